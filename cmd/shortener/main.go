@@ -3,11 +3,19 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/sandor-clegane/urlshortener/internal/app"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == migrateCommand {
+		if err := migrate(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	h, err := app.New()
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/migration"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+const migrateCommand = "migrate-data"
+
+//migrate переносит ссылки между хранилищами:
+//shortener migrate-data --from file:///old.json --to postgres://...
+func migrate(args []string) error {
+	fs := flag.NewFlagSet(migrateCommand, flag.ExitOnError)
+	from := fs.String("from", "", "source storage URL (file:///path, postgres://...)")
+	to := fs.String("to", "", "destination storage URL (file:///path, postgres://...)")
+	batchSize := fs.Int("batch-size", 500, "number of links copied in one batch")
+	checkpointPath := fs.String("checkpoint", "migrate-data.checkpoint",
+		"file storing migration progress, empty to disable resuming")
+	sampleSize := fs.Int("sample", 100, "number of links checked by lookup after copying")
+	verifyOnly := fs.Bool("verify-only", false, "skip copying and only verify destination")
+	fs.Parse(args)
+
+	if *from == "" || *to == "" {
+		fs.Usage()
+		return errors.New("both --from and --to are required")
+	}
+	if *batchSize <= 0 {
+		return errors.New("--batch-size must be positive")
+	}
+
+	//настройки пула соединений берутся из окружения, как и у сервера
+	var cfg config.Config
+	if err := env.Parse(&cfg); err != nil {
+		return err
+	}
+
+	src, srcCloser, err := storages.OpenStorage(*from, cfg)
+	if err != nil {
+		return fmt.Errorf("open source: %w", err)
+	}
+	defer srcCloser.Close()
+	dst, dstCloser, err := storages.OpenStorage(*to, cfg)
+	if err != nil {
+		return fmt.Errorf("open destination: %w", err)
+	}
+	defer dstCloser.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m := migration.New(src, dst, migration.Options{
+		BatchSize:      *batchSize,
+		CheckpointPath: *checkpointPath,
+		SampleSize:     *sampleSize,
+	})
+	if !*verifyOnly {
+		copied, err := m.Run(ctx)
+		if err != nil {
+			return fmt.Errorf("migration stopped after %d links: %w", copied, err)
+		}
+		log.Printf("migration finished: %d links copied", copied)
+	}
+
+	report, err := m.Verify(ctx)
+	log.Printf("verification: source %d links, destination %d links, %d sampled, %d mismatches",
+		report.SourceCount, report.DestinationCount, report.Sampled, len(report.Mismatches))
+	for _, mismatch := range report.Mismatches {
+		log.Printf("mismatch: %s", mismatch)
+	}
+	return err
+}
@@ -3,12 +3,16 @@ module github.com/sandor-clegane/urlshortener
 go 1.16
 
 require (
-	github.com/caarlos0/env/v6 v6.10.1 // indirect
-	github.com/go-chi/chi v1.5.4 // indirect
+	github.com/caarlos0/env/v6 v6.10.1
+	github.com/go-chi/chi v1.5.4
 	github.com/go-resty/resty/v2 v2.7.0 // indirect
+	github.com/golang-jwt/jwt/v4 v4.4.3
 	github.com/golang/mock v1.6.0 // indirect
 	github.com/google/uuid v1.3.0
-	github.com/lib/pq v1.10.7 // indirect
+	github.com/lib/pq v1.10.7
 	github.com/sirupsen/logrus v1.9.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/stretchr/testify v1.8.1
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
+	golang.org/x/net v0.0.0-20211112202133-69e39bad7dc2
 )
@@ -5,6 +5,9 @@ import (
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sandor-clegane/urlshortener/internal/common"
 )
 
 type gzipWriter struct {
@@ -77,3 +80,36 @@ func GzipDecompressHandle(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+const (
+	requestIDHeader    = "X-Request-ID"
+	maxRequestIDLength = 64
+)
+
+//validRequestID принимает идентификаторы, которые безопасно писать в журнал
+//и возвращать в заголовке ответа
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+//RequestIDHandle middleware обработчик сохраняет в контексте идентификатор
+//запроса из заголовка X-Request-ID или создаёт новый и возвращает его клиенту
+func RequestIDHandle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(common.WithRequestID(r.Context(), id)))
+	})
+}
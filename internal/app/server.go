@@ -1,21 +1,37 @@
 package app
 
 import (
+	"context"
+	"io"
+	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 	"github.com/go-chi/chi"
+	"github.com/sandor-clegane/urlshortener/internal/common"
 	"github.com/sandor-clegane/urlshortener/internal/config"
-	"github.com/sandor-clegane/urlshortener/internal/handlers/db"
+	"github.com/sandor-clegane/urlshortener/internal/handlers/admin"
+	"github.com/sandor-clegane/urlshortener/internal/handlers/health"
+	"github.com/sandor-clegane/urlshortener/internal/handlers/stats"
 	"github.com/sandor-clegane/urlshortener/internal/handlers/url"
+	"github.com/sandor-clegane/urlshortener/internal/service/policy"
 	"github.com/sandor-clegane/urlshortener/internal/storages"
 )
 
 type App struct {
 	*chi.Mux
 	Cfg  config.Config
-	dbh  db.DBHandler
+	pool *storages.DBPool
+	stg  storages.Storage
+	pe   policy.PolicyEngine
+	hh   health.HealthHandler
 	urlh url.URLHandler
+	adm  admin.AdminHandler
+	sh   stats.StatsHandler
 }
 
 func New() (*App, error) {
@@ -52,28 +68,159 @@ func (h *App) initConfig() error {
 
 //TODO паттерны стоит вынести в константы
 func (h *App) initHandlers() error {
-	stg, err := storages.CreateStorage(h.Cfg)
+	var err error
+	if h.Cfg.DatabaseDSN != config.DefaultDatabaseDSN {
+		h.pool, err = storages.OpenDBPool(h.Cfg)
+		if err != nil {
+			return err
+		}
+	}
+	h.stg, err = storages.CreateStorage(h.Cfg, h.pool)
+	if err != nil {
+		return err
+	}
+	h.hh = health.New(h.healthComponents(h.stg)...)
+	h.pe, err = policy.New(h.Cfg)
+	if err != nil {
+		return err
+	}
+	h.urlh, err = url.New(h.stg, h.pe, h.Cfg)
 	if err != nil {
 		return err
 	}
-	h.dbh, err = db.NewDBHandler(h.Cfg.DatabaseDSN)
+	h.adm = admin.New(h.stg, h.Cfg)
+	h.sh, err = stats.New(h.stg, h.Cfg)
 	if err != nil {
 		return err
 	}
-	h.urlh = url.New(stg, h.Cfg)
 
-	h.Use(GzipCompressHandle, GzipDecompressHandle, h.urlh.GetAuthorizationMiddleware())
+	h.Use(RequestIDHandle)
+
+	h.Get("/healthz", h.hh.Liveness)
+	h.Get("/readyz", h.hh.Readiness)
+	//QR-коды кэшируются клиентами, поэтому отдаются без выдачи cookie
+	h.With(GzipCompressHandle).Get("/{id}/qr", h.urlh.GetQRCode)
+	h.With(h.sh.TrustedSubnet).Get("/api/internal/stats", h.sh.GetStats)
+	//административный API авторизуется токеном и не выдаёт cookie
+	h.Route("/api/admin", func(r chi.Router) {
+		r.Use(GzipCompressHandle, h.adm.Authentication)
+		r.Get("/audit", h.adm.GetAudit)
+		r.Get("/links", h.adm.SearchLinks)
+		r.Get("/links/{id}", h.adm.GetLink)
+		r.Post("/links/{id}/disable", h.adm.DisableLink)
+		r.Post("/links/{id}/enable", h.adm.EnableLink)
+		r.Post("/domains/disable", h.adm.DisableDomain)
+	})
+
+	h.Group(func(r chi.Router) {
+		r.Use(GzipCompressHandle, GzipDecompressHandle, h.urlh.GetAuthorizationMiddleware())
 
-	h.Post("/", h.urlh.ShortenURL)
-	h.Post("/api/shorten", h.urlh.ShortenURLwJSON)
-	h.Post("/api/shorten/batch", h.urlh.ShortenSomeURL)
+		shorten := h.urlh.GetScopeMiddleware(common.ScopeShorten)
+		read := h.urlh.GetScopeMiddleware(common.ScopeRead)
 
-	h.Get("/ping", h.dbh.PingConnectionDB)
-	h.Get("/{id}", h.urlh.ExpandURL)
-	h.Get("/api/user/urls", h.urlh.GetAllURL)
+		r.With(shorten, h.urlh.GetRateLimitMiddleware("/")).
+			Post("/", h.urlh.ShortenURL)
+		r.With(shorten, h.urlh.GetRateLimitMiddleware("/api/shorten")).
+			Post("/api/shorten", h.urlh.ShortenURLwJSON)
+		r.With(shorten, h.urlh.GetRateLimitMiddleware("/api/shorten/batch")).
+			Post("/api/shorten/batch", h.urlh.ShortenSomeURL)
+
+		r.Get("/ping", h.hh.Ping)
+		r.Get("/{id}", h.urlh.ExpandURL)
+		r.Get("/{id}+", h.urlh.PreviewURL)
+		r.With(h.urlh.GetRateLimitMiddleware("/{id}")).
+			Post("/{id}", h.urlh.UnlockURL)
+		r.With(read).Get("/api/user/urls", h.urlh.GetAllURL)
+		r.With(read).Get("/api/user/urls/{id}/rules", h.urlh.GetRules)
+		r.With(shorten).Put("/api/user/urls/{id}/rules", h.urlh.SetRules)
+		r.With(read).Get("/api/user/urls/{id}/variants", h.urlh.GetVariants)
+		r.With(read).Get("/api/user/urls/{id}/utm", h.urlh.GetUTM)
+		r.With(shorten).Put("/api/user/urls/{id}/utm", h.urlh.SetUTM)
+		r.With(read).Get("/api/user/utm", h.urlh.GetUserUTM)
+		r.With(shorten).Put("/api/user/utm", h.urlh.SetUserUTM)
+		r.Get("/api/user/keys", h.urlh.GetAPIKeys)
+		r.Post("/api/user/keys", h.urlh.CreateAPIKey)
+		r.Delete("/api/user/keys/{id}", h.urlh.RevokeAPIKey)
+		r.With(read).Get("/api/workspaces", h.urlh.GetWorkspaces)
+		r.Post("/api/workspaces", h.urlh.CreateWorkspace)
+		r.With(read).Get("/api/workspaces/{id}/members", h.urlh.GetMembers)
+		r.Post("/api/workspaces/{id}/members", h.urlh.SetMember)
+		r.Delete("/api/workspaces/{id}/members/{userID}", h.urlh.RemoveMember)
+		r.With(h.urlh.GetRateLimitMiddleware("/api/user/register")).
+			Post("/api/user/register", h.urlh.Register)
+		r.With(h.urlh.GetRateLimitMiddleware("/api/user/login")).
+			Post("/api/user/login", h.urlh.Login)
+	})
 	return nil
 }
 
+func (h *App) healthComponents(stg storages.Storage) []health.Component {
+	components := []health.Component{
+		{Name: "storage", Check: stg.HealthCheck},
+	}
+	if rs, ok := stg.(*storages.ReplicatedStorage); ok {
+		for _, m := range rs.Mirrors() {
+			components = append(components, health.Component{
+				Name:     "mirror:" + m.Name,
+				Check:    m.HealthCheck,
+				Details:  m.Status,
+				Optional: true,
+			})
+		}
+	}
+	if h.pool != nil && h.pool.Replica != nil {
+		components = append(components, health.Component{
+			Name:     "replica",
+			Check:    h.pool.PingReplica,
+			Optional: true,
+		})
+	}
+	return components
+}
+
+//Run запускает сервер и при получении SIGINT/SIGTERM снимает готовность,
+//ждёт ShutdownDrainDelay, продолжая обслуживать запросы, затем дожидается
+//завершения текущих запросов и закрывает соединения с базой
 func (h *App) Run() error {
-	return http.ListenAndServe(h.Cfg.ServerAddress, h)
+	srv := &http.Server{Addr: h.Cfg.ServerAddress, Handler: h}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+		<-sigs
+
+		h.hh.SetShuttingDown()
+		if h.Cfg.ShutdownDrainDelay > 0 {
+			log.Printf("shutting down, draining for %s", h.Cfg.ShutdownDrainDelay)
+			time.Sleep(h.Cfg.ShutdownDrainDelay)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), h.Cfg.ShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("HTTP server shutdown: %v", err)
+		}
+	}()
+
+	err := srv.ListenAndServe()
+	if err == http.ErrServerClosed {
+		<-stopped
+		h.close()
+	}
+	return err
+}
+
+func (h *App) close() {
+	h.pe.Close()
+	if c, ok := h.stg.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("unable to close storage: %v", err)
+		}
+	}
+	if h.pool != nil {
+		if err := h.pool.Close(); err != nil {
+			log.Printf("unable to close DB pool: %v", err)
+		}
+	}
 }
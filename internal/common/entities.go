@@ -2,9 +2,346 @@ package common
 
 import (
 	"encoding/json"
+	"fmt"
 	url2 "net/url"
+	"strings"
+	"time"
 )
 
+//Link сокращённая ссылка вместе с владельцем. ID хранится без ведущего "/".
+type Link struct {
+	ID        string `json:"id"`
+	ExpandURL string `json:"original_url"`
+	UserID    string `json:"user_id"`
+	//PasswordHash bcrypt-хеш пароля, пустой у ссылок без пароля.
+	//Не должен попадать в ответы API.
+	PasswordHash string `json:"-"`
+	//ClickLimit число переходов, после которого ссылка перестаёт работать,
+	//0 означает отсутствие ограничения
+	ClickLimit int `json:"click_limit,omitempty"`
+	ClicksLeft int `json:"clicks_left,omitempty"`
+	//Rules правила выбора адреса перенаправления, проверяются по порядку.
+	//Если ни одно не подошло, используется ExpandURL.
+	Rules []RedirectRule `json:"rules,omitempty"`
+	//Variants адреса A/B-теста, между которыми по весам распределяются
+	//переходы, не подошедшие ни под одно правило
+	Variants []Variant `json:"variants,omitempty"`
+	//UTM метки, добавляемые к адресу перенаправления поверх меток владельца
+	UTM UTM `json:"utm"`
+	//Preview сведения о странице назначения, загруженные при создании ссылки
+	Preview Preview `json:"preview"`
+	//WorkspaceID рабочее пространство, которому принадлежит ссылка,
+	//пустое у личных ссылок UserID
+	WorkspaceID string `json:"workspace_id,omitempty"`
+	//Disabled блокировка ссылки администратором, nil у работающих ссылок
+	Disabled *LinkBlock `json:"disabled,omitempty"`
+}
+
+//LinkBlock причина блокировки ссылки администратором
+type LinkBlock struct {
+	//Legal блокировка по требованию закона: переход по ссылке отвечает
+	//451 Unavailable For Legal Reasons вместо 410 Gone
+	Legal  bool      `json:"legal,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+//Preview OpenGraph-описание страницы назначения
+type Preview struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Image       string `json:"image,omitempty"`
+}
+
+//IsEmpty сообщает, что о странице ничего не известно
+func (p Preview) IsEmpty() bool {
+	return p == Preview{}
+}
+
+//UTM метки кампании, пустые значения не добавляются
+type UTM struct {
+	Source   string `json:"source,omitempty"`
+	Medium   string `json:"medium,omitempty"`
+	Campaign string `json:"campaign,omitempty"`
+	Term     string `json:"term,omitempty"`
+	Content  string `json:"content,omitempty"`
+}
+
+//IsEmpty сообщает, что ни одна метка не задана
+func (u UTM) IsEmpty() bool {
+	return u == UTM{}
+}
+
+//Merge дополняет незаданные метки значениями из defaults
+func (u UTM) Merge(defaults UTM) UTM {
+	pick := func(value, def string) string {
+		if value != "" {
+			return value
+		}
+		return def
+	}
+	return UTM{
+		Source:   pick(u.Source, defaults.Source),
+		Medium:   pick(u.Medium, defaults.Medium),
+		Campaign: pick(u.Campaign, defaults.Campaign),
+		Term:     pick(u.Term, defaults.Term),
+		Content:  pick(u.Content, defaults.Content),
+	}
+}
+
+//Variant вариант A/B-теста
+type Variant struct {
+	Name   string `json:"name"`
+	Target string `json:"target"`
+	Weight int    `json:"weight"`
+	Clicks int64  `json:"clicks"`
+}
+
+//Redirect результат перехода по ссылке: адрес и выбранный вариант A/B-теста
+type Redirect struct {
+	URL     string
+	Variant string
+}
+
+//RedirectRule правило выбора адреса перенаправления. Заданные условия
+//должны выполняться одновременно.
+type RedirectRule struct {
+	//Platforms операционные системы клиента: ios, android, windows, macos, linux
+	Platforms []string `json:"platforms,omitempty"`
+	//UserAgent подстрока заголовка User-Agent без учёта регистра
+	UserAgent string `json:"user_agent,omitempty"`
+	//Languages языки из Accept-Language, "en" подходит и для "en-US"
+	Languages []string `json:"languages,omitempty"`
+	//Query параметры запроса, пустое значение требует только наличия параметра
+	Query  map[string]string `json:"query,omitempty"`
+	Target string            `json:"target"`
+}
+
+//ClientInfo сведения о запросе перехода, по которым выбирается адрес
+type ClientInfo struct {
+	UserAgent      string
+	AcceptLanguage string
+	Query          url2.Values
+	//Variant вариант A/B-теста, ранее назначенный посетителю
+	Variant string
+}
+
+//Restricted сообщает, что переход по ссылке требует отдельной обработки:
+//такую ссылку нельзя разворачивать внутри цепочки других ссылок
+func (l Link) Restricted() bool {
+	return l.PasswordHash != "" || l.ClickLimit > 0 || l.Disabled != nil ||
+		len(l.Rules) > 0 || len(l.Variants) > 0 || !l.UTM.IsEmpty()
+}
+
+//Exhausted сообщает, что переходы по ссылке с ограничением закончились
+func (l Link) Exhausted() bool {
+	return l.ClickLimit > 0 && l.ClicksLeft <= 0
+}
+
+//LinkOptions дополнительные параметры создаваемой ссылки
+type LinkOptions struct {
+	Password string `json:"password,omitempty"`
+	//MaxClicks число переходов до самоуничтожения ссылки, 1 для одноразовой
+	MaxClicks int            `json:"max_clicks,omitempty"`
+	Rules     []RedirectRule `json:"rules,omitempty"`
+	Variants  []Variant      `json:"variants,omitempty"`
+	UTM       UTM            `json:"utm"`
+	//WorkspaceID рабочее пространство, в котором создаётся ссылка,
+	//задаётся параметром запроса workspace
+	WorkspaceID string `json:"-"`
+}
+
+//IsEmpty сообщает, что ссылка создаётся без дополнительных параметров
+func (o LinkOptions) IsEmpty() bool {
+	return o.Password == "" && o.MaxClicks == 0 &&
+		len(o.Rules) == 0 && len(o.Variants) == 0 && o.UTM.IsEmpty() &&
+		o.WorkspaceID == ""
+}
+
+//Account зарегистрированный пользователь. ID используется как
+//идентификатор пользователя во всех остальных сущностях.
+type Account struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	//PasswordHash bcrypt-хеш пароля, не должен попадать в ответы API
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+//Области действия API-ключей
+const (
+	ScopeShorten = "shorten"
+	ScopeRead    = "read"
+)
+
+//APIKey ключ доступа к API от имени пользователя. Сам ключ не хранится,
+//только его SHA-256 хеш.
+type APIKey struct {
+	ID     string `json:"id"`
+	UserID string `json:"-"`
+	Name   string `json:"name,omitempty"`
+	//Prefix начало ключа, по которому владелец узнаёт его в списке
+	Prefix    string    `json:"prefix"`
+	Hash      string    `json:"-"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+//HasScope сообщает, разрешено ли ключу действие scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//Role роль участника рабочего пространства. Каждая следующая роль
+//разрешает всё, что разрешают предыдущие: viewer видит ссылки,
+//editor создаёт и меняет их, owner управляет участниками.
+type Role string
+
+const (
+	RoleViewer Role = "viewer"
+	RoleEditor Role = "editor"
+	RoleOwner  Role = "owner"
+)
+
+var roleRanks = map[Role]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+//Valid сообщает, что роль известна
+func (r Role) Valid() bool {
+	return roleRanks[r] > 0
+}
+
+//Includes сообщает, что роль разрешает всё, что разрешает роль other
+func (r Role) Includes(other Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[other]
+}
+
+//Workspace рабочее пространство, ссылками которого совместно
+//управляют его участники
+type Workspace struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+//Member участие пользователя в рабочем пространстве
+type Member struct {
+	WorkspaceID string `json:"workspace_id"`
+	UserID      string `json:"user_id"`
+	Role        Role   `json:"role"`
+}
+
+//UserWorkspace рабочее пространство вместе с ролью в нём пользователя
+type UserWorkspace struct {
+	Workspace
+	Role Role `json:"role"`
+}
+
+//Действия журнала аудита
+const (
+	AuditCreate      = "create"
+	AuditUpdateRules = "update_rules"
+	AuditUpdateUTM   = "update_utm"
+	//AuditReassign смена владельца ссылки, например при входе в учётную запись
+	AuditReassign = "reassign"
+	AuditDisable  = "disable"
+	AuditEnable   = "enable"
+)
+
+//AuditRecord запись журнала аудита об изменении ссылки. Журнал только
+//дополняется, записи не меняются и не удаляются.
+type AuditRecord struct {
+	ID        int64           `json:"id"`
+	Time      time.Time       `json:"time"`
+	ActorID   string          `json:"actor_id"`
+	Action    string          `json:"action"`
+	LinkID    string          `json:"link_id"`
+	OldValue  json.RawMessage `json:"old_value,omitempty"`
+	NewValue  json.RawMessage `json:"new_value,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+}
+
+//AuditFilter условия выборки из журнала аудита, пустые поля не ограничивают её
+type AuditFilter struct {
+	LinkID  string
+	ActorID string
+	Action  string
+	Since   time.Time
+	Until   time.Time
+	//BeforeID позволяет листать журнал: выбираются записи с меньшим ID
+	BeforeID int64
+	Limit    int
+}
+
+//Match сообщает, что запись удовлетворяет условиям, кроме Limit
+func (f AuditFilter) Match(r AuditRecord) bool {
+	return (f.LinkID == "" || r.LinkID == f.LinkID) &&
+		(f.ActorID == "" || r.ActorID == f.ActorID) &&
+		(f.Action == "" || r.Action == f.Action) &&
+		(f.Since.IsZero() || !r.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || r.Time.Before(f.Until)) &&
+		(f.BeforeID == 0 || r.ID < f.BeforeID)
+}
+
+//LinkFilter условия поиска ссылок администратором, пустые поля не ограничивают его
+type LinkFilter struct {
+	//Query подстрока ID или адреса назначения без учёта регистра
+	Query       string
+	UserID      string
+	WorkspaceID string
+	//Domain домен адреса назначения вместе с поддоменами
+	Domain string
+	//Disabled оставляет только заблокированные ссылки
+	Disabled bool
+	//AfterID позволяет листать результаты: выбираются ссылки с большим ID
+	AfterID string
+	Limit   int
+}
+
+//Match сообщает, что ссылка удовлетворяет условиям, кроме AfterID и Limit
+func (f LinkFilter) Match(l Link) bool {
+	query := strings.ToLower(f.Query)
+	return (query == "" || strings.Contains(strings.ToLower(l.ID), query) ||
+		strings.Contains(strings.ToLower(l.ExpandURL), query)) &&
+		(f.UserID == "" || l.UserID == f.UserID) &&
+		(f.WorkspaceID == "" || l.WorkspaceID == f.WorkspaceID) &&
+		(f.Domain == "" || InDomain(l.ExpandURL, f.Domain)) &&
+		(!f.Disabled || l.Disabled != nil)
+}
+
+//LinkDetails ссылка вместе с владельцем и статистикой для администратора
+type LinkDetails struct {
+	Link  Link      `json:"link"`
+	Owner LinkOwner `json:"owner"`
+	Stats LinkStats `json:"stats"`
+}
+
+type LinkOwner struct {
+	UserID string `json:"user_id"`
+	//Email задан, если владелец — зарегистрированная учётная запись
+	Email     string     `json:"email,omitempty"`
+	Workspace *Workspace `json:"workspace,omitempty"`
+}
+
+type LinkStats struct {
+	//ClicksUsed переходы, засчитанные ограничением ссылки
+	ClicksUsed int `json:"clicks_used,omitempty"`
+	ClicksLeft int `json:"clicks_left,omitempty"`
+	//VariantClicks переходы по всем вариантам A/B-теста
+	VariantClicks int64 `json:"variant_clicks,omitempty"`
+	HasPassword   bool  `json:"has_password,omitempty"`
+}
+
+//Stats общая статистика сервиса
+type Stats struct {
+	URLs  int `json:"urls"`
+	Users int `json:"users"`
+}
+
 type PairURL struct {
 	ShortURL  string `json:"short_url"`
 	ExpandURL string `json:"original_url"`
@@ -12,6 +349,7 @@ type PairURL struct {
 
 type InMessage struct {
 	ExpandURL url2.URL `json:"url"`
+	LinkOptions
 }
 
 type OutMessage struct {
@@ -30,9 +368,14 @@ func (im *InMessage) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return err
 	}
+	//полная проверка выполняется сервисом, здесь отсекаем заведомо
+	//непригодные значения: пустые строки и относительные пути
+	if !url.IsAbs() || url.Host == "" {
+		return fmt.Errorf("url %q must be absolute", aliasValue.RawURL)
+	}
 
 	im.ExpandURL = *url
-	return nil
+	return json.Unmarshal(data, &im.LinkOptions)
 }
 
 type PairURLwithCIDin struct {
@@ -0,0 +1,74 @@
+package common
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrUnauthenticated = errors.New("request is not authenticated")
+
+type userIDKey struct{}
+
+//WithUserID сохраняет в контексте идентификатор пользователя,
+//подтверждённый middleware авторизации
+func WithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDKey{}, userID)
+}
+
+//UserID возвращает идентификатор пользователя запроса,
+//ErrUnauthenticated если запрос не прошёл авторизацию
+func UserID(ctx context.Context) (string, error) {
+	userID, ok := ctx.Value(userIDKey{}).(string)
+	if !ok || userID == "" {
+		return "", ErrUnauthenticated
+	}
+	return userID, nil
+}
+
+type apiKeyKey struct{}
+
+//WithAPIKey авторизует запрос от имени владельца API-ключа
+//с ограничением областями действия ключа
+func WithAPIKey(ctx context.Context, key APIKey) context.Context {
+	return context.WithValue(WithUserID(ctx, key.UserID), apiKeyKey{}, key)
+}
+
+//RequestAPIKey возвращает API-ключ, которым авторизован запрос
+func RequestAPIKey(ctx context.Context) (APIKey, bool) {
+	key, ok := ctx.Value(apiKeyKey{}).(APIKey)
+	return key, ok
+}
+
+//Authorized сообщает, разрешено ли запросу действие scope: сессиям
+//пользователей разрешено всё, запросам с API-ключом — только области ключа
+func Authorized(ctx context.Context, scope string) bool {
+	key, ok := RequestAPIKey(ctx)
+	return !ok || key.HasScope(scope)
+}
+
+type requestIDKey struct{}
+
+//WithRequestID сохраняет в контексте идентификатор запроса
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+//RequestID возвращает идентификатор запроса, пустой вне HTTP-запроса
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+type sessionKey struct{}
+
+//WithSession авторизует запрос по cookie сессии. Только такого
+//пользователя можно объединить с учётной записью при входе.
+func WithSession(ctx context.Context, userID string) context.Context {
+	return context.WithValue(WithUserID(ctx, userID), sessionKey{}, userID)
+}
+
+//SessionUserID возвращает пользователя cookie сессии запроса
+func SessionUserID(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(sessionKey{}).(string)
+	return userID, ok && userID != ""
+}
@@ -0,0 +1,24 @@
+package myerrors
+
+import "fmt"
+
+//Forbidden пользователь состоит в рабочем пространстве, но его роль
+//не позволяет действие
+type Forbidden struct {
+	WorkspaceID string
+	Role        string
+	Required    string
+}
+
+func (f Forbidden) Error() string {
+	return fmt.Sprintf("role %s in workspace %s is not allowed to do this, %s required",
+		f.Role, f.WorkspaceID, f.Required)
+}
+
+func NewForbidden(workspaceID, role, required string) error {
+	return &Forbidden{
+		WorkspaceID: workspaceID,
+		Role:        role,
+		Required:    required,
+	}
+}
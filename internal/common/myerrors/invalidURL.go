@@ -0,0 +1,19 @@
+package myerrors
+
+import "fmt"
+
+type InvalidURL struct {
+	URL    string
+	Reason string
+}
+
+func (iu InvalidURL) Error() string {
+	return fmt.Sprintf("invalid URL %q: %s", iu.URL, iu.Reason)
+}
+
+func NewInvalidURL(url, reason string) error {
+	return &InvalidURL{
+		URL:    url,
+		Reason: reason,
+	}
+}
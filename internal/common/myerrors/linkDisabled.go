@@ -0,0 +1,21 @@
+package myerrors
+
+import "fmt"
+
+//LinkDisabled ссылка заблокирована администратором
+type LinkDisabled struct {
+	URL string
+	//Legal блокировка по требованию закона
+	Legal bool
+}
+
+func (ld LinkDisabled) Error() string {
+	if ld.Legal {
+		return fmt.Sprintf("short URL %s is unavailable for legal reasons", ld.URL)
+	}
+	return fmt.Sprintf("short URL %s has been disabled", ld.URL)
+}
+
+func NewLinkDisabled(url string, legal bool) error {
+	return &LinkDisabled{URL: url, Legal: legal}
+}
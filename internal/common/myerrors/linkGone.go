@@ -0,0 +1,16 @@
+package myerrors
+
+import "fmt"
+
+//LinkGone ссылка больше не работает: переходы по ней закончились
+type LinkGone struct {
+	URL string
+}
+
+func (lg LinkGone) Error() string {
+	return fmt.Sprintf("short URL %s is no longer available", lg.URL)
+}
+
+func NewLinkGone(url string) error {
+	return &LinkGone{URL: url}
+}
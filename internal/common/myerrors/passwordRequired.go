@@ -0,0 +1,30 @@
+package myerrors
+
+import "fmt"
+
+//PasswordRequired ссылка защищена паролем, перенаправление возможно только
+//после его ввода
+type PasswordRequired struct {
+	URL string
+}
+
+func (pr PasswordRequired) Error() string {
+	return fmt.Sprintf("short URL %s is protected by a password", pr.URL)
+}
+
+func NewPasswordRequired(url string) error {
+	return &PasswordRequired{URL: url}
+}
+
+//InvalidPassword введён неверный пароль к ссылке
+type InvalidPassword struct {
+	URL string
+}
+
+func (ip InvalidPassword) Error() string {
+	return fmt.Sprintf("invalid password for short URL %s", ip.URL)
+}
+
+func NewInvalidPassword(url string) error {
+	return &InvalidPassword{URL: url}
+}
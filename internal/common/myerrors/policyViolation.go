@@ -0,0 +1,19 @@
+package myerrors
+
+import "fmt"
+
+type PolicyViolation struct {
+	URL  string
+	Rule string
+}
+
+func (pv PolicyViolation) Error() string {
+	return fmt.Sprintf("URL %s is blocked by policy rule %q", pv.URL, pv.Rule)
+}
+
+func NewPolicyViolation(url, rule string) error {
+	return &PolicyViolation{
+		URL:  url,
+		Rule: rule,
+	}
+}
@@ -0,0 +1,19 @@
+package myerrors
+
+import "fmt"
+
+type QuotaExceeded struct {
+	UserID string
+	Limit  int
+}
+
+func (qe QuotaExceeded) Error() string {
+	return fmt.Sprintf("user %s reached the limit of %d links", qe.UserID, qe.Limit)
+}
+
+func NewQuotaExceeded(userID string, limit int) error {
+	return &QuotaExceeded{
+		UserID: userID,
+		Limit:  limit,
+	}
+}
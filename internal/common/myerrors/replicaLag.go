@@ -0,0 +1,22 @@
+package myerrors
+
+import (
+	"fmt"
+	"time"
+)
+
+type ReplicaLag struct {
+	Lag    time.Duration
+	MaxLag time.Duration
+}
+
+func (rl ReplicaLag) Error() string {
+	return fmt.Sprintf("read replica lags behind by %s (max %s)", rl.Lag, rl.MaxLag)
+}
+
+func NewReplicaLag(lag, maxLag time.Duration) error {
+	return &ReplicaLag{
+		Lag:    lag,
+		MaxLag: maxLag,
+	}
+}
@@ -0,0 +1,29 @@
+package myerrors
+
+import "fmt"
+
+//SelfReference целевой URL указывает на сам сервис сокращения ссылок
+type SelfReference struct {
+	URL string
+}
+
+func (sr SelfReference) Error() string {
+	return fmt.Sprintf("URL %s points to this shortener", sr.URL)
+}
+
+func NewSelfReference(url string) error {
+	return &SelfReference{URL: url}
+}
+
+//RedirectLoop цепочка коротких ссылок замыкается сама на себя
+type RedirectLoop struct {
+	URL string
+}
+
+func (rl RedirectLoop) Error() string {
+	return fmt.Sprintf("redirect loop detected at %s", rl.URL)
+}
+
+func NewRedirectLoop(url string) error {
+	return &RedirectLoop{URL: url}
+}
@@ -3,6 +3,7 @@ package common
 import (
 	"net/url"
 	"path"
+	"strings"
 )
 
 func Join(basePath string, paths ...string) (*url.URL, error) {
@@ -17,3 +18,14 @@ func Join(basePath string, paths ...string) (*url.URL, error) {
 
 	return u, nil
 }
+
+//InDomain сообщает, что хост адреса rawURL совпадает с domain или является
+//его поддоменом. domain должен быть в нижнем регистре.
+func InDomain(rawURL, domain string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -1,21 +1,105 @@
 package config
 
-import "flag"
+import (
+	"flag"
+	"time"
+)
 
 const (
-	DefaultServerAddress   = "localhost:8080"
-	DefaultBaseURL         = "http://localhost:8080/"
-	DefaultFileStoragePath = ""
-	DefaultKey             = "SuperSecretKey2022"
-	DefaultDatabaseDSN     = "user=pqgotest dbname=pqgotest sslmode=verify-full"
+	DefaultServerAddress      = "localhost:8080"
+	DefaultBaseURL            = "http://localhost:8080/"
+	DefaultFileStoragePath    = ""
+	DefaultKey                = ""
+	DefaultDatabaseDSN        = "user=pqgotest dbname=pqgotest sslmode=verify-full"
+	DefaultStorageTimeout     = 3 * time.Second
+	DefaultDatabaseReplicaDSN = ""
+	DefaultTrustedSubnet      = ""
 )
 
 type Config struct {
-	ServerAddress   string `env:"SERVER_ADDRESS" envDefault:"localhost:8080"`
-	BaseURL         string `env:"BASE_URL"       envDefault:"http://localhost:8080/"`
-	FileStoragePath string `env:"FILE_STORAGE_PATH" envDefault:""`
-	Key             string `env:"SECRET_KEY" envDefault:"SuperSecretKey2022"`
-	DatabaseDSN     string `env:"DATABASE_DSN" envDefault:"user=pqgotest dbname=pqgotest sslmode=verify-full"`
+	ServerAddress   string        `env:"SERVER_ADDRESS" envDefault:"localhost:8080"`
+	BaseURL         string        `env:"BASE_URL"       envDefault:"http://localhost:8080/"`
+	FileStoragePath string        `env:"FILE_STORAGE_PATH" envDefault:""`
+	Key             string        `env:"SECRET_KEY" envDefault:""`
+	DatabaseDSN     string        `env:"DATABASE_DSN" envDefault:"user=pqgotest dbname=pqgotest sslmode=verify-full"`
+	StorageTimeout  time.Duration `env:"STORAGE_TIMEOUT" envDefault:"3s"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"10s"`
+	//ShutdownDrainDelay сколько после сигнала остановки сервер продолжает
+	//принимать запросы с /readyz в состоянии 503, чтобы балансировщик
+	//успел вывести его из ротации
+	ShutdownDrainDelay time.Duration `env:"SHUTDOWN_DRAIN_DELAY" envDefault:"5s"`
+
+	//SecretKeys ключи подписи cookie: первый подписывает новые cookie, остальные
+	//только проверяют, чтобы смена ключа не сбрасывала сессии. Если не заданы,
+	//используется Key.
+	SecretKeys     []string      `env:"SECRET_KEYS" envSeparator:","`
+	CookieMaxAge   time.Duration `env:"COOKIE_MAX_AGE" envDefault:"720h"`
+	CookieSecure   bool          `env:"COOKIE_SECURE" envDefault:"false"`
+	CookieSameSite string        `env:"COOKIE_SAME_SITE" envDefault:"lax"`
+	CookieDomain   string        `env:"COOKIE_DOMAIN" envDefault:""`
+	//LegacyCookiesUntil время в формате RFC 3339, до которого принимаются
+	//cookie прежнего формата без срока действия. Если не задано, такие
+	//cookie не принимаются.
+	LegacyCookiesUntil string `env:"LEGACY_COOKIES_UNTIL" envDefault:""`
+
+	//JWTAuth принимать ли заголовок Authorization: Bearer. HS256 проверяется
+	//ключами JWTHMACKeys и отключён, пока они не заданы, RS256 и ES256 —
+	//ключами из файла JWKSPath. Несколько ключей HS256 через запятую
+	//принимаются на время их смены.
+	JWTAuth      bool     `env:"JWT_AUTH" envDefault:"false"`
+	JWTHMACKeys  []string `env:"JWT_HMAC_KEY" envSeparator:","`
+	JWKSPath     string   `env:"JWKS_PATH" envDefault:""`
+	JWTIssuer    string   `env:"JWT_ISSUER" envDefault:""`
+	JWTAudience  string   `env:"JWT_AUDIENCE" envDefault:""`
+	JWTUserClaim string   `env:"JWT_USER_CLAIM" envDefault:"sub"`
+
+	DatabaseReplicaDSN string        `env:"DATABASE_REPLICA_DSN" envDefault:""`
+	DBMaxOpenConns     int           `env:"DB_MAX_OPEN_CONNS" envDefault:"25"`
+	DBMaxIdleConns     int           `env:"DB_MAX_IDLE_CONNS" envDefault:"25"`
+	DBConnMaxLifetime  time.Duration `env:"DB_CONN_MAX_LIFETIME" envDefault:"30m"`
+	DBConnMaxIdleTime  time.Duration `env:"DB_CONN_MAX_IDLE_TIME" envDefault:"5m"`
+	DBReplicaMaxLag    time.Duration `env:"DB_REPLICA_MAX_LAG" envDefault:"10s"`
+
+	AllowedURLSchemes []string `env:"ALLOWED_URL_SCHEMES" envDefault:"http,https" envSeparator:","`
+	MaxURLLength      int      `env:"MAX_URL_LENGTH" envDefault:"2048"`
+	SortQueryParams   bool     `env:"SORT_QUERY_PARAMS" envDefault:"false"`
+
+	BaseURLAliases []string `env:"BASE_URL_ALIASES" envSeparator:","`
+	SelfLinkPolicy string   `env:"SELF_LINK_POLICY" envDefault:"resolve"`
+
+	RateLimitsByUser string `env:"RATE_LIMITS_BY_USER" envDefault:"/=60/1m,/api/shorten=60/1m,/api/shorten/batch=10/1m"`
+	RateLimitsByIP   string `env:"RATE_LIMITS_BY_IP" envDefault:"/=300/1m,/api/shorten=300/1m,/api/shorten/batch=50/1m,/{id}=10/1m,/api/user/register=10/1m,/api/user/login=10/1m"`
+	MaxLinksPerUser  int    `env:"MAX_LINKS_PER_USER" envDefault:"0"`
+
+	QRErrorCorrection string        `env:"QR_ERROR_CORRECTION" envDefault:"M"`
+	QRMaxSize         int           `env:"QR_MAX_SIZE" envDefault:"1024"`
+	QRCacheMaxAge     time.Duration `env:"QR_CACHE_MAX_AGE" envDefault:"24h"`
+
+	//PreviewFetch загружать ли описание страницы назначения при создании ссылки
+	PreviewFetch        bool          `env:"PREVIEW_FETCH" envDefault:"true"`
+	PreviewFetchTimeout time.Duration `env:"PREVIEW_FETCH_TIMEOUT" envDefault:"5s"`
+	PreviewBlockPrivate bool          `env:"PREVIEW_BLOCK_PRIVATE" envDefault:"true"`
+
+	PolicyRulesPath       string        `env:"POLICY_RULES_PATH" envDefault:""`
+	PolicyReloadInterval  time.Duration `env:"POLICY_RELOAD_INTERVAL" envDefault:"10s"`
+	PolicyDefaultDeny     bool          `env:"POLICY_DEFAULT_DENY" envDefault:"false"`
+	PolicyCheckOnRedirect bool          `env:"POLICY_CHECK_ON_REDIRECT" envDefault:"false"`
+
+	//AdminToken токен доступа к /api/admin/*, передаётся в заголовке
+	//X-Admin-Token. Пока не задан, административный API отключён.
+	AdminToken string `env:"ADMIN_TOKEN" envDefault:""`
+	//TrustedSubnet CIDR клиентов, которым доступен /api/internal/stats.
+	//Пока не задан, статистика недоступна никому.
+	TrustedSubnet string `env:"TRUSTED_SUBNET" envDefault:""`
+	//TrustedProxies адреса или CIDR прокси, которым разрешено передавать адрес
+	//клиента в X-Real-IP. Соединениям из TrustedSubnet это разрешено и так.
+	TrustedProxies []string `env:"TRUSTED_PROXIES" envSeparator:","`
+
+	MirrorStoragePaths []string      `env:"MIRROR_STORAGE_PATHS" envSeparator:","`
+	MirrorQueueSize    int           `env:"MIRROR_QUEUE_SIZE" envDefault:"1024"`
+	MirrorRetries      int           `env:"MIRROR_RETRIES" envDefault:"3"`
+	MirrorRetryDelay   time.Duration `env:"MIRROR_RETRY_DELAY" envDefault:"500ms"`
+	MirrorMaxLag       time.Duration `env:"MIRROR_MAX_LAG" envDefault:"1m"`
 }
 
 func (c *Config) ParseArgsCMD() {
@@ -28,6 +112,12 @@ func (c *Config) ParseArgsCMD() {
 			"path to file with shortened URL")
 		flag.StringVar(&c.DatabaseDSN, "d", DefaultDatabaseDSN,
 			"DB connection address")
+		flag.DurationVar(&c.StorageTimeout, "st", DefaultStorageTimeout,
+			"timeout of a single storage request")
+		flag.StringVar(&c.DatabaseReplicaDSN, "dr", DefaultDatabaseReplicaDSN,
+			"read replica DB connection address")
+		flag.StringVar(&c.TrustedSubnet, "t", DefaultTrustedSubnet,
+			"CIDR of clients allowed to read internal stats")
 		flag.Parse()
 	}
 }
@@ -42,10 +132,20 @@ func (c *Config) ApplyConfig(other Config) {
 	if c.FileStoragePath == DefaultFileStoragePath {
 		c.FileStoragePath = other.FileStoragePath
 	}
-	if c.Key == DefaultKey {
+	//у ключа нет флага командной строки
+	if c.Key == DefaultKey && other.Key != "" {
 		c.Key = other.Key
 	}
 	if c.DatabaseDSN == DefaultDatabaseDSN {
 		c.DatabaseDSN = other.DatabaseDSN
 	}
+	if c.StorageTimeout == DefaultStorageTimeout {
+		c.StorageTimeout = other.StorageTimeout
+	}
+	if c.DatabaseReplicaDSN == DefaultDatabaseReplicaDSN {
+		c.DatabaseReplicaDSN = other.DatabaseReplicaDSN
+	}
+	if c.TrustedSubnet == DefaultTrustedSubnet {
+		c.TrustedSubnet = other.TrustedSubnet
+	}
 }
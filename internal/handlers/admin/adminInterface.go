@@ -0,0 +1,17 @@
+package admin
+
+import "net/http"
+
+var _ AdminHandler = &adminHandlerImpl{}
+
+type AdminHandler interface {
+	//Authentication пропускает только запросы с токеном администратора
+	Authentication(next http.Handler) http.Handler
+
+	GetAudit(w http.ResponseWriter, r *http.Request)
+	SearchLinks(w http.ResponseWriter, r *http.Request)
+	GetLink(w http.ResponseWriter, r *http.Request)
+	DisableLink(w http.ResponseWriter, r *http.Request)
+	EnableLink(w http.ResponseWriter, r *http.Request)
+	DisableDomain(w http.ResponseWriter, r *http.Request)
+}
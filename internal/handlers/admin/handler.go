@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	adminservice "github.com/sandor-clegane/urlshortener/internal/service/admin"
+	"github.com/sandor-clegane/urlshortener/internal/service/audit"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+const tokenHeader = "X-Admin-Token"
+
+type adminHandlerImpl struct {
+	admin adminservice.AdminService
+	audit audit.AuditService
+	//tokenHash хэш токена администратора, nil если административный API отключён
+	tokenHash []byte
+}
+
+func New(stg storages.Storage, cfg config.Config) AdminHandler {
+	h := &adminHandlerImpl{
+		admin: adminservice.New(stg, cfg),
+		audit: audit.New(stg, cfg),
+	}
+	if cfg.AdminToken != "" {
+		sum := sha256.Sum256([]byte(cfg.AdminToken))
+		h.tokenHash = sum[:]
+	}
+	return h
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+//Authentication сравнивает хэши токенов, чтобы время сравнения
+//не зависело ни от длины, ни от содержимого переданного токена
+func (h *adminHandlerImpl) Authentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h.tokenHash == nil {
+			http.Error(w, "admin API is disabled", http.StatusForbidden)
+			return
+		}
+		token := r.Header.Get(tokenHeader)
+		if token == "" {
+			http.Error(w, "admin token required", http.StatusUnauthorized)
+			return
+		}
+		sum := sha256.Sum256([]byte(token))
+		if subtle.ConstantTimeCompare(sum[:], h.tokenHash) != 1 {
+			http.Error(w, "invalid admin token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//auditFilter разбирает параметры запроса link_id, actor_id, action,
+//since и until (RFC 3339), before (ID записи) и limit
+func auditFilter(r *http.Request) (common.AuditFilter, error) {
+	q := r.URL.Query()
+	filter := common.AuditFilter{
+		LinkID:  q.Get("link_id"),
+		ActorID: q.Get("actor_id"),
+		Action:  q.Get("action"),
+	}
+	var err error
+	if v := q.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("since must be an RFC 3339 time")
+		}
+	}
+	if v := q.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			return filter, errors.New("until must be an RFC 3339 time")
+		}
+	}
+	if v := q.Get("before"); v != "" {
+		if filter.BeforeID, err = strconv.ParseInt(v, 10, 64); err != nil || filter.BeforeID <= 0 {
+			return filter, errors.New("before must be a positive record ID")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			return filter, errors.New("limit must be a positive integer")
+		}
+	}
+	return filter, nil
+}
+
+//GetAudit эндпоинт GET /api/admin/audit возвращает записи журнала аудита,
+//начиная с новых. Следующая страница запрашивается с before равным ID
+//последней полученной записи.
+func (h *adminHandlerImpl) GetAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := auditFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	records, err := h.audit.Query(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if records == nil {
+		records = []common.AuditRecord{}
+	}
+	writeJSON(w, http.StatusOK, records)
+}
@@ -0,0 +1,151 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	adminservice "github.com/sandor-clegane/urlshortener/internal/service/admin"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+type disableRequest struct {
+	Legal  bool   `json:"legal"`
+	Reason string `json:"reason"`
+}
+
+type disableDomainRequest struct {
+	Domain string `json:"domain"`
+	disableRequest
+}
+
+type disableDomainResponse struct {
+	Disabled []string `json:"disabled"`
+}
+
+//errorStatus возвращает HTTP-статус для ошибки административного сервиса
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, adminservice.ErrInvalidDomain), errors.Is(err, adminservice.ErrInvalidReason):
+		return http.StatusBadRequest
+	case errors.Is(err, storages.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+//readDisableRequest разбирает необязательное тело запроса блокировки
+func readDisableRequest(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(r.Body).Decode(v)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+//linkFilter разбирает параметры запроса q, user_id, workspace, domain,
+//disabled, after и limit
+func linkFilter(r *http.Request) (common.LinkFilter, error) {
+	q := r.URL.Query()
+	filter := common.LinkFilter{
+		Query:       q.Get("q"),
+		UserID:      q.Get("user_id"),
+		WorkspaceID: q.Get("workspace"),
+		Domain:      q.Get("domain"),
+		AfterID:     q.Get("after"),
+	}
+	var err error
+	if v := q.Get("disabled"); v != "" {
+		if filter.Disabled, err = strconv.ParseBool(v); err != nil {
+			return filter, errors.New("disabled must be true or false")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit <= 0 {
+			return filter, errors.New("limit must be a positive integer")
+		}
+	}
+	return filter, nil
+}
+
+//SearchLinks эндпоинт GET /api/admin/links ищет ссылки всех пользователей
+//в порядке ID. Следующая страница запрашивается с after равным ID
+//последней полученной ссылки.
+func (h *adminHandlerImpl) SearchLinks(w http.ResponseWriter, r *http.Request) {
+	filter, err := linkFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	links, err := h.admin.SearchLinks(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, links)
+}
+
+//GetLink эндпоинт GET /api/admin/links/{id} возвращает ссылку вместе
+//с владельцем и статистикой переходов
+func (h *adminHandlerImpl) GetLink(w http.ResponseWriter, r *http.Request) {
+	details, err := h.admin.Link(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+//DisableLink эндпоинт POST /api/admin/links/{id}/disable принимает
+//необязательный JSON {"legal": ..., "reason": ...} и блокирует ссылку:
+//переходы по ней отвечают 451 при legal и 410 в остальных случаях
+func (h *adminHandlerImpl) DisableLink(w http.ResponseWriter, r *http.Request) {
+	var req disableRequest
+	if err := readDisableRequest(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	link, err := h.admin.Disable(r.Context(), chi.URLParam(r, "id"), req.Legal, req.Reason)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, link)
+}
+
+//EnableLink эндпоинт POST /api/admin/links/{id}/enable снимает блокировку ссылки
+func (h *adminHandlerImpl) EnableLink(w http.ResponseWriter, r *http.Request) {
+	link, err := h.admin.Enable(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, link)
+}
+
+//DisableDomain эндпоинт POST /api/admin/domains/disable принимает JSON
+//{"domain": ..., "legal": ..., "reason": ...} и блокирует все работающие
+//ссылки на домен и его поддомены, возвращая их ID
+func (h *adminHandlerImpl) DisableDomain(w http.ResponseWriter, r *http.Request) {
+	var req disableDomainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ids, err := h.admin.DisableDomain(r.Context(), req.Domain, req.Legal, req.Reason)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, disableDomainResponse{Disabled: ids})
+}
@@ -1,9 +0,0 @@
-package db
-
-import "net/http"
-
-var _ DBHandler = &dbHandlerImpl{}
-
-type DBHandler interface {
-	PingConnectionDB(w http.ResponseWriter, r *http.Request)
-}
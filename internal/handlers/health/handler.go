@@ -0,0 +1,128 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK           = "ok"
+	StatusFail         = "fail"
+	StatusShuttingDown = "shutting down"
+
+	checkTimeout = 2 * time.Second
+)
+
+//Component проверяемая часть сервиса. Отказ необязательного (Optional)
+//компонента отражается в ответе, но не снимает готовность сервиса.
+//Details, если задан, добавляет в ответ метрики компонента.
+type Component struct {
+	Name     string
+	Check    func(ctx context.Context) error
+	Details  func() interface{}
+	Optional bool
+}
+
+type componentStatus struct {
+	Status   string      `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	Optional bool        `json:"optional,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+type healthResponse struct {
+	Status     string                     `json:"status"`
+	Components map[string]componentStatus `json:"components,omitempty"`
+}
+
+type healthHandlerImpl struct {
+	components   []Component
+	shuttingDown int32
+}
+
+func New(components ...Component) HealthHandler {
+	return &healthHandlerImpl{components: components}
+}
+
+//SetShuttingDown переводит сервис в состояние "не готов" на время остановки
+func (h *healthHandlerImpl) SetShuttingDown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+func (h *healthHandlerImpl) isShuttingDown() bool {
+	return atomic.LoadInt32(&h.shuttingDown) == 1
+}
+
+//check опрашивает все компоненты и сообщает, готовы ли обязательные из них
+func (h *healthHandlerImpl) check(ctx context.Context) (map[string]componentStatus, bool) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	ready := true
+	statuses := make(map[string]componentStatus, len(h.components))
+	for _, c := range h.components {
+		cs := componentStatus{Status: StatusOK, Optional: c.Optional}
+		if err := c.Check(ctx); err != nil {
+			cs.Status = StatusFail
+			cs.Error = err.Error()
+			if !c.Optional {
+				ready = false
+			}
+		}
+		if c.Details != nil {
+			cs.Details = c.Details()
+		}
+		statuses[c.Name] = cs
+	}
+	return statuses, ready
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp healthResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+//Liveness эндпоинт GET /healthz сообщает, что процесс жив и обслуживает запросы.
+//Состояние хранилища на ответ не влияет.
+func (h *healthHandlerImpl) Liveness(w http.ResponseWriter, _ *http.Request) {
+	writeResponse(w, http.StatusOK, healthResponse{Status: StatusOK})
+}
+
+//Readiness эндпоинт GET /readyz проверяет все компоненты и возвращает их
+//состояние. При отказе обязательного компонента или во время остановки
+//сервиса отвечает 503 Service Unavailable.
+func (h *healthHandlerImpl) Readiness(w http.ResponseWriter, r *http.Request) {
+	statuses, ready := h.check(r.Context())
+	resp := healthResponse{Status: StatusOK, Components: statuses}
+	status := http.StatusOK
+	switch {
+	case h.isShuttingDown():
+		resp.Status = StatusShuttingDown
+		status = http.StatusServiceUnavailable
+	case !ready:
+		resp.Status = StatusFail
+		status = http.StatusServiceUnavailable
+	}
+	writeResponse(w, status, resp)
+}
+
+//Ping эндпоинт GET /ping проверяет используемое хранилище.
+//При успешной проверке возвращает HTTP-статус 200 OK,
+//при неуспешной — 500 Internal Server Error
+func (h *healthHandlerImpl) Ping(w http.ResponseWriter, r *http.Request) {
+	statuses, ready := h.check(r.Context())
+	if !ready {
+		for name, cs := range statuses {
+			if cs.Status != StatusOK && !cs.Optional {
+				http.Error(w, name+": "+cs.Error, http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
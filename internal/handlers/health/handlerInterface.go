@@ -0,0 +1,13 @@
+package health
+
+import "net/http"
+
+var _ HealthHandler = &healthHandlerImpl{}
+
+type HealthHandler interface {
+	Liveness(w http.ResponseWriter, r *http.Request)
+	Readiness(w http.ResponseWriter, r *http.Request)
+	Ping(w http.ResponseWriter, r *http.Request)
+
+	SetShuttingDown()
+}
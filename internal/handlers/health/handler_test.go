@@ -0,0 +1,115 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func okCheck(context.Context) error { return nil }
+
+func failCheck(context.Context) error { return errors.New("connection refused") }
+
+func readiness(t *testing.T, h HealthHandler) (int, healthResponse) {
+	rec := httptest.NewRecorder()
+	h.Readiness(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	var resp healthResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return rec.Code, resp
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		name       string
+		components []Component
+		wantCode   int
+		wantStatus string
+		wantComps  map[string]componentStatus
+	}{
+		{
+			name: "all ok",
+			components: []Component{
+				{Name: "storage", Check: okCheck},
+				{Name: "mirror:a", Check: okCheck, Optional: true,
+					Details: func() interface{} { return map[string]interface{}{"lag": "0s"} }},
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: StatusOK,
+			wantComps: map[string]componentStatus{
+				"storage":  {Status: StatusOK},
+				"mirror:a": {Status: StatusOK, Optional: true, Details: map[string]interface{}{"lag": "0s"}},
+			},
+		},
+		{
+			name: "optional component fails",
+			components: []Component{
+				{Name: "storage", Check: okCheck},
+				{Name: "replica", Check: failCheck, Optional: true},
+			},
+			wantCode:   http.StatusOK,
+			wantStatus: StatusOK,
+			wantComps: map[string]componentStatus{
+				"storage": {Status: StatusOK},
+				"replica": {Status: StatusFail, Error: "connection refused", Optional: true},
+			},
+		},
+		{
+			name: "required component fails",
+			components: []Component{
+				{Name: "storage", Check: failCheck},
+				{Name: "replica", Check: okCheck, Optional: true},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: StatusFail,
+			wantComps: map[string]componentStatus{
+				"storage": {Status: StatusFail, Error: "connection refused"},
+				"replica": {Status: StatusOK, Optional: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, resp := readiness(t, New(tt.components...))
+			assert.Equal(t, tt.wantCode, code)
+			assert.Equal(t, tt.wantStatus, resp.Status)
+			assert.Equal(t, tt.wantComps, resp.Components)
+		})
+	}
+}
+
+func TestReadinessShuttingDown(t *testing.T) {
+	h := New(Component{Name: "storage", Check: okCheck})
+	code, _ := readiness(t, h)
+	assert.Equal(t, http.StatusOK, code)
+
+	h.SetShuttingDown()
+	code, resp := readiness(t, h)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusShuttingDown, resp.Status)
+	assert.Equal(t, StatusOK, resp.Components["storage"].Status)
+
+	//liveness во время остановки не меняется
+	rec := httptest.NewRecorder()
+	h.Liveness(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestPing(t *testing.T) {
+	rec := httptest.NewRecorder()
+	New(Component{Name: "storage", Check: okCheck},
+		Component{Name: "replica", Check: failCheck, Optional: true}).
+		Ping(rec, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	New(Component{Name: "storage", Check: failCheck}).
+		Ping(rec, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Contains(t, rec.Body.String(), "storage: connection refused")
+}
@@ -0,0 +1,111 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/service/stats"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+type statsHandlerImpl struct {
+	stats stats.StatsService
+	//trusted доверенная подсеть, nil если она не задана
+	trusted *net.IPNet
+	//proxies прокси, чей заголовок X-Real-IP принимается
+	proxies []*net.IPNet
+}
+
+func New(stg storages.Storage, cfg config.Config) (StatsHandler, error) {
+	h := &statsHandlerImpl{stats: stats.New(stg, cfg)}
+	if cfg.TrustedSubnet != "" {
+		_, subnet, err := net.ParseCIDR(strings.TrimSpace(cfg.TrustedSubnet))
+		if err != nil {
+			return nil, fmt.Errorf("trusted subnet: %w", err)
+		}
+		h.trusted = subnet
+	}
+	for _, p := range cfg.TrustedProxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			if ip := net.ParseIP(p); ip != nil && ip.To4() != nil {
+				p += "/32"
+			} else {
+				p += "/128"
+			}
+		}
+		_, proxy, err := net.ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy: %w", err)
+		}
+		h.proxies = append(h.proxies, proxy)
+	}
+	return h, nil
+}
+
+//clientIP адрес соединения. Заголовок X-Real-IP учитывается, только если
+//соединение пришло из доверенной подсети или от доверенного прокси,
+//иначе любой клиент мог бы выдать себя за внутренний адрес.
+func (h *statsHandlerImpl) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote := net.ParseIP(host)
+	realIP := strings.TrimSpace(r.Header.Get("X-Real-IP"))
+	if remote == nil || realIP == "" || !h.isForwarder(remote) {
+		return remote
+	}
+	return net.ParseIP(realIP)
+}
+
+func (h *statsHandlerImpl) isForwarder(ip net.IP) bool {
+	if h.trusted != nil && h.trusted.Contains(ip) {
+		return true
+	}
+	for _, p := range h.proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//TrustedSubnet отвечает 403, если подсеть не задана, адрес клиента
+//не удалось разобрать или он не входит в подсеть
+func (h *statsHandlerImpl) TrustedSubnet(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := h.clientIP(r)
+		if h.trusted == nil || ip == nil || !h.trusted.Contains(ip) {
+			http.Error(w, "access is allowed from the trusted subnet only", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+//GetStats эндпоинт GET /api/internal/stats возвращает JSON с числом
+//сокращённых ссылок и пользователей
+func (h *statsHandlerImpl) GetStats(w http.ResponseWriter, r *http.Request) {
+	res, err := h.stats.Stats(r.Context())
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(res)
+}
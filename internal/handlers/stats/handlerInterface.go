@@ -0,0 +1,12 @@
+package stats
+
+import "net/http"
+
+var _ StatsHandler = &statsHandlerImpl{}
+
+type StatsHandler interface {
+	//TrustedSubnet пропускает только клиентов из доверенной подсети
+	TrustedSubnet(next http.Handler) http.Handler
+
+	GetStats(w http.ResponseWriter, r *http.Request)
+}
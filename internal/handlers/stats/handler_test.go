@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedSubnet(t *testing.T) {
+	tests := []struct {
+		name       string
+		subnet     string
+		proxies    []string
+		remoteAddr string
+		realIP     string
+		want       int
+	}{
+		{name: "subnet not set", remoteAddr: "10.0.0.5:1234", want: http.StatusForbidden},
+		{name: "remote addr inside", subnet: "10.0.0.0/24", remoteAddr: "10.0.0.5:1234",
+			want: http.StatusOK},
+		{name: "remote addr outside", subnet: "10.0.0.0/24", remoteAddr: "192.0.2.1:1234",
+			want: http.StatusForbidden},
+		{name: "remote addr without port", subnet: "10.0.0.0/24", remoteAddr: "10.0.0.5",
+			want: http.StatusOK},
+		{name: "unparsable remote addr", subnet: "10.0.0.0/24", remoteAddr: "garbage",
+			want: http.StatusForbidden},
+		{name: "spoofed header from outside", subnet: "10.0.0.0/24", remoteAddr: "192.0.2.1:1234",
+			realIP: "10.0.0.5", want: http.StatusForbidden},
+		{name: "header inside from trusted proxy", subnet: "10.0.0.0/24", proxies: []string{"", "192.0.2.1"},
+			remoteAddr: "192.0.2.1:1234", realIP: "10.0.0.5", want: http.StatusOK},
+		{name: "header outside from trusted proxy", subnet: "10.0.0.0/24", proxies: []string{"192.0.2.0/24"},
+			remoteAddr: "192.0.2.1:1234", realIP: "198.51.100.7", want: http.StatusForbidden},
+		{name: "header outside from subnet", subnet: "10.0.0.0/24", remoteAddr: "10.0.0.1:1234",
+			realIP: "198.51.100.7", want: http.StatusForbidden},
+		{name: "unparsable header", subnet: "10.0.0.0/24", remoteAddr: "10.0.0.1:1234",
+			realIP: "garbage", want: http.StatusForbidden},
+	}
+	stg, _ := storages.NewInMemoryStorage()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := New(stg, config.Config{TrustedSubnet: tt.subnet, TrustedProxies: tt.proxies})
+			require.NoError(t, err)
+			r := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			rec := httptest.NewRecorder()
+			h.TrustedSubnet(ok).ServeHTTP(rec, r)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	stg, _ := storages.NewInMemoryStorage()
+	_, err := New(stg, config.Config{TrustedSubnet: "10.0.0.0"})
+	assert.Error(t, err)
+	_, err = New(stg, config.Config{TrustedSubnet: "10.0.0.0/24", TrustedProxies: []string{"proxy"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,86 @@
+package url
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/service/account"
+)
+
+type credentialsRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+//credentials читает email и пароль запроса. Входят только пользователи,
+//а не скрипты с API-ключом.
+func credentials(w http.ResponseWriter, r *http.Request) (credentialsRequest, bool) {
+	var req credentialsRequest
+	if _, ok := common.RequestAPIKey(r.Context()); ok {
+		http.Error(w, "API keys cannot log in", http.StatusForbidden)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+//startSession выдаёт cookie сессии учётной записи и возвращает её в ответе
+func (h *URLhandlerImpl) startSession(w http.ResponseWriter, a common.Account, status int) {
+	h.cs.SetSession(w, a.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(a)
+}
+
+//Register эндпоинт POST /api/user/register принимает JSON {"email": ..., "password": ...},
+//создаёт учётную запись и возвращает ответ с кодом 201 и cookie её сессии.
+//Ссылки анонимного пользователя cookie переходят учётной записи.
+func (h *URLhandlerImpl) Register(w http.ResponseWriter, r *http.Request) {
+	req, ok := credentials(w, r)
+	if !ok {
+		return
+	}
+	anonymousID, _ := common.SessionUserID(r.Context())
+	a, err := h.accounts.Register(r.Context(), anonymousID, req.Email, req.Password)
+	if errors.Is(err, account.ErrEmailTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, account.ErrInvalidEmail) || errors.Is(err, account.ErrPasswordTooShort) ||
+		errors.Is(err, account.ErrPasswordTooLong) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	h.startSession(w, a, http.StatusCreated)
+}
+
+//Login эндпоинт POST /api/user/login принимает JSON {"email": ..., "password": ...}
+//и возвращает ответ с кодом 200 и cookie сессии учётной записи или 401.
+//Ссылки анонимного пользователя cookie переходят учётной записи.
+func (h *URLhandlerImpl) Login(w http.ResponseWriter, r *http.Request) {
+	req, ok := credentials(w, r)
+	if !ok {
+		return
+	}
+	anonymousID, _ := common.SessionUserID(r.Context())
+	a, err := h.accounts.Login(r.Context(), anonymousID, req.Email, req.Password)
+	if errors.Is(err, account.ErrInvalidCredentials) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	h.startSession(w, a, http.StatusOK)
+}
@@ -0,0 +1,114 @@
+package url
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/service/apikey"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+type createAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type createAPIKeyResponse struct {
+	common.APIKey
+	//Key значение ключа, больше его получить нельзя
+	Key string `json:"key"`
+}
+
+//GetScopeMiddleware пропускает запросы с API-ключом, только если ключу
+//разрешено действие scope. Должен подключаться после middleware авторизации.
+func (h *URLhandlerImpl) GetScopeMiddleware(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !common.Authorized(r.Context(), scope) {
+				http.Error(w, "API key has no "+scope+" scope", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+//keyOwner возвращает пользователя для управления ключами. Ключами
+//управляет только сам пользователь, а не скрипты с его ключом.
+func (h *URLhandlerImpl) keyOwner(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if _, ok := common.RequestAPIKey(r.Context()); ok {
+		http.Error(w, "API keys cannot manage API keys", http.StatusForbidden)
+		return "", false
+	}
+	userID, err := h.userID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+//CreateAPIKey эндпоинт POST /api/user/keys принимает JSON {"name": ..., "scopes": [...]}
+//и возвращает ответ с кодом 201 и ключом. Без scopes ключу разрешены все действия.
+func (h *URLhandlerImpl) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.keyOwner(w, r)
+	if !ok {
+		return
+	}
+	var req createAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	key, rawKey, err := h.keys.Create(r.Context(), userID, req.Name, req.Scopes)
+	if errors.Is(err, apikey.ErrInvalidScope) || errors.Is(err, apikey.ErrNameTooLong) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createAPIKeyResponse{APIKey: key, Key: rawKey})
+}
+
+//GetAPIKeys эндпоинт GET /api/user/keys возвращает ключи пользователя без их значений
+func (h *URLhandlerImpl) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.keyOwner(w, r)
+	if !ok {
+		return
+	}
+	keys, err := h.keys.List(r.Context(), userID)
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(keys)
+}
+
+//RevokeAPIKey эндпоинт DELETE /api/user/keys/{id} отзывает ключ,
+//возвращает 204 или 404, если у пользователя нет такого ключа
+func (h *URLhandlerImpl) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.keyOwner(w, r)
+	if !ok {
+		return
+	}
+	err := h.keys.Revoke(r.Context(), userID, chi.URLParam(r, "id"))
+	if errors.Is(err, storages.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeError(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -7,9 +7,31 @@ var _ URLHandler = &URLhandlerImpl{}
 type URLHandler interface {
 	GetAllURL(w http.ResponseWriter, r *http.Request)
 	ExpandURL(w http.ResponseWriter, r *http.Request)
+	UnlockURL(w http.ResponseWriter, r *http.Request)
+	GetQRCode(w http.ResponseWriter, r *http.Request)
+	PreviewURL(w http.ResponseWriter, r *http.Request)
 	ShortenURL(w http.ResponseWriter, r *http.Request)
 	ShortenURLwJSON(w http.ResponseWriter, r *http.Request)
 	ShortenSomeURL(w http.ResponseWriter, r *http.Request)
+	GetRules(w http.ResponseWriter, r *http.Request)
+	SetRules(w http.ResponseWriter, r *http.Request)
+	GetVariants(w http.ResponseWriter, r *http.Request)
+	GetUTM(w http.ResponseWriter, r *http.Request)
+	SetUTM(w http.ResponseWriter, r *http.Request)
+	GetUserUTM(w http.ResponseWriter, r *http.Request)
+	SetUserUTM(w http.ResponseWriter, r *http.Request)
+	CreateAPIKey(w http.ResponseWriter, r *http.Request)
+	GetAPIKeys(w http.ResponseWriter, r *http.Request)
+	RevokeAPIKey(w http.ResponseWriter, r *http.Request)
+	Register(w http.ResponseWriter, r *http.Request)
+	Login(w http.ResponseWriter, r *http.Request)
+	CreateWorkspace(w http.ResponseWriter, r *http.Request)
+	GetWorkspaces(w http.ResponseWriter, r *http.Request)
+	GetMembers(w http.ResponseWriter, r *http.Request)
+	SetMember(w http.ResponseWriter, r *http.Request)
+	RemoveMember(w http.ResponseWriter, r *http.Request)
 
 	GetAuthorizationMiddleware() func(next http.Handler) http.Handler
+	GetRateLimitMiddleware(route string) func(next http.Handler) http.Handler
+	GetScopeMiddleware(scope string) func(next http.Handler) http.Handler
 }
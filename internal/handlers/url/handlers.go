@@ -1,57 +1,256 @@
 package url
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi"
 	_ "github.com/lib/pq"
 	"github.com/sandor-clegane/urlshortener/internal/common"
 	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
 	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/service/account"
+	"github.com/sandor-clegane/urlshortener/internal/service/apikey"
 	"github.com/sandor-clegane/urlshortener/internal/service/cookie"
+	"github.com/sandor-clegane/urlshortener/internal/service/policy"
+	"github.com/sandor-clegane/urlshortener/internal/service/qrcode"
 	"github.com/sandor-clegane/urlshortener/internal/service/shortener"
+	"github.com/sandor-clegane/urlshortener/internal/service/workspace"
 	"github.com/sandor-clegane/urlshortener/internal/storages"
 )
 
 type URLhandlerImpl struct {
-	us shortener.URLshortenerService
-	cs cookie.CookieService
+	us       shortener.URLshortenerService
+	cs       cookie.CookieService
+	keys     apikey.APIKeyService
+	accounts account.AccountService
+	limiters map[string]routeLimiters
+	qr       qrcode.QRCodeService
+	qrMaxAge time.Duration
+
+	workspaces workspace.WorkspaceService
 }
 
-func New(stg storages.Storage, cfg config.Config) URLHandler {
+func New(stg storages.Storage, pe policy.PolicyEngine, cfg config.Config) (URLHandler, error) {
+	limiters, err := newRouteLimiters(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := cookie.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	qr, err := qrcode.New(cfg)
+	if err != nil {
+		return nil, err
+	}
 	return &URLhandlerImpl{
-		cs: cookie.New(cfg.Key),
-		us: shortener.New(stg, cfg.BaseURL),
+		cs:       cs,
+		keys:     apikey.New(stg, cfg),
+		accounts: account.New(stg, cfg),
+		us:       shortener.New(stg, pe, cfg),
+		limiters: limiters,
+		qr:       qr,
+		qrMaxAge: cfg.QRCacheMaxAge,
+
+		workspaces: workspace.New(stg, cfg),
+	}, nil
+}
+
+//userID возвращает идентификатор пользователя, подтверждённый
+//middleware авторизации
+func (h *URLhandlerImpl) userID(r *http.Request) (string, error) {
+	return common.UserID(r.Context())
+}
+
+//workspaceID рабочее пространство из параметра запроса workspace,
+//пустое для личных ссылок пользователя
+func workspaceID(r *http.Request) string {
+	return r.URL.Query().Get("workspace")
+}
+
+//errorStatus возвращает HTTP-статус для ошибки сервиса: 504, если хранилище
+//не ответило вовремя, 503, если запрос к хранилищу был прерван,
+//и defaultStatus во всех остальных случаях
+func errorStatus(err error, defaultStatus int) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	default:
+		return defaultStatus
 	}
 }
 
+type errorResponse struct {
+	Error   string `json:"error"`
+	Message string `json:"message"`
+	URL     string `json:"url,omitempty"`
+	Rule    string `json:"rule,omitempty"`
+}
+
+//writeError отвечает на ошибку сервиса. Нарушение политики доменов и
+//ссылка на сам сервис возвращаются как 422 с описанием в JSON,
+//превышение квоты ссылок — как 429, исчерпанная или заблокированная
+//ссылка — как 410 Gone, заблокированная по требованию закона — как 451,
+//зацикленная цепочка ссылок — как 508 Loop Detected, недостаточная роль
+//в рабочем пространстве — как 403.
+func writeError(w http.ResponseWriter, err error, defaultStatus int) {
+	var policyError *myerrors.PolicyViolation
+	if errors.As(err, &policyError) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(errorResponse{
+			Error:   "url_blocked",
+			Message: policyError.Error(),
+			URL:     policyError.URL,
+			Rule:    policyError.Rule,
+		})
+		return
+	}
+	var selfRefError *myerrors.SelfReference
+	if errors.As(err, &selfRefError) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(errorResponse{
+			Error:   "self_reference",
+			Message: selfRefError.Error(),
+			URL:     selfRefError.URL,
+		})
+		return
+	}
+	var quotaError *myerrors.QuotaExceeded
+	if errors.As(err, &quotaError) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(errorResponse{
+			Error:   "quota_exceeded",
+			Message: quotaError.Error(),
+		})
+		return
+	}
+	var forbiddenError *myerrors.Forbidden
+	if errors.As(err, &forbiddenError) {
+		http.Error(w, forbiddenError.Error(), http.StatusForbidden)
+		return
+	}
+	var disabledError *myerrors.LinkDisabled
+	if errors.As(err, &disabledError) {
+		status := http.StatusGone
+		if disabledError.Legal {
+			status = http.StatusUnavailableForLegalReasons
+		}
+		http.Error(w, disabledError.Error(), status)
+		return
+	}
+	var goneError *myerrors.LinkGone
+	if errors.As(err, &goneError) {
+		http.Error(w, goneError.Error(), http.StatusGone)
+		return
+	}
+	var loopError *myerrors.RedirectLoop
+	if errors.As(err, &loopError) {
+		http.Error(w, loopError.Error(), http.StatusLoopDetected)
+		return
+	}
+	http.Error(w, err.Error(), errorStatus(err, defaultStatus))
+}
+
+//GetAuthorizationMiddleware авторизует запрос API-ключом, а если его нет —
+//bearer-токеном или cookie
 func (h *URLhandlerImpl) GetAuthorizationMiddleware() func(next http.Handler) http.Handler {
-	return h.cs.Authentication
+	return func(next http.Handler) http.Handler {
+		return h.keys.Authentication(h.cs.Authentication(next))
+	}
+}
+
+//variantCookieMaxAge сколько посетитель остаётся в назначенном варианте A/B-теста
+const variantCookieMaxAge = 30 * 24 * 60 * 60
+
+//variantCookieName у каждой ссылки своя cookie с вариантом A/B-теста
+func variantCookieName(r *http.Request) string {
+	return "ab_" + strings.Trim(r.URL.Path, "/")
+}
+
+//clientInfo собирает сведения о запросе для правил перенаправления
+func clientInfo(r *http.Request) common.ClientInfo {
+	client := common.ClientInfo{
+		UserAgent:      r.UserAgent(),
+		AcceptLanguage: r.Header.Get("Accept-Language"),
+		Query:          r.URL.Query(),
+	}
+	if c, err := r.Cookie(variantCookieName(r)); err == nil {
+		client.Variant = c.Value
+	}
+	return client
+}
+
+//writeRedirect перенаправляет на адрес ссылки и закрепляет за посетителем
+//выбранный вариант A/B-теста
+func writeRedirect(w http.ResponseWriter, r *http.Request, client common.ClientInfo,
+	redirect common.Redirect, status int) {
+	if redirect.Variant != "" && redirect.Variant != client.Variant {
+		http.SetCookie(w, &http.Cookie{
+			Name:     variantCookieName(r),
+			Value:    redirect.Variant,
+			Path:     r.URL.Path,
+			MaxAge:   variantCookieMaxAge,
+			HttpOnly: true,
+		})
+	}
+	w.Header().Add("Location", redirect.URL)
+	w.WriteHeader(status)
 }
 
 //ExpandURL Эндпоинт GET /{id} принимает в качестве URL-параметра идентификатор сокращённого URL и
 //возвращает ответ с кодом 307 и оригинальным URL в HTTP-заголовке Location.
+//Для ссылки с паролем вместо перенаправления отдаётся форма ввода пароля.
 func (h *URLhandlerImpl) ExpandURL(w http.ResponseWriter, r *http.Request) {
-	expandURL, err := h.us.ExpandURL(r.Context(), r.URL.Path)
+	client := clientInfo(r)
+	redirect, err := h.us.ExpandURL(r.Context(), r.URL.Path, client)
+	var passwordError *myerrors.PasswordRequired
+	if errors.As(err, &passwordError) {
+		writePasswordForm(w, http.StatusOK, false)
+		return
+	}
 	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	writeRedirect(w, r, client, redirect, http.StatusTemporaryRedirect)
+}
+
+//UnlockURL эндпоинт POST /{id} принимает пароль из формы и при верном
+//пароле возвращает ответ с кодом 303 и оригинальным URL в заголовке Location.
+func (h *URLhandlerImpl) UnlockURL(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	w.Header().Add("Location", expandURL)
-	w.WriteHeader(http.StatusTemporaryRedirect)
+	client := clientInfo(r)
+	redirect, err := h.us.UnlockURL(r.Context(), r.URL.Path, r.PostForm.Get("password"), client)
+	var invalidPasswordError *myerrors.InvalidPassword
+	if errors.As(err, &invalidPasswordError) {
+		writePasswordForm(w, http.StatusForbidden, true)
+		return
+	}
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	writeRedirect(w, r, client, redirect, http.StatusSeeOther)
 }
 
 //ShortenURL эндпоинт POST / принимает в теле запроса строку URL для сокращения
 //и возвращает ответ с кодом 201 и сокращённым URL в виде текстовой строки в теле.
 func (h *URLhandlerImpl) ShortenURL(w http.ResponseWriter, r *http.Request) {
-	authCookie, err := r.Cookie("userID")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	userID, err := h.cs.ExtractValue(authCookie)
+	userID, err := h.userID(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -61,7 +260,8 @@ func (h *URLhandlerImpl) ShortenURL(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	short, err := h.us.ShortenURL(r.Context(), userID, string(rawurl))
+	short, err := h.us.ShortenURL(r.Context(), userID, string(rawurl),
+		common.LinkOptions{WorkspaceID: workspaceID(r)})
 	if err == nil {
 		w.WriteHeader(http.StatusCreated)
 		w.Write([]byte(short))
@@ -72,7 +272,7 @@ func (h *URLhandlerImpl) ShortenURL(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusConflict)
 		w.Write([]byte(violationError.ExistedShortURL))
 	} else {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 	}
 }
 
@@ -80,12 +280,7 @@ func (h *URLhandlerImpl) ShortenURL(w http.ResponseWriter, r *http.Request) {
 //принимающий в теле запроса JSON-объект {"url":"<some_url>"}  и
 //возвращающий в ответ объект {"result":"<shorten_url>"}.
 func (h *URLhandlerImpl) ShortenURLwJSON(w http.ResponseWriter, r *http.Request) {
-	authCookie, err := r.Cookie("userID")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-	userID, err := h.cs.ExtractValue(authCookie)
+	userID, err := h.userID(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -96,7 +291,8 @@ func (h *URLhandlerImpl) ShortenURLwJSON(w http.ResponseWriter, r *http.Request)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	short, err := h.us.ShortenURL(r.Context(), userID, inData.ExpandURL.String())
+	inData.LinkOptions.WorkspaceID = workspaceID(r)
+	short, err := h.us.ShortenURL(r.Context(), userID, inData.ExpandURL.String(), inData.LinkOptions)
 	if err == nil {
 		w.Header().Add("Content-Type", "application/json")
 		w.WriteHeader(http.StatusCreated)
@@ -111,35 +307,34 @@ func (h *URLhandlerImpl) ShortenURLwJSON(w http.ResponseWriter, r *http.Request)
 		outData := common.OutMessage{ShortURL: violationError.ExistedShortURL}
 		json.NewEncoder(w).Encode(outData)
 	} else {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusBadRequest)
 	}
 }
 
 //GetAllURL Иметь хендлер GET /api/user/urls, который сможет вернуть
 //пользователю все когда-либо сокращённые им URL в формате:
 //[
-//    {
-//        "short_url": "http://...",
-//        "original_url": "http://..."
-//    },
-//    ...
+//
+//	{
+//	    "short_url": "http://...",
+//	    "original_url": "http://..."
+//	},
+//	...
+//
 //]
 //При отсутствии сокращённых пользователем URL хендлер должен отдавать HTTP-статус 204 No Content.
+//С параметром workspace возвращаются ссылки рабочего пространства.
 func (h *URLhandlerImpl) GetAllURL(w http.ResponseWriter, r *http.Request) {
-	authCookie, err := r.Cookie("userID")
+	userID, err := h.userID(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	userID, err := h.cs.ExtractValue(authCookie)
+	listOfURL, err := h.us.GetAllURL(r.Context(), userID, workspaceID(r))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, err, http.StatusNoContent)
 		return
 	}
-	listOfURL, err := h.us.GetAllURL(r.Context(), userID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusNoContent)
-	}
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(listOfURL)
 	if err != nil {
@@ -150,34 +345,181 @@ func (h *URLhandlerImpl) GetAllURL(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *URLhandlerImpl) ShortenSomeURL(w http.ResponseWriter, r *http.Request) {
-	authCookie, err := r.Cookie("userID")
+	userID, err := h.userID(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	userID, err := h.cs.ExtractValue(authCookie)
+
+	var expandURLwIDslice []common.PairURLwithCIDin
+	err = json.NewDecoder(r.Body).Decode(&expandURLwIDslice)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	var expandURLwIDslice []common.PairURLwithCIDin
-	err = json.NewDecoder(r.Body).Decode(&expandURLwIDslice)
+	shortURLwIDslice, err := h.us.ShortenSomeURL(r.Context(), userID, workspaceID(r), expandURLwIDslice)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(shortURLwIDslice)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+}
+
+//GetRules эндпоинт GET /api/user/urls/{id}/rules возвращает правила
+//перенаправления ссылки пользователя в порядке их проверки
+func (h *URLhandlerImpl) GetRules(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userID(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	rules, err := h.us.GetRules(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err, notFoundStatus(err))
+		return
+	}
+	writeRules(w, rules)
+}
 
-	shortURLwIDslice, err := h.us.ShortenSomeURL(r.Context(), userID, expandURLwIDslice)
+//SetRules эндпоинт PUT /api/user/urls/{id}/rules принимает JSON-массив
+//правил, заменяет ими прежние и возвращает сохранённые правила
+func (h *URLhandlerImpl) SetRules(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userID(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	var rules []common.RedirectRule
+	if err = json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	rules, err = h.us.SetRules(r.Context(), userID, chi.URLParam(r, "id"), rules)
+	if err != nil {
+		writeError(w, err, notFoundStatus(err))
+		return
+	}
+	writeRules(w, rules)
+}
+
+//GetVariants эндпоинт GET /api/user/urls/{id}/variants возвращает
+//варианты A/B-теста ссылки с числом переходов по каждому
+func (h *URLhandlerImpl) GetVariants(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	variants, err := h.us.GetVariants(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err, notFoundStatus(err))
+		return
+	}
+	if variants == nil {
+		variants = []common.Variant{}
+	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	err = json.NewEncoder(w).Encode(shortURLwIDslice)
+	json.NewEncoder(w).Encode(variants)
+}
+
+//GetUTM эндпоинт GET /api/user/urls/{id}/utm возвращает UTM-метки ссылки
+func (h *URLhandlerImpl) GetUTM(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userID(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	utm, err := h.us.GetUTM(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err, notFoundStatus(err))
+		return
+	}
+	writeUTM(w, utm)
+}
+
+//SetUTM эндпоинт PUT /api/user/urls/{id}/utm принимает объект
+//{"source":"...","medium":"...","campaign":"...","term":"...","content":"..."}
+//и заменяет им метки ссылки
+func (h *URLhandlerImpl) SetUTM(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var utm common.UTM
+	if err = json.NewDecoder(r.Body).Decode(&utm); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	utm, err = h.us.SetUTM(r.Context(), userID, chi.URLParam(r, "id"), utm)
+	if err != nil {
+		writeError(w, err, notFoundStatus(err))
+		return
+	}
+	writeUTM(w, utm)
+}
+
+//GetUserUTM эндпоинт GET /api/user/utm возвращает UTM-метки пользователя
+//по умолчанию
+func (h *URLhandlerImpl) GetUserUTM(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	utm, err := h.us.GetUserUTM(r.Context(), userID)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	writeUTM(w, utm)
+}
+
+//SetUserUTM эндпоинт PUT /api/user/utm задаёт метки, которые добавляются
+//при переходе по всем ссылкам пользователя, если у ссылки нет своих
+func (h *URLhandlerImpl) SetUserUTM(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var utm common.UTM
+	if err = json.NewDecoder(r.Body).Decode(&utm); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	utm, err = h.us.SetUserUTM(r.Context(), userID, utm)
+	if err != nil {
+		writeError(w, err, http.StatusBadRequest)
+		return
+	}
+	writeUTM(w, utm)
+}
+
+func writeUTM(w http.ResponseWriter, utm common.UTM) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(utm)
+}
+
+func writeRules(w http.ResponseWriter, rules []common.RedirectRule) {
+	if rules == nil {
+		rules = []common.RedirectRule{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rules)
+}
+
+//notFoundStatus 404 для отсутствующей ссылки, 400 для остальных ошибок
+func notFoundStatus(err error) int {
+	if errors.Is(err, storages.ErrNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
 }
@@ -0,0 +1,33 @@
+package url
+
+import (
+	"html/template"
+	"net/http"
+)
+
+var passwordForm = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>Password required</title>
+</head>
+<body>
+<form method="post">
+<p>This link is protected by a password.</p>
+{{if .}}<p>Invalid password, try again.</p>{{end}}
+<input type="password" name="password" autofocus required>
+<button type="submit">Open</button>
+</form>
+</body>
+</html>
+`))
+
+//writePasswordForm отдаёт форму ввода пароля, которая отправляется
+//на тот же адрес методом POST
+func writePasswordForm(w http.ResponseWriter, status int, invalidPassword bool) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	passwordForm.Execute(w, invalidPassword)
+}
@@ -0,0 +1,60 @@
+package url
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
+)
+
+var previewPage = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="robots" content="noindex">
+<title>{{if .Preview.Title}}{{.Preview.Title}}{{else}}Link preview{{end}}</title>
+</head>
+<body>
+<p>This link leads to:</p>
+<p><code>{{.URL}}</code></p>
+{{with .Preview}}{{if .Image}}<p><img src="{{.Image}}" alt="" style="max-width: 100%; max-height: 300px"></p>{{end}}
+{{if .Title}}<h1>{{.Title}}</h1>{{end}}
+{{if .Description}}<p>{{.Description}}</p>{{end}}{{end}}
+<p><a href="{{.Continue}}" rel="noreferrer">Continue</a></p>
+</body>
+</html>
+`))
+
+type previewPageData struct {
+	URL      string
+	Preview  common.Preview
+	Continue string
+}
+
+//PreviewURL эндпоинт GET /{id}+ отдаёт страницу с адресом назначения
+//и его описанием вместо перенаправления. Для ссылки с паролем адрес
+//не раскрывается: клиент отправляется на форму ввода пароля.
+func (h *URLhandlerImpl) PreviewURL(w http.ResponseWriter, r *http.Request) {
+	shortPath := strings.TrimSuffix(r.URL.Path, "+")
+	link, err := h.us.PreviewURL(r.Context(), chi.URLParam(r, "id"))
+	var passwordError *myerrors.PasswordRequired
+	if errors.As(err, &passwordError) {
+		http.Redirect(w, r, shortPath, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		writeError(w, err, notFoundStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	previewPage.Execute(w, previewPageData{
+		URL:      link.ExpandURL,
+		Preview:  link.Preview,
+		Continue: shortPath,
+	})
+}
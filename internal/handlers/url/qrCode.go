@@ -0,0 +1,59 @@
+package url
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+//GetQRCode эндпоинт GET /{id}/qr возвращает QR-код полного короткого URL.
+//Параметры size, format (png или svg) и level (L, M, Q, H) необязательны.
+//Изображение зависит только от адреса и параметров, поэтому отдаётся
+//с ETag и кэшируется клиентами на QR_CACHE_MAX_AGE.
+func (h *URLhandlerImpl) GetQRCode(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	opts, err := h.qr.ParseOptions(query.Get("size"), query.Get("format"), query.Get("level"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	shortURL, err := h.us.ShortURL(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err, notFoundStatus(err))
+		return
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%d|%s|%s", shortURL, opts.Size, opts.Format, opts.Level)))
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.qrMaxAge.Seconds())))
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	img, err := h.qr.Render(shortURL, opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", img.ContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(img.Data)
+}
+
+//etagMatches проверяет заголовок If-None-Match, который может содержать
+//несколько меток через запятую или *
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package url
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/service/ratelimit"
+)
+
+//routeLimiters ограничители одного маршрута: по пользователю и по IP клиента.
+//Любой из них может отсутствовать.
+type routeLimiters struct {
+	byUser ratelimit.RateLimiter
+	byIP   ratelimit.RateLimiter
+}
+
+func newRouteLimiters(cfg config.Config) (map[string]routeLimiters, error) {
+	byUser, err := ratelimit.ParseLimits(cfg.RateLimitsByUser)
+	if err != nil {
+		return nil, err
+	}
+	byIP, err := ratelimit.ParseLimits(cfg.RateLimitsByIP)
+	if err != nil {
+		return nil, err
+	}
+
+	limiters := make(map[string]routeLimiters)
+	for route, limit := range byUser {
+		rl := limiters[route]
+		rl.byUser = ratelimit.New(limit)
+		limiters[route] = rl
+	}
+	for route, limit := range byIP {
+		rl := limiters[route]
+		rl.byIP = ratelimit.New(limit)
+		limiters[route] = rl
+	}
+	return limiters, nil
+}
+
+//clientIP адрес клиента из соединения: заголовкам, которые клиент
+//может подделать, ограничитель запросов не доверяет
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+//GetRateLimitMiddleware ограничивает частоту запросов к маршруту route
+//по идентификатору авторизованного пользователя и по IP клиента.
+//Должен подключаться после middleware авторизации. Если хотя бы один
+//ограничитель исчерпан, запрос отклоняется с кодом 429, а токены
+//остальных не расходуются.
+func (h *URLhandlerImpl) GetRateLimitMiddleware(route string) func(next http.Handler) http.Handler {
+	rl, ok := h.limiters[route]
+	return func(next http.Handler) http.Handler {
+		if !ok {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var limiters []ratelimit.RateLimiter
+			var keys []string
+			if rl.byUser != nil {
+				if userID, err := h.userID(r); err == nil {
+					limiters = append(limiters, rl.byUser)
+					keys = append(keys, userID)
+				}
+			}
+			if rl.byIP != nil {
+				limiters = append(limiters, rl.byIP)
+				keys = append(keys, clientIP(r))
+			}
+			if len(limiters) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			results := make([]ratelimit.Result, len(limiters))
+			allowed := true
+			for i, l := range limiters {
+				results[i] = l.Peek(keys[i])
+				allowed = allowed && results[i].Allowed
+			}
+			if allowed {
+				for i, l := range limiters {
+					results[i] = l.Allow(keys[i])
+				}
+			}
+
+			//в заголовках отражается самый строгий из ограничителей
+			strictest := results[0]
+			for _, res := range results[1:] {
+				if !res.Allowed && strictest.Allowed ||
+					res.Allowed == strictest.Allowed && res.Remaining < strictest.Remaining {
+					strictest = res
+				}
+			}
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(strictest.Limit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(strictest.Remaining))
+			w.Header().Set("X-RateLimit-Reset", seconds(strictest.Reset))
+			if !strictest.Allowed {
+				w.Header().Set("Retry-After", seconds(strictest.RetryAfter))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
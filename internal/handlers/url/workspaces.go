@@ -0,0 +1,148 @@
+package url
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/service/workspace"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+type createWorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+type setMemberRequest struct {
+	Email string      `json:"email"`
+	Role  common.Role `json:"role"`
+}
+
+//workspaceErrorStatus возвращает HTTP-статус для ошибки сервиса рабочих пространств
+func workspaceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, workspace.ErrInvalidName), errors.Is(err, workspace.ErrInvalidRole):
+		return http.StatusBadRequest
+	case errors.Is(err, workspace.ErrAccountRequired):
+		return http.StatusForbidden
+	case errors.Is(err, workspace.ErrLastOwner):
+		return http.StatusConflict
+	case errors.Is(err, storages.ErrNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+//memberManager возвращает пользователя для создания рабочих пространств
+//и управления участниками. Как и ключами, ими управляет только сам
+//пользователь: скрипт с ключом для сокращения не должен раздавать роли.
+func (h *URLhandlerImpl) memberManager(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if _, ok := common.RequestAPIKey(r.Context()); ok {
+		http.Error(w, "API keys cannot manage workspaces", http.StatusForbidden)
+		return "", false
+	}
+	userID, err := h.userID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+	return userID, true
+}
+
+//CreateWorkspace эндпоинт POST /api/workspaces принимает JSON {"name": ...}
+//и возвращает ответ с кодом 201 и рабочим пространством, владельцем
+//которого становится пользователь
+func (h *URLhandlerImpl) CreateWorkspace(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.memberManager(w, r)
+	if !ok {
+		return
+	}
+	var req createWorkspaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ws, err := h.workspaces.Create(r.Context(), userID, req.Name)
+	if err != nil {
+		writeError(w, err, workspaceErrorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, ws)
+}
+
+//GetWorkspaces эндпоинт GET /api/workspaces возвращает рабочие
+//пространства пользователя с его ролью в каждом
+func (h *URLhandlerImpl) GetWorkspaces(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	workspaces, err := h.workspaces.List(r.Context(), userID)
+	if err != nil {
+		writeError(w, err, workspaceErrorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, workspaces)
+}
+
+//GetMembers эндпоинт GET /api/workspaces/{id}/members возвращает
+//участников рабочего пространства, 404 если пользователь в нём не состоит
+func (h *URLhandlerImpl) GetMembers(w http.ResponseWriter, r *http.Request) {
+	userID, err := h.userID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	members, err := h.workspaces.Members(r.Context(), userID, chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, err, workspaceErrorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+//SetMember эндпоинт POST /api/workspaces/{id}/members принимает JSON
+//{"email": ..., "role": "owner|editor|viewer"}, добавляет учётную запись
+//в рабочее пространство или меняет её роль. Доступен только владельцам.
+func (h *URLhandlerImpl) SetMember(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.memberManager(w, r)
+	if !ok {
+		return
+	}
+	var req setMemberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	member, err := h.workspaces.SetMember(r.Context(), userID, chi.URLParam(r, "id"), req.Email, req.Role)
+	if err != nil {
+		writeError(w, err, workspaceErrorStatus(err))
+		return
+	}
+	writeJSON(w, http.StatusOK, member)
+}
+
+//RemoveMember эндпоинт DELETE /api/workspaces/{id}/members/{userID}
+//исключает участника и возвращает 204
+func (h *URLhandlerImpl) RemoveMember(w http.ResponseWriter, r *http.Request) {
+	userID, ok := h.memberManager(w, r)
+	if !ok {
+		return
+	}
+	err := h.workspaces.RemoveMember(r.Context(), userID, chi.URLParam(r, "id"), chi.URLParam(r, "userID"))
+	if err != nil {
+		writeError(w, err, workspaceErrorStatus(err))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,174 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+var ErrVerificationFailed = errors.New("verification failed")
+
+type Options struct {
+	BatchSize int
+	//CheckpointPath файл, в котором запоминается последний перенесённый ID
+	CheckpointPath string
+	//SampleSize число случайных ссылок, проверяемых после переноса
+	SampleSize int
+}
+
+type checkpoint struct {
+	LastID string `json:"last_id"`
+	Copied int    `json:"copied"`
+}
+
+type Migrator struct {
+	from storages.Storage
+	to   storages.Storage
+	opts Options
+}
+
+type VerifyReport struct {
+	SourceCount      int
+	DestinationCount int
+	Sampled          int
+	Mismatches       []string
+}
+
+func New(from, to storages.Storage, opts Options) *Migrator {
+	return &Migrator{from: from, to: to, opts: opts}
+}
+
+//Run переносит ссылки пачками по возрастанию ID. После каждой пачки
+//позиция сохраняется в контрольную точку, поэтому прерванный перенос
+//продолжается с места остановки.
+func (m *Migrator) Run(ctx context.Context) (int, error) {
+	cp, err := m.loadCheckpoint()
+	if err != nil {
+		return 0, err
+	}
+	if cp.LastID != "" {
+		log.Printf("resuming from checkpoint: %d links copied, last ID %s", cp.Copied, cp.LastID)
+	}
+
+	for {
+		links, err := m.from.GetLinks(ctx, cp.LastID, m.opts.BatchSize)
+		if err != nil {
+			return cp.Copied, fmt.Errorf("read batch after %q: %w", cp.LastID, err)
+		}
+		if len(links) == 0 {
+			return cp.Copied, nil
+		}
+		if err = m.to.InsertLinks(ctx, links); err != nil {
+			return cp.Copied, fmt.Errorf("write batch after %q: %w", cp.LastID, err)
+		}
+
+		cp.LastID = links[len(links)-1].ID
+		cp.Copied += len(links)
+		if err = m.saveCheckpoint(cp); err != nil {
+			return cp.Copied, err
+		}
+		log.Printf("copied %d links, last ID %s", cp.Copied, cp.LastID)
+	}
+}
+
+//Verify сравнивает число ссылок в хранилищах и проверяет случайную
+//выборку ссылок источника в целевом хранилище
+func (m *Migrator) Verify(ctx context.Context) (VerifyReport, error) {
+	var report VerifyReport
+	sample := make([]common.Link, 0, m.opts.SampleSize)
+
+	err := forEachLink(ctx, m.from, m.opts.BatchSize, func(l common.Link) {
+		report.SourceCount++
+		//reservoir sampling: каждая ссылка попадает в выборку с равной вероятностью
+		if len(sample) < m.opts.SampleSize {
+			sample = append(sample, l)
+		} else if i := rand.Intn(report.SourceCount); i < m.opts.SampleSize {
+			sample[i] = l
+		}
+	})
+	if err != nil {
+		return report, err
+	}
+	err = forEachLink(ctx, m.to, m.opts.BatchSize, func(common.Link) {
+		report.DestinationCount++
+	})
+	if err != nil {
+		return report, err
+	}
+
+	for _, l := range sample {
+		report.Sampled++
+		//GetLink сам приводит ID к формату ключа хранилища
+		got, err := m.to.GetLink(ctx, l.ID)
+		if err != nil {
+			report.Mismatches = append(report.Mismatches, fmt.Sprintf("%s: %v", l.ID, err))
+			continue
+		}
+		if got.ExpandURL != l.ExpandURL {
+			report.Mismatches = append(report.Mismatches,
+				fmt.Sprintf("%s: expected %s, got %s", l.ID, l.ExpandURL, got.ExpandURL))
+		}
+	}
+
+	if report.DestinationCount < report.SourceCount || len(report.Mismatches) > 0 {
+		return report, ErrVerificationFailed
+	}
+	return report, nil
+}
+
+func forEachLink(ctx context.Context, stg storages.Storage, batchSize int, fn func(l common.Link)) error {
+	afterID := ""
+	for {
+		links, err := stg.GetLinks(ctx, afterID, batchSize)
+		if err != nil {
+			return err
+		}
+		if len(links) == 0 {
+			return nil
+		}
+		for _, l := range links {
+			fn(l)
+		}
+		afterID = links[len(links)-1].ID
+	}
+}
+
+func (m *Migrator) loadCheckpoint() (checkpoint, error) {
+	var cp checkpoint
+	if m.opts.CheckpointPath == "" {
+		return cp, nil
+	}
+	data, err := os.ReadFile(m.opts.CheckpointPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+	err = json.Unmarshal(data, &cp)
+	return cp, err
+}
+
+//saveCheckpoint записывает контрольную точку через временный файл,
+//чтобы прерывание не оставило её повреждённой
+func (m *Migrator) saveCheckpoint(cp checkpoint) error {
+	if m.opts.CheckpointPath == "" {
+		return nil
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := m.opts.CheckpointPath + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.opts.CheckpointPath)
+}
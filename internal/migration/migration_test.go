@@ -0,0 +1,82 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+	src, _ := storages.NewInMemoryStorage()
+	dst, _ := storages.NewInMemoryStorage()
+	src.InsertLinks(ctx, []common.Link{
+		{ID: "id1", ExpandURL: "http://ya.ru", UserID: "user1"},
+		{ID: "id2", ExpandURL: "http://yandex.ru", UserID: "user1"},
+		{ID: "id3", ExpandURL: "http://practicum.yandex.ru", UserID: "user2"},
+	})
+
+	opts := Options{
+		BatchSize:      2,
+		CheckpointPath: filepath.Join(t.TempDir(), "checkpoint"),
+		SampleSize:     10,
+	}
+	copied, err := New(src, dst, opts).Run(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, copied)
+
+	pairs, err := dst.GetPairsByID(ctx, "user1")
+	require.NoError(t, err)
+	assert.Len(t, pairs, 2)
+
+	//повторный запуск продолжает с контрольной точки и копирует только новые ссылки
+	src.InsertLinks(ctx, []common.Link{{ID: "id4", ExpandURL: "http://go.dev", UserID: "user2"}})
+	copied, err = New(src, dst, opts).Run(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, copied)
+
+	report, err := New(src, dst, opts).Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.SourceCount)
+	assert.Equal(t, 4, report.DestinationCount)
+	assert.Empty(t, report.Mismatches)
+}
+
+//slashKeyStorage хранит ключи с ведущим "/", как dbStorage: LookUp ищет
+//ключ как есть, а GetLink сам добавляет "/"
+type slashKeyStorage struct {
+	storages.Storage
+}
+
+func (s slashKeyStorage) LookUp(ctx context.Context, key string) (string, error) {
+	if !strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("short URL %s: %w", key, storages.ErrNotFound)
+	}
+	return s.Storage.LookUp(ctx, key)
+}
+
+func TestVerifySlashKeyDestination(t *testing.T) {
+	ctx := context.Background()
+	src, _ := storages.NewInMemoryStorage()
+	mem, _ := storages.NewInMemoryStorage()
+	dst := slashKeyStorage{Storage: mem}
+	src.InsertLinks(ctx, []common.Link{
+		{ID: "id1", ExpandURL: "http://ya.ru", UserID: "user1"},
+		{ID: "id2", ExpandURL: "http://yandex.ru", UserID: "user1"},
+	})
+
+	opts := Options{BatchSize: 10, SampleSize: 10}
+	_, err := New(src, dst, opts).Run(ctx)
+	require.NoError(t, err)
+	report, err := New(src, dst, opts).Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Sampled)
+	assert.Empty(t, report.Mismatches)
+}
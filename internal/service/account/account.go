@@ -0,0 +1,169 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/service/audit"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	minPasswordLength = 8
+	//maxPasswordLength bcrypt учитывает только первые 72 байта пароля
+	maxPasswordLength = 72
+)
+
+var (
+	ErrInvalidEmail       = errors.New("invalid email")
+	ErrPasswordTooShort   = errors.New("password must be at least 8 bytes")
+	ErrPasswordTooLong    = errors.New("password must not exceed 72 bytes")
+	ErrEmailTaken         = errors.New("email is already registered")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+)
+
+//dummyHash сравнивается с паролем, если email не найден, чтобы время
+//ответа не выдавало, зарегистрирован ли адрес
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+type accountServiceImpl struct {
+	storage        storages.Storage
+	storageTimeout time.Duration
+	now            func() time.Time
+	audit          audit.AuditService
+}
+
+func New(stg storages.Storage, cfg config.Config) AccountService {
+	return &accountServiceImpl{
+		storage:        stg,
+		storageTimeout: cfg.StorageTimeout,
+		now:            time.Now,
+		audit:          audit.New(stg, cfg),
+	}
+}
+
+func (s *accountServiceImpl) withStorageTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.storageTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.storageTimeout)
+}
+
+//normalizeEmail принимает только адрес без имени и приводит его к нижнему регистру
+func normalizeEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(email), nil
+}
+
+func (s *accountServiceImpl) Register(ctx context.Context, anonymousID, email, password string) (common.Account, error) {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return common.Account{}, err
+	}
+	if len(password) < minPasswordLength {
+		return common.Account{}, ErrPasswordTooShort
+	}
+	if len(password) > maxPasswordLength {
+		return common.Account{}, ErrPasswordTooLong
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return common.Account{}, err
+	}
+	account := common.Account{
+		ID:           uuid.New().String(),
+		Email:        email,
+		PasswordHash: string(hash),
+		CreatedAt:    s.now().UTC(),
+	}
+
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	err = s.storage.InsertAccount(ctx, account)
+	if errors.Is(err, storages.ErrAlreadyExists) {
+		return common.Account{}, ErrEmailTaken
+	}
+	if err != nil {
+		return common.Account{}, err
+	}
+	s.merge(ctx, anonymousID, account)
+	return account, nil
+}
+
+func (s *accountServiceImpl) Login(ctx context.Context, anonymousID, email, password string) (common.Account, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return common.Account{}, ErrInvalidCredentials
+	}
+	account, err := s.storage.GetAccountByEmail(ctx, email)
+	if errors.Is(err, storages.ErrNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return common.Account{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return common.Account{}, err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)) != nil {
+		return common.Account{}, ErrInvalidCredentials
+	}
+	s.merge(ctx, anonymousID, account)
+	return account, nil
+}
+
+//ownerValue значение в журнале аудита при смене владельца ссылки
+type ownerValue struct {
+	UserID string `json:"user_id"`
+}
+
+//merge передаёт учётной записи ссылки анонимного пользователя. Ссылки
+//другой учётной записи не передаются: вход в чужую учётную запись из
+//своей сессии не должен лишать владельца его ссылок. Ошибка объединения
+//не мешает входу, ссылки останутся у анонимного пользователя.
+func (s *accountServiceImpl) merge(ctx context.Context, anonymousID string, account common.Account) {
+	if anonymousID == "" || anonymousID == account.ID {
+		return
+	}
+	_, err := s.storage.GetAccount(ctx, anonymousID)
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, storages.ErrNotFound) {
+		log.Printf("unable to merge links of %s into account %s: %v", anonymousID, account.ID, err)
+		return
+	}
+	//список нужен только журналу аудита: ссылки, созданные между чтением
+	//списка и передачей, передаются без записи в журнал
+	pairs, err := s.storage.GetPairsByID(ctx, anonymousID)
+	if errors.Is(err, storages.ErrNotFound) || err == nil && len(pairs) == 0 {
+		return
+	}
+	if err != nil {
+		log.Printf("unable to merge links of %s into account %s: %v", anonymousID, account.ID, err)
+		return
+	}
+	n, err := s.storage.ReassignLinks(ctx, anonymousID, account.ID)
+	if err != nil {
+		log.Printf("unable to merge links of %s into account %s: %v", anonymousID, account.ID, err)
+		return
+	}
+	for _, p := range pairs {
+		s.audit.Record(ctx, account.ID, common.AuditReassign, p.ShortURL,
+			ownerValue{UserID: anonymousID}, ownerValue{UserID: account.ID})
+	}
+	log.Printf("merged %d links of %s into account %s", n, anonymousID, account.ID)
+}
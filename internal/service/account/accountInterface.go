@@ -0,0 +1,18 @@
+package account
+
+import (
+	"context"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+)
+
+var _ AccountService = &accountServiceImpl{}
+
+type AccountService interface {
+	//Register создаёт учётную запись и передаёт ей ссылки анонимного
+	//пользователя anonymousID. anonymousID может быть пустым.
+	Register(ctx context.Context, anonymousID, email, password string) (common.Account, error)
+	//Login проверяет email и пароль и, как и Register, передаёт учётной
+	//записи ссылки анонимного пользователя anonymousID
+	Login(ctx context.Context, anonymousID, email, password string) (common.Account, error)
+}
@@ -0,0 +1,65 @@
+package account
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisterAndLogin(t *testing.T) {
+	ctx := context.Background()
+	stg, _ := storages.NewInMemoryStorage()
+	s := New(stg, config.Config{})
+
+	require.NoError(t, stg.Insert(ctx, "id1", "http://ya.ru", "anonymous"))
+	a, err := s.Register(ctx, "anonymous", " User@Example.com ", "password1")
+	require.NoError(t, err)
+	assert.Equal(t, "user@example.com", a.Email)
+	assert.NotEqual(t, "password1", a.PasswordHash)
+	count, err := stg.CountByUser(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	_, err = s.Register(ctx, "", "user@example.com", "password2")
+	assert.ErrorIs(t, err, ErrEmailTaken)
+	_, err = s.Register(ctx, "", "Name <name@example.com>", "password1")
+	assert.ErrorIs(t, err, ErrInvalidEmail)
+	_, err = s.Register(ctx, "", "short@example.com", "short")
+	assert.ErrorIs(t, err, ErrPasswordTooShort)
+
+	require.NoError(t, stg.Insert(ctx, "id2", "http://ya.ru/2", "device2"))
+	got, err := s.Login(ctx, "device2", "USER@example.com", "password1")
+	require.NoError(t, err)
+	assert.Equal(t, a.ID, got.ID)
+	count, err = stg.CountByUser(ctx, a.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	_, err = s.Login(ctx, "", "user@example.com", "wrong password")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = s.Login(ctx, "", "nobody@example.com", "password1")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+func TestLoginKeepsOtherAccountLinks(t *testing.T) {
+	ctx := context.Background()
+	stg, _ := storages.NewInMemoryStorage()
+	s := New(stg, config.Config{})
+
+	first, err := s.Register(ctx, "", "first@example.com", "password1")
+	require.NoError(t, err)
+	second, err := s.Register(ctx, "", "second@example.com", "password2")
+	require.NoError(t, err)
+	require.NoError(t, stg.Insert(ctx, "id1", "http://ya.ru", first.ID))
+
+	//вход во вторую учётную запись из сессии первой
+	_, err = s.Login(ctx, first.ID, second.Email, "password2")
+	require.NoError(t, err)
+	count, err := stg.CountByUser(ctx, first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
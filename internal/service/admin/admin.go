@@ -0,0 +1,185 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/service/audit"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+
+	//Actor исполнитель действий администратора в журнале аудита
+	Actor = "admin"
+
+	maxReasonLength = 500
+)
+
+var (
+	ErrInvalidDomain = errors.New("domain must be a host name with at least two labels")
+	ErrInvalidReason = errors.New("reason must be at most 500 characters")
+)
+
+type adminServiceImpl struct {
+	storage        storages.Storage
+	storageTimeout time.Duration
+	audit          audit.AuditService
+	now            func() time.Time
+}
+
+func New(stg storages.Storage, cfg config.Config) AdminService {
+	return &adminServiceImpl{
+		storage:        stg,
+		storageTimeout: cfg.StorageTimeout,
+		audit:          audit.New(stg, cfg),
+		now:            time.Now,
+	}
+}
+
+func (s *adminServiceImpl) withStorageTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.storageTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.storageTimeout)
+}
+
+func (s *adminServiceImpl) SearchLinks(ctx context.Context, filter common.LinkFilter) ([]common.Link, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultLimit
+	}
+	if filter.Limit > MaxLimit {
+		filter.Limit = MaxLimit
+	}
+	if filter.Domain != "" {
+		domain, err := normalizeDomain(filter.Domain)
+		if err != nil {
+			return nil, err
+		}
+		filter.Domain = domain
+	}
+	filter.AfterID = strings.Trim(filter.AfterID, "/")
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	return s.storage.SearchLinks(ctx, filter)
+}
+
+//Link дополняет ссылку email владельца и рабочим пространством, если они есть
+func (s *adminServiceImpl) Link(ctx context.Context, urlID string) (common.LinkDetails, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.storage.GetLink(ctx, urlID)
+	if err != nil {
+		return common.LinkDetails{}, err
+	}
+	details := common.LinkDetails{
+		Link:  link,
+		Owner: common.LinkOwner{UserID: link.UserID},
+		Stats: common.LinkStats{HasPassword: link.PasswordHash != ""},
+	}
+	account, err := s.storage.GetAccount(ctx, link.UserID)
+	if err == nil {
+		details.Owner.Email = account.Email
+	} else if !errors.Is(err, storages.ErrNotFound) {
+		return common.LinkDetails{}, err
+	}
+	if link.WorkspaceID != "" {
+		workspace, err := s.storage.GetWorkspace(ctx, link.WorkspaceID)
+		if err != nil {
+			return common.LinkDetails{}, err
+		}
+		details.Owner.Workspace = &workspace
+	}
+	if link.ClickLimit > 0 {
+		details.Stats.ClicksUsed = link.ClickLimit - link.ClicksLeft
+		details.Stats.ClicksLeft = link.ClicksLeft
+	}
+	for _, v := range link.Variants {
+		details.Stats.VariantClicks += v.Clicks
+	}
+	return details, nil
+}
+
+func (s *adminServiceImpl) block(legal bool, reason string) (common.LinkBlock, error) {
+	reason = strings.TrimSpace(reason)
+	if utf8.RuneCountInString(reason) > maxReasonLength {
+		return common.LinkBlock{}, ErrInvalidReason
+	}
+	return common.LinkBlock{Legal: legal, Reason: reason, Time: s.now().UTC()}, nil
+}
+
+func (s *adminServiceImpl) Disable(ctx context.Context, urlID string, legal bool,
+	reason string) (common.Link, error) {
+	block, err := s.block(legal, reason)
+	if err != nil {
+		return common.Link{}, err
+	}
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.storage.GetLink(ctx, urlID)
+	if err != nil {
+		return common.Link{}, err
+	}
+	if err = s.storage.SetDisabled(ctx, urlID, &block); err != nil {
+		return common.Link{}, err
+	}
+	s.audit.Record(ctx, Actor, common.AuditDisable, link.ID, link.Disabled, block)
+	link.Disabled = &block
+	return link, nil
+}
+
+//Enable ничего не делает с работающей ссылкой
+func (s *adminServiceImpl) Enable(ctx context.Context, urlID string) (common.Link, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.storage.GetLink(ctx, urlID)
+	if err != nil || link.Disabled == nil {
+		return link, err
+	}
+	if err = s.storage.SetDisabled(ctx, urlID, nil); err != nil {
+		return common.Link{}, err
+	}
+	s.audit.Record(ctx, Actor, common.AuditEnable, link.ID, link.Disabled, nil)
+	link.Disabled = nil
+	return link, nil
+}
+
+func (s *adminServiceImpl) DisableDomain(ctx context.Context, domain string, legal bool,
+	reason string) ([]string, error) {
+	domain, err := normalizeDomain(domain)
+	if err != nil {
+		return nil, err
+	}
+	block, err := s.block(legal, reason)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	ids, err := s.storage.DisableByDomain(ctx, domain, block)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		s.audit.Record(ctx, Actor, common.AuditDisable, id, nil, block)
+	}
+	return ids, nil
+}
+
+//normalizeDomain приводит домен к нижнему регистру. Домен из одной метки
+//не принимается, чтобы опечатка не заблокировала целую доменную зону.
+func normalizeDomain(domain string) (string, error) {
+	domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if !strings.Contains(domain, ".") || strings.ContainsAny(domain, "/:@?#[] ") ||
+		strings.Contains(domain, "..") {
+		return "", ErrInvalidDomain
+	}
+	return domain, nil
+}
@@ -0,0 +1,20 @@
+package admin
+
+import (
+	"context"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+)
+
+var _ AdminService = &adminServiceImpl{}
+
+type AdminService interface {
+	SearchLinks(ctx context.Context, filter common.LinkFilter) ([]common.Link, error)
+	Link(ctx context.Context, urlID string) (common.LinkDetails, error)
+	//Disable блокирует ссылку, повторная блокировка заменяет её причину
+	Disable(ctx context.Context, urlID string, legal bool, reason string) (common.Link, error)
+	Enable(ctx context.Context, urlID string) (common.Link, error)
+	//DisableDomain блокирует все работающие ссылки на домен и его
+	//поддомены и возвращает их ID
+	DisableDomain(ctx context.Context, domain string, legal bool, reason string) ([]string, error)
+}
@@ -0,0 +1,63 @@
+package admin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisableAndEnable(t *testing.T) {
+	ctx := context.Background()
+	stg, _ := storages.NewInMemoryStorage()
+	s := New(stg, config.Config{}).(*adminServiceImpl)
+	now := time.Unix(100, 0).UTC()
+	s.now = func() time.Time { return now }
+	require.NoError(t, stg.InsertAccount(ctx, common.Account{ID: "alice", Email: "alice@example.com"}))
+	require.NoError(t, stg.InsertLink(ctx, common.Link{ID: "a", ExpandURL: "https://Mail.Example.com/x", UserID: "alice",
+		ClickLimit: 5, ClicksLeft: 3}))
+	require.NoError(t, stg.InsertLink(ctx, common.Link{ID: "b", ExpandURL: "http://example.com:8080", UserID: "bob"}))
+	require.NoError(t, stg.InsertLink(ctx, common.Link{ID: "c", ExpandURL: "http://notexample.com", UserID: "bob"}))
+
+	details, err := s.Link(ctx, "/a")
+	require.NoError(t, err)
+	assert.Equal(t, common.LinkOwner{UserID: "alice", Email: "alice@example.com"}, details.Owner)
+	assert.Equal(t, common.LinkStats{ClicksUsed: 2, ClicksLeft: 3}, details.Stats)
+
+	link, err := s.Disable(ctx, "/b", false, " spam ")
+	require.NoError(t, err)
+	assert.Equal(t, &common.LinkBlock{Reason: "spam", Time: now}, link.Disabled)
+
+	_, err = s.DisableDomain(ctx, "com", true, "")
+	assert.ErrorIs(t, err, ErrInvalidDomain)
+	ids, err := s.DisableDomain(ctx, "EXAMPLE.com.", true, "court order")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, ids)
+
+	links, err := s.SearchLinks(ctx, common.LinkFilter{Disabled: true})
+	require.NoError(t, err)
+	require.Len(t, links, 2)
+	assert.True(t, links[0].Disabled.Legal)
+	assert.False(t, links[1].Disabled.Legal)
+
+	link, err = s.Enable(ctx, "b")
+	require.NoError(t, err)
+	assert.Nil(t, link.Disabled)
+	links, err = s.SearchLinks(ctx, common.LinkFilter{Query: "EXAMPLE", UserID: "bob"})
+	require.NoError(t, err)
+	require.Len(t, links, 2)
+	assert.Nil(t, links[0].Disabled)
+
+	records, err := stg.GetAudit(ctx, common.AuditFilter{ActorID: Actor, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, common.AuditEnable, records[0].Action)
+	assert.Nil(t, records[0].NewValue)
+	assert.Equal(t, "a", records[1].LinkID)
+	assert.Nil(t, records[2].OldValue)
+}
@@ -0,0 +1,179 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+const (
+	//Header заголовок, в котором передаётся ключ
+	Header = "X-API-Key"
+	//keyPrefix отличает ключи сервиса от других секретов при утечке
+	keyPrefix = "sk_"
+	//visiblePrefix сколько символов ключа показывается в списке ключей
+	visiblePrefix = len(keyPrefix) + 8
+	maxNameLength = 100
+)
+
+var (
+	ErrInvalidKey   = errors.New("invalid API key")
+	ErrInvalidScope = errors.New("scope must be one of shorten, read")
+	ErrNameTooLong  = errors.New("API key name must not exceed 100 characters")
+)
+
+var allScopes = []string{common.ScopeShorten, common.ScopeRead}
+
+type apiKeyServiceImpl struct {
+	storage        storages.Storage
+	storageTimeout time.Duration
+	now            func() time.Time
+}
+
+func New(stg storages.Storage, cfg config.Config) APIKeyService {
+	return &apiKeyServiceImpl{
+		storage:        stg,
+		storageTimeout: cfg.StorageTimeout,
+		now:            time.Now,
+	}
+}
+
+func (s *apiKeyServiceImpl) withStorageTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.storageTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.storageTimeout)
+}
+
+func hash(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//normalizeScopes проверяет области действия, без них ключу разрешено всё
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return append([]string(nil), allScopes...), nil
+	}
+	requested := make(map[string]bool, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		valid := false
+		for _, known := range allScopes {
+			valid = valid || scope == known
+		}
+		if !valid {
+			return nil, fmt.Errorf("%q: %w", scope, ErrInvalidScope)
+		}
+		requested[scope] = true
+	}
+	//порядок областей не зависит от порядка в запросе
+	result := make([]string, 0, len(requested))
+	for _, known := range allScopes {
+		if requested[known] {
+			result = append(result, known)
+		}
+	}
+	return result, nil
+}
+
+func (s *apiKeyServiceImpl) Create(ctx context.Context, userID, name string,
+	scopes []string) (common.APIKey, string, error) {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return common.APIKey{}, "", ErrNameTooLong
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return common.APIKey{}, "", err
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return common.APIKey{}, "", err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return common.APIKey{}, "", err
+	}
+	rawKey := keyPrefix + secret
+	key := common.APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      name,
+		Prefix:    rawKey[:visiblePrefix],
+		Hash:      hash(rawKey),
+		Scopes:    scopes,
+		CreatedAt: s.now().UTC().Truncate(time.Second),
+	}
+
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	if err = s.storage.InsertAPIKey(ctx, key); err != nil {
+		return common.APIKey{}, "", err
+	}
+	return key, rawKey, nil
+}
+
+func (s *apiKeyServiceImpl) List(ctx context.Context, userID string) ([]common.APIKey, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	return s.storage.GetAPIKeys(ctx, userID)
+}
+
+func (s *apiKeyServiceImpl) Revoke(ctx context.Context, userID, id string) error {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	return s.storage.DeleteAPIKey(ctx, userID, id)
+}
+
+func (s *apiKeyServiceImpl) Authenticate(ctx context.Context, rawKey string) (common.APIKey, error) {
+	if !strings.HasPrefix(rawKey, keyPrefix) {
+		return common.APIKey{}, ErrInvalidKey
+	}
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	key, err := s.storage.GetAPIKey(ctx, hash(rawKey))
+	if errors.Is(err, storages.ErrNotFound) {
+		return common.APIKey{}, ErrInvalidKey
+	}
+	return key, err
+}
+
+func (s *apiKeyServiceImpl) Authentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawKey := r.Header.Get(Header)
+		if rawKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key, err := s.Authenticate(r.Context(), rawKey)
+		if errors.Is(err, ErrInvalidKey) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(common.WithAPIKey(r.Context(), key)))
+	})
+}
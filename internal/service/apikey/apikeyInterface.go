@@ -0,0 +1,23 @@
+package apikey
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+)
+
+var _ APIKeyService = &apiKeyServiceImpl{}
+
+type APIKeyService interface {
+	//Create выпускает ключ. Значение ключа возвращается только здесь,
+	//хранится лишь его хеш.
+	Create(ctx context.Context, userID, name string, scopes []string) (common.APIKey, string, error)
+	List(ctx context.Context, userID string) ([]common.APIKey, error)
+	Revoke(ctx context.Context, userID, id string) error
+	//Authenticate возвращает ключ по значению из заголовка X-API-Key
+	Authenticate(ctx context.Context, rawKey string) (common.APIKey, error)
+	//Authentication авторизует запросы с заголовком X-API-Key от имени
+	//владельца ключа, остальные запросы передаёт дальше без изменений
+	Authentication(next http.Handler) http.Handler
+}
@@ -0,0 +1,87 @@
+package apikey
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	ctx := context.Background()
+	stg, _ := storages.NewInMemoryStorage()
+	s := New(stg, config.Config{})
+
+	key, rawKey, err := s.Create(ctx, "user", "ci", []string{"READ", "shorten", "read"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{common.ScopeShorten, common.ScopeRead}, key.Scopes)
+	assert.True(t, strings.HasPrefix(rawKey, key.Prefix))
+	assert.NotContains(t, key.Hash, rawKey)
+
+	got, err := s.Authenticate(ctx, rawKey)
+	require.NoError(t, err)
+	assert.Equal(t, "user", got.UserID)
+	_, err = s.Authenticate(ctx, rawKey+"x")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+
+	full, _, err := s.Create(ctx, "user", "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{common.ScopeShorten, common.ScopeRead}, full.Scopes)
+	_, _, err = s.Create(ctx, "user", "", []string{"admin"})
+	assert.ErrorIs(t, err, ErrInvalidScope)
+	//области без проверяющего эндпоинта не выдаются
+	_, _, err = s.Create(ctx, "user", "", []string{"delete"})
+	assert.ErrorIs(t, err, ErrInvalidScope)
+
+	keys, err := s.List(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	assert.ErrorIs(t, s.Revoke(ctx, "other", key.ID), storages.ErrNotFound)
+	require.NoError(t, s.Revoke(ctx, "user", key.ID))
+	_, err = s.Authenticate(ctx, rawKey)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestAuthentication(t *testing.T) {
+	stg, _ := storages.NewInMemoryStorage()
+	s := New(stg, config.Config{})
+	_, rawKey, err := s.Create(context.Background(), "user", "", []string{common.ScopeRead})
+	require.NoError(t, err)
+
+	serve := func(header string) (int, string, bool) {
+		r := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+		if header != "" {
+			r.Header.Set(Header, header)
+		}
+		w := httptest.NewRecorder()
+		var userID string
+		var canShorten bool
+		s.Authentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, _ = common.UserID(r.Context())
+			canShorten = common.Authorized(r.Context(), common.ScopeShorten)
+		})).ServeHTTP(w, r)
+		return w.Code, userID, canShorten
+	}
+
+	code, userID, canShorten := serve(rawKey)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "user", userID)
+	assert.False(t, canShorten)
+
+	code, _, _ = serve("sk_forged")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	//без ключа запрос передаётся дальше, авторизацию выполнит cookie
+	code, userID, canShorten = serve("")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, userID)
+	assert.True(t, canShorten)
+}
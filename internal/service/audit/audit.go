@@ -0,0 +1,91 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+type auditServiceImpl struct {
+	storage        storages.Storage
+	storageTimeout time.Duration
+	now            func() time.Time
+}
+
+func New(stg storages.Storage, cfg config.Config) AuditService {
+	return &auditServiceImpl{
+		storage:        stg,
+		storageTimeout: cfg.StorageTimeout,
+		now:            time.Now,
+	}
+}
+
+func (s *auditServiceImpl) withStorageTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.storageTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.storageTimeout)
+}
+
+//marshal возвращает nil и для nil-указателей и срезов, которые
+//json.Marshal превращает в null
+func marshal(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("unable to marshal audit value: %v", err)
+		return nil
+	}
+	if string(data) == "null" {
+		return nil
+	}
+	return data
+}
+
+//Record не возвращает ошибку: изменение к этому моменту уже сохранено,
+//и отказ журнала не должен выглядеть для клиента как отказ в изменении.
+//Запись не привязана к отмене запроса, чтобы не потеряться при обрыве
+//соединения клиента.
+func (s *auditServiceImpl) Record(ctx context.Context, actorID, action, linkID string,
+	oldValue, newValue interface{}) {
+	record := common.AuditRecord{
+		Time:      s.now().UTC(),
+		ActorID:   actorID,
+		Action:    action,
+		LinkID:    strings.Trim(linkID, "/"),
+		OldValue:  marshal(oldValue),
+		NewValue:  marshal(newValue),
+		RequestID: common.RequestID(ctx),
+	}
+	ctx, cancel := s.withStorageTimeout(context.Background())
+	defer cancel()
+	if _, err := s.storage.AppendAudit(ctx, record); err != nil {
+		log.Printf("unable to write audit record %s of %s by %s: %v", action, record.LinkID, actorID, err)
+	}
+}
+
+func (s *auditServiceImpl) Query(ctx context.Context, filter common.AuditFilter) ([]common.AuditRecord, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultLimit
+	}
+	if filter.Limit > MaxLimit {
+		filter.Limit = MaxLimit
+	}
+	filter.LinkID = strings.Trim(filter.LinkID, "/")
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	return s.storage.GetAudit(ctx, filter)
+}
@@ -0,0 +1,17 @@
+package audit
+
+import (
+	"context"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+)
+
+var _ AuditService = &auditServiceImpl{}
+
+type AuditService interface {
+	//Record дописывает в журнал действие actorID над ссылкой linkID вместе
+	//с прежним и новым значениями, nil означает отсутствие значения.
+	//Идентификатор запроса берётся из контекста.
+	Record(ctx context.Context, actorID, action, linkID string, oldValue, newValue interface{})
+	Query(ctx context.Context, filter common.AuditFilter) ([]common.AuditRecord, error)
+}
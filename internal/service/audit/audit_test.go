@@ -0,0 +1,47 @@
+package audit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndQuery(t *testing.T) {
+	stg, _ := storages.NewInMemoryStorage()
+	s := New(stg, config.Config{}).(*auditServiceImpl)
+	now := time.Unix(100, 0).UTC()
+	s.now = func() time.Time { return now }
+
+	ctx := common.WithRequestID(context.Background(), "req-1")
+	s.Record(ctx, "alice", common.AuditCreate, "/id1", nil, common.Link{ID: "id1", ExpandURL: "http://ya.ru"})
+	now = now.Add(time.Minute)
+	s.Record(ctx, "alice", common.AuditUpdateUTM, "id1", common.UTM{}, common.UTM{Source: "news"})
+	s.Record(context.Background(), "bob", common.AuditCreate, "id2", nil, nil)
+
+	records, err := s.Query(context.Background(), common.AuditFilter{LinkID: "/id1"})
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, common.AuditUpdateUTM, records[0].Action)
+	assert.JSONEq(t, `{"source":"news"}`, string(records[0].NewValue))
+	assert.Equal(t, "req-1", records[1].RequestID)
+	assert.Nil(t, records[1].OldValue)
+	assert.Equal(t, time.Unix(100, 0).UTC(), records[1].Time)
+
+	records, err = s.Query(context.Background(), common.AuditFilter{Action: common.AuditCreate, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "bob", records[0].ActorID)
+	assert.Empty(t, records[0].RequestID)
+
+	records, err = s.Query(context.Background(), common.AuditFilter{BeforeID: records[0].ID, Until: now})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, common.AuditCreate, records[0].Action)
+	assert.Equal(t, "id1", records[0].LinkID)
+}
@@ -2,104 +2,255 @@ package cookie
 
 import (
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
-	"io"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+)
+
+const (
+	cookieName = "userID"
+	//payloadVersion префикс значения с временем выдачи и истечения,
+	//cookie прежнего формата содержали только идентификатор пользователя
+	payloadVersion = "v1"
+	//publishedKey ключ подписи по умолчанию прежних версий. Он есть
+	//в исходниках, поэтому подписанные им cookie может подделать любой.
+	publishedKey = "SuperSecretKey2022"
 )
 
 var (
-	ErrInvalidValue = errors.New("invalid cookie value")
+	ErrInvalidValue    = errors.New("invalid cookie value")
+	ErrExpired         = errors.New("cookie expired")
+	ErrInvalidSameSite = errors.New("COOKIE_SAME_SITE must be one of lax, strict, none")
+	ErrPublishedKey    = errors.New("cookie signing key " + publishedKey + " is public, set another SECRET_KEYS")
 )
 
 type cookieServiceImpl struct {
-	Key []byte
+	//keys первый ключ подписывает новые cookie, все ключи проверяют подпись
+	keys     [][]byte
+	maxAge   time.Duration
+	secure   bool
+	sameSite http.SameSite
+	domain   string
+	//legacyUntil до этого момента принимаются cookie прежнего формата,
+	//нулевое значение запрещает их
+	legacyUntil time.Time
+	now         func() time.Time
+	//tokens nil, если bearer-токены не принимаются
+	tokens *jwtVerifier
 }
 
-func New(key string) *cookieServiceImpl {
-	return &cookieServiceImpl{Key: []byte(key)}
+//session содержимое проверенной cookie
+type session struct {
+	userID    string
+	issuedAt  time.Time
+	expiresAt time.Time
+	//keyIndex номер ключа, которым подписана cookie
+	keyIndex int
+	legacy   bool
 }
 
-func (c *cookieServiceImpl) ExtractValue(cookie *http.Cookie) (string, error) {
-	signedValue, err := base64.URLEncoding.DecodeString(cookie.Value)
-	if err != nil {
-		return "", err
+func New(cfg config.Config) (CookieService, error) {
+	c := &cookieServiceImpl{
+		maxAge: cfg.CookieMaxAge,
+		secure: cfg.CookieSecure,
+		domain: cfg.CookieDomain,
+		now:    time.Now,
+	}
+	for _, k := range cfg.SecretKeys {
+		if k = strings.TrimSpace(k); k != "" {
+			c.keys = append(c.keys, []byte(k))
+		}
+	}
+	if len(c.keys) == 0 && cfg.Key != "" {
+		c.keys = [][]byte{[]byte(cfg.Key)}
+	}
+	for _, k := range c.keys {
+		if string(k) == publishedKey {
+			return nil, ErrPublishedKey
+		}
+	}
+	if len(c.keys) == 0 {
+		key := make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		c.keys = [][]byte{key}
+		log.Printf("cookie signing key is not set, sessions will not survive a restart, set SECRET_KEYS")
+	}
+	if cfg.LegacyCookiesUntil != "" {
+		until, err := time.Parse(time.RFC3339, cfg.LegacyCookiesUntil)
+		if err != nil {
+			return nil, fmt.Errorf("LEGACY_COOKIES_UNTIL: %w", err)
+		}
+		c.legacyUntil = until
 	}
-	return string(signedValue[sha256.Size:]), nil
-}
 
-func (c *cookieServiceImpl) CreateAndSign(w http.ResponseWriter, r *http.Request) error {
-	cookie := http.Cookie{
-		Name:     "userID",
-		Value:    uuid.New().String(),
-		HttpOnly: true,
-		Secure:   false,
+	if cfg.JWTAuth {
+		tokens, err := newJWTVerifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+		c.tokens = tokens
 	}
 
-	mac := hmac.New(sha256.New, c.Key)
-	mac.Write([]byte(cookie.Name))
-	mac.Write([]byte(cookie.Value))
-	signature := mac.Sum(nil)
+	switch strings.ToLower(cfg.CookieSameSite) {
+	case "", "lax":
+		c.sameSite = http.SameSiteLaxMode
+	case "strict":
+		c.sameSite = http.SameSiteStrictMode
+	case "none":
+		//браузеры отвергают SameSite=None без Secure
+		if !c.secure {
+			return nil, fmt.Errorf("%w: none requires COOKIE_SECURE", ErrInvalidSameSite)
+		}
+		c.sameSite = http.SameSiteNoneMode
+	default:
+		return nil, ErrInvalidSameSite
+	}
+	return c, nil
+}
 
-	//value structure is fixed :[signature][user_id]
-	cookie.Value = base64.URLEncoding.
-		EncodeToString([]byte(string(signature) + cookie.Value))
-	http.SetCookie(w, &cookie)
-	r.AddCookie(&cookie)
+func (c *cookieServiceImpl) sign(key []byte, value string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(cookieName))
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
 
-	return nil
+//encode value structure is fixed: [signature][v1|issued_at|expires_at|user_id]
+func (c *cookieServiceImpl) encode(userID string, now time.Time) string {
+	payload := strings.Join([]string{payloadVersion,
+		strconv.FormatInt(now.Unix(), 10),
+		strconv.FormatInt(now.Add(c.maxAge).Unix(), 10),
+		userID}, "|")
+	signature := c.sign(c.keys[0], payload)
+	return base64.URLEncoding.EncodeToString([]byte(string(signature) + payload))
 }
 
-func (c *cookieServiceImpl) CheckSign(r *http.Request, name string) error {
-	//[signature][user_id]
-	//Get and decode value from cookie
-	cookie, err := r.Cookie(name)
+//decode проверяет подпись всеми ключами и срок действия cookie
+func (c *cookieServiceImpl) decode(value string) (session, error) {
+	signedValue, err := base64.URLEncoding.DecodeString(value)
+	if err != nil || len(signedValue) <= sha256.Size {
+		return session{}, ErrInvalidValue
+	}
+	signature := signedValue[:sha256.Size]
+	payload := string(signedValue[sha256.Size:])
+
+	s := session{keyIndex: -1}
+	for i, key := range c.keys {
+		if hmac.Equal(signature, c.sign(key, payload)) {
+			s.keyIndex = i
+			break
+		}
+	}
+	if s.keyIndex < 0 {
+		return session{}, ErrInvalidValue
+	}
+
+	parts := strings.Split(payload, "|")
+	if parts[0] != payloadVersion {
+		//cookie прежнего формата не истекают сами, поэтому принимаются
+		//и переподписываются только до legacyUntil
+		if !c.now().Before(c.legacyUntil) {
+			return session{}, ErrExpired
+		}
+		s.userID, s.legacy = payload, true
+		return s, nil
+	}
+	if len(parts) != 4 || parts[3] == "" {
+		return session{}, ErrInvalidValue
+	}
+	issuedAt, err := strconv.ParseInt(parts[1], 10, 64)
 	if err != nil {
-		return err
+		return session{}, ErrInvalidValue
 	}
-	signedValue, err := base64.URLEncoding.DecodeString(cookie.Value)
+	expiresAt, err := strconv.ParseInt(parts[2], 10, 64)
 	if err != nil {
-		return ErrInvalidValue
+		return session{}, ErrInvalidValue
 	}
-	if len(signedValue) < sha256.Size {
-		return ErrInvalidValue
+	s.userID = parts[3]
+	s.issuedAt = time.Unix(issuedAt, 0)
+	s.expiresAt = time.Unix(expiresAt, 0)
+	if !c.now().Before(s.expiresAt) {
+		return session{}, ErrExpired
 	}
+	return s, nil
+}
 
-	//compute expected signature value
-	signature := signedValue[:sha256.Size]
-	value := signedValue[sha256.Size:]
+//needsRefresh cookie переподписывается, если она подписана старым ключом,
+//имеет прежний формат или прожила больше половины срока
+func (c *cookieServiceImpl) needsRefresh(s session) bool {
+	return s.legacy || s.keyIndex > 0 || c.now().Sub(s.issuedAt) > c.maxAge/2
+}
 
-	mac := hmac.New(sha256.New, c.Key)
-	mac.Write([]byte(name))
-	mac.Write([]byte(value))
-	expectedSignature := mac.Sum(nil)
+//issue выдаёт cookie пользователю userID
+func (c *cookieServiceImpl) issue(w http.ResponseWriter, userID string) {
+	now := c.now()
+	http.SetCookie(w, &http.Cookie{
+		Name:     cookieName,
+		Value:    c.encode(userID, now),
+		Path:     "/",
+		Domain:   c.domain,
+		Expires:  now.Add(c.maxAge),
+		MaxAge:   int(c.maxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   c.secure,
+		SameSite: c.sameSite,
+	})
+}
 
-	if !hmac.Equal(signature, expectedSignature) {
-		return ErrInvalidValue
-	}
+func (c *cookieServiceImpl) SetSession(w http.ResponseWriter, userID string) {
+	c.issue(w, userID)
+}
 
-	return nil
+//authenticate возвращает пользователя из cookie запроса. Если cookie нет,
+//она повреждена, подделана или просрочена, выдаётся cookie нового пользователя.
+func (c *cookieServiceImpl) authenticate(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(cookieName); err == nil {
+		if s, err := c.decode(cookie.Value); err == nil {
+			if c.needsRefresh(s) {
+				c.issue(w, s.userID)
+			}
+			return s.userID
+		}
+	}
+	userID := uuid.New().String()
+	c.issue(w, userID)
+	return userID
 }
 
+//Authentication проверяет cookie один раз и передаёт пользователя
+//обработчикам через контекст запроса, см. common.UserID. Запрос
+//с bearer-токеном авторизуется только по токену и cookie не получает.
 func (c *cookieServiceImpl) Authentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := c.CheckSign(r, "userID")
-		if err == nil {
+		//запрос уже авторизован предыдущим звеном цепочки, например API-ключом
+		if _, err := common.UserID(r.Context()); err == nil {
 			next.ServeHTTP(w, r)
 			return
 		}
-
-		if errors.Is(err, http.ErrNoCookie) || errors.Is(err, ErrInvalidValue) {
-			err = c.CreateAndSign(w, r)
-			if err == nil {
-				next.ServeHTTP(w, r)
+		if raw, ok := bearerToken(r); ok && c.tokens != nil {
+			userID, err := c.tokens.verify(raw)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
 				return
 			}
+			next.ServeHTTP(w, r.WithContext(common.WithUserID(r.Context(), userID)))
+			return
 		}
-
-		io.WriteString(w, err.Error())
+		userID := c.authenticate(w, r)
+		next.ServeHTTP(w, r.WithContext(common.WithSession(r.Context(), userID)))
 	})
 }
@@ -0,0 +1,146 @@
+package cookie
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T, cfg config.Config) *cookieServiceImpl {
+	if cfg.CookieMaxAge == 0 {
+		cfg.CookieMaxAge = time.Hour
+	}
+	cs, err := New(cfg)
+	require.NoError(t, err)
+	return cs.(*cookieServiceImpl)
+}
+
+//authenticate пропускает запрос с cookie value через Authentication
+//и возвращает выданную cookie и пользователя, которого увидел обработчик
+func authenticate(c *cookieServiceImpl, value string) (issued *http.Cookie, userID string) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if value != "" {
+		r.AddCookie(&http.Cookie{Name: cookieName, Value: value})
+	}
+	w := httptest.NewRecorder()
+	c.Authentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ = common.UserID(r.Context())
+	})).ServeHTTP(w, r)
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == cookieName {
+			issued = cookie
+		}
+	}
+	return issued, userID
+}
+
+func TestCookieAttributes(t *testing.T) {
+	c := newTestService(t, config.Config{SecretKeys: []string{"k1"}, CookieSecure: true,
+		CookieSameSite: "strict", CookieDomain: "sho.rt"})
+	issued, userID := authenticate(c, "")
+	require.NotNil(t, issued)
+	assert.NotEmpty(t, userID)
+	assert.True(t, issued.Secure)
+	assert.True(t, issued.HttpOnly)
+	assert.Equal(t, http.SameSiteStrictMode, issued.SameSite)
+	assert.Equal(t, "sho.rt", issued.Domain)
+	assert.Equal(t, 3600, issued.MaxAge)
+
+	_, err := New(config.Config{Key: "k", CookieSameSite: "none"})
+	assert.ErrorIs(t, err, ErrInvalidSameSite)
+}
+
+func TestCookieExpiry(t *testing.T) {
+	c := newTestService(t, config.Config{Key: "k1"})
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	value := c.encode("user", now)
+
+	issued, userID := authenticate(c, value)
+	assert.Nil(t, issued)
+	assert.Equal(t, "user", userID)
+
+	//cookie старше половины срока продлевается для того же пользователя
+	c.now = func() time.Time { return now.Add(40 * time.Minute) }
+	issued, userID = authenticate(c, value)
+	require.NotNil(t, issued)
+	assert.Equal(t, "user", userID)
+
+	//просроченная cookie заменяется cookie нового пользователя
+	c.now = func() time.Time { return now.Add(2 * time.Hour) }
+	issued, userID = authenticate(c, value)
+	require.NotNil(t, issued)
+	assert.NotEqual(t, "user", userID)
+}
+
+func TestCookieKeyRotation(t *testing.T) {
+	old := newTestService(t, config.Config{SecretKeys: []string{"old"}})
+	value := old.encode("user", time.Now())
+
+	rotated := newTestService(t, config.Config{SecretKeys: []string{"new", "old"}})
+	issued, userID := authenticate(rotated, value)
+	require.NotNil(t, issued)
+	assert.Equal(t, "user", userID)
+	//после переподписи cookie проверяется новым ключом
+	fresh := newTestService(t, config.Config{SecretKeys: []string{"new"}})
+	_, err := fresh.decode(issued.Value)
+	assert.NoError(t, err)
+
+	_, err = fresh.decode(value)
+	assert.ErrorIs(t, err, ErrInvalidValue)
+}
+
+func TestLegacyCookie(t *testing.T) {
+	mac := hmac.New(sha256.New, []byte("k1"))
+	mac.Write([]byte(cookieName))
+	mac.Write([]byte("user"))
+	value := base64.URLEncoding.EncodeToString(append(mac.Sum(nil), "user"...))
+
+	now := time.Now()
+	c := newTestService(t, config.Config{Key: "k1", LegacyCookiesUntil: now.Add(time.Hour).Format(time.RFC3339)})
+	issued, userID := authenticate(c, value)
+	require.NotNil(t, issued)
+	assert.Equal(t, "user", userID)
+
+	c.now = func() time.Time { return now.Add(2 * time.Hour) }
+	_, err := c.decode(value)
+	assert.ErrorIs(t, err, ErrExpired)
+	_, err = newTestService(t, config.Config{Key: "k1"}).decode(value)
+	assert.ErrorIs(t, err, ErrExpired)
+}
+
+func TestSigningKeyRequired(t *testing.T) {
+	_, err := New(config.Config{Key: "SuperSecretKey2022"})
+	assert.ErrorIs(t, err, ErrPublishedKey)
+	_, err = New(config.Config{SecretKeys: []string{"new", "SuperSecretKey2022"}})
+	assert.ErrorIs(t, err, ErrPublishedKey)
+
+	//без ключа каждый процесс подписывает cookie своим случайным ключом
+	first := newTestService(t, config.Config{SecretKeys: []string{""}})
+	second := newTestService(t, config.Config{})
+	value := first.encode("user", time.Now())
+	_, err = first.decode(value)
+	assert.NoError(t, err)
+	_, err = second.decode(value)
+	assert.ErrorIs(t, err, ErrInvalidValue)
+}
+
+func TestMalformedCookie(t *testing.T) {
+	c := newTestService(t, config.Config{Key: "k1"})
+	forged := base64.URLEncoding.EncodeToString(append(make([]byte, sha256.Size), "v1|0|99999999999|admin"...))
+	for _, value := range []string{"AAAA", "not base64!", "", base64.URLEncoding.EncodeToString([]byte("short")), forged} {
+		issued, userID := authenticate(c, value)
+		require.NotNil(t, issued, value)
+		assert.NotEmpty(t, userID)
+		assert.NotEqual(t, "admin", userID)
+	}
+}
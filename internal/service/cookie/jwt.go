@@ -0,0 +1,243 @@
+package cookie
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+)
+
+var (
+	ErrUnknownKey    = errors.New("unknown token signing key")
+	ErrMissingClaim  = errors.New("token has no user claim")
+	ErrInvalidClaims = errors.New("token issuer or audience mismatch")
+	ErrNoExpiry      = errors.New("token has no expiration time")
+	ErrNoJWTKeys     = errors.New("JWT_AUTH requires JWT_HMAC_KEY or JWKS_PATH")
+)
+
+//jwtVerifier проверяет bearer-токены сервисов
+type jwtVerifier struct {
+	parser   *jwt.Parser
+	hmacKeys [][]byte
+	//publicKeys ключи из JWKS по kid: *rsa.PublicKey или *ecdsa.PublicKey
+	publicKeys map[string]interface{}
+	issuer     string
+	audience   string
+	userClaim  string
+}
+
+//newJWTVerifier ключи HS256 не совпадают с ключами подписи cookie:
+//иначе любой, кто знает ключ cookie, мог бы выпустить токен
+func newJWTVerifier(cfg config.Config) (*jwtVerifier, error) {
+	v := &jwtVerifier{
+		publicKeys: make(map[string]interface{}),
+		issuer:     cfg.JWTIssuer,
+		audience:   cfg.JWTAudience,
+		userClaim:  cfg.JWTUserClaim,
+	}
+	if v.userClaim == "" {
+		v.userClaim = "sub"
+	}
+	if cfg.JWKSPath != "" {
+		keys, err := loadJWKS(cfg.JWKSPath)
+		if err != nil {
+			return nil, fmt.Errorf("load JWKS %s: %w", cfg.JWKSPath, err)
+		}
+		v.publicKeys = keys
+	}
+	for _, k := range cfg.JWTHMACKeys {
+		if k = strings.TrimSpace(k); k != "" {
+			v.hmacKeys = append(v.hmacKeys, []byte(k))
+		}
+	}
+	methods := []string{"RS256", "ES256"}
+	if len(v.hmacKeys) > 0 {
+		methods = append(methods, "HS256")
+	} else if len(v.publicKeys) == 0 {
+		return nil, ErrNoJWTKeys
+	}
+	v.parser = jwt.NewParser(jwt.WithValidMethods(methods))
+	return v, nil
+}
+
+//bearerToken извлекает токен из заголовка Authorization
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(prefix):]), true
+}
+
+//verify проверяет подпись и сроки токена и возвращает пользователя
+//из claim JWT_USER_CLAIM
+func (v *jwtVerifier) verify(raw string) (string, error) {
+	for i := 0; ; i++ {
+		claims := jwt.MapClaims{}
+		hmacSigned := false
+		_, err := v.parser.ParseWithClaims(raw, claims, func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+				if len(v.hmacKeys) == 0 {
+					return nil, ErrUnknownKey
+				}
+				hmacSigned = true
+				return v.hmacKeys[i], nil
+			}
+			return v.publicKey(t)
+		})
+		if err != nil {
+			//HS256 проверяется каждым ключом по очереди, как и cookie
+			if hmacSigned && errors.Is(err, jwt.ErrSignatureInvalid) && i+1 < len(v.hmacKeys) {
+				continue
+			}
+			return "", err
+		}
+		return v.userID(claims)
+	}
+}
+
+func (v *jwtVerifier) publicKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key, ok := v.publicKeys[kid]
+	if !ok && kid == "" && len(v.publicKeys) == 1 {
+		for _, k := range v.publicKeys {
+			key, ok = k, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("kid %q: %w", kid, ErrUnknownKey)
+	}
+	switch t.Method.(type) {
+	case *jwt.SigningMethodRSA:
+		if rsaKey, isRSA := key.(*rsa.PublicKey); isRSA {
+			return rsaKey, nil
+		}
+	case *jwt.SigningMethodECDSA:
+		if ecKey, isEC := key.(*ecdsa.PublicKey); isEC {
+			return ecKey, nil
+		}
+	}
+	return nil, fmt.Errorf("kid %q does not match %s: %w", kid, t.Method.Alg(), ErrUnknownKey)
+}
+
+//userID требует exp: MapClaims проверяет срок, только если он указан,
+//и токен без exp действовал бы вечно
+func (v *jwtVerifier) userID(claims jwt.MapClaims) (string, error) {
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return "", ErrNoExpiry
+	}
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return "", ErrInvalidClaims
+	}
+	if v.audience != "" && !claims.VerifyAudience(v.audience, true) {
+		return "", ErrInvalidClaims
+	}
+	userID, _ := claims[v.userClaim].(string)
+	if userID == "" {
+		return "", fmt.Errorf("%s: %w", v.userClaim, ErrMissingClaim)
+	}
+	return userID, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+//loadJWKS читает открытые ключи подписи RSA и EC P-256,
+//ключи других типов пропускаются
+func loadJWKS(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err = json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key interface{}
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaKey()
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			key, err = k.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	curve := elliptic.P256()
+	if !curve.IsOnCurve(x, y) {
+		return nil, errors.New("EC point is not on P-256")
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
@@ -0,0 +1,125 @@
+package cookie
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+//bearerRequest возвращает код ответа и пользователя, которого увидел обработчик
+func bearerRequest(c CookieService, token string) (int, string) {
+	r := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	var userID string
+	c.Authentication(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, _ = common.UserID(r.Context())
+	})).ServeHTTP(w, r)
+	return w.Code, userID
+}
+
+func TestBearerToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa1", "use": "sig", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec1", "crv": "P-256", "x": b64(ecKey.X), "y": b64(ecKey.Y)},
+	}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwks, 0600))
+
+	c, err := New(config.Config{SecretKeys: []string{"cookie"}, CookieMaxAge: time.Hour,
+		JWTAuth: true, JWTHMACKeys: []string{"new", "old"}, JWKSPath: path, JWTAudience: "shortener"})
+	require.NoError(t, err)
+
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		s, err := token.SignedString(key)
+		require.NoError(t, err)
+		return s
+	}
+	claims := func(sub string) jwt.MapClaims {
+		return jwt.MapClaims{"sub": sub, "aud": "shortener", "exp": time.Now().Add(time.Minute).Unix()}
+	}
+
+	for name, token := range map[string]string{
+		"HS256":     sign(jwt.SigningMethodHS256, "", []byte("new"), claims("svc")),
+		"HS256 old": sign(jwt.SigningMethodHS256, "", []byte("old"), claims("svc")),
+		"RS256":     sign(jwt.SigningMethodRS256, "rsa1", rsaKey, claims("svc")),
+		"ES256":     sign(jwt.SigningMethodES256, "ec1", ecKey, claims("svc")),
+	} {
+		code, userID := bearerRequest(c, token)
+		assert.Equal(t, http.StatusOK, code, name)
+		assert.Equal(t, "svc", userID, name)
+	}
+
+	expired := claims("svc")
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	wrongAudience := claims("svc")
+	wrongAudience["aud"] = "other"
+	noExpiry := claims("svc")
+	delete(noExpiry, "exp")
+	for name, token := range map[string]string{
+		"wrong key":     sign(jwt.SigningMethodHS256, "", []byte("unknown"), claims("svc")),
+		"cookie key":    sign(jwt.SigningMethodHS256, "", []byte("cookie"), claims("svc")),
+		"expired":       sign(jwt.SigningMethodHS256, "", []byte("new"), expired),
+		"no expiry":     sign(jwt.SigningMethodRS256, "rsa1", rsaKey, noExpiry),
+		"audience":      sign(jwt.SigningMethodHS256, "", []byte("new"), wrongAudience),
+		"no subject":    sign(jwt.SigningMethodHS256, "", []byte("new"), jwt.MapClaims{"aud": "shortener"}),
+		"unknown kid":   sign(jwt.SigningMethodRS256, "rsa2", rsaKey, claims("svc")),
+		"kid of EC key": sign(jwt.SigningMethodRS256, "ec1", rsaKey, claims("svc")),
+		"HS384":         sign(jwt.SigningMethodHS384, "", []byte("new"), claims("svc")),
+		"unsigned":      sign(jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, claims("svc")),
+		"malformed":     "not.a.token",
+	} {
+		code, _ := bearerRequest(c, token)
+		assert.Equal(t, http.StatusUnauthorized, code, name)
+	}
+}
+
+func TestBearerHS256Disabled(t *testing.T) {
+	_, err := New(config.Config{SecretKeys: []string{"k1"}, JWTAuth: true, JWTHMACKeys: []string{""}})
+	assert.ErrorIs(t, err, ErrNoJWTKeys)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa1", "n": b64(rsaKey.N), "e": b64(big.NewInt(int64(rsaKey.E)))},
+	}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwks, 0600))
+	c, err := New(config.Config{SecretKeys: []string{"k1"}, CookieMaxAge: time.Hour, JWTAuth: true, JWKSPath: path})
+	require.NoError(t, err)
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "victim"}).SignedString([]byte("k1"))
+	require.NoError(t, err)
+	code, _ := bearerRequest(c, token)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
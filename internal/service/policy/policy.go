@@ -0,0 +1,136 @@
+package policy
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"golang.org/x/net/idna"
+)
+
+type policyEngineImpl struct {
+	path            string
+	defaultDeny     bool
+	checkOnRedirect bool
+
+	lock    sync.RWMutex
+	rules   *ruleSet
+	modTime time.Time
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+//New загружает правила из cfg.PolicyRulesPath и перечитывает файл
+//при его изменении. Без файла правил разрешены все URL.
+func New(cfg config.Config) (PolicyEngine, error) {
+	pe := &policyEngineImpl{
+		path:            cfg.PolicyRulesPath,
+		defaultDeny:     cfg.PolicyDefaultDeny,
+		checkOnRedirect: cfg.PolicyCheckOnRedirect,
+		rules:           &ruleSet{},
+		done:            make(chan struct{}),
+	}
+	if pe.path == "" {
+		return pe, nil
+	}
+	if err := pe.reload(); err != nil {
+		return nil, err
+	}
+	if cfg.PolicyReloadInterval > 0 {
+		pe.wg.Add(1)
+		go pe.watch(cfg.PolicyReloadInterval)
+	}
+	return pe, nil
+}
+
+func (pe *policyEngineImpl) reload() error {
+	f, err := os.Open(pe.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	rules, err := parseRules(f)
+	if err != nil {
+		return err
+	}
+
+	pe.lock.Lock()
+	pe.rules = rules
+	pe.modTime = info.ModTime()
+	pe.lock.Unlock()
+	return nil
+}
+
+//watch перечитывает файл правил, если изменилось время его модификации.
+//При ошибке разбора продолжают действовать прежние правила.
+func (pe *policyEngineImpl) watch(interval time.Duration) {
+	defer pe.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			info, err := os.Stat(pe.path)
+			if err != nil {
+				log.Printf("policy rules: %v", err)
+				continue
+			}
+			pe.lock.RLock()
+			changed := !info.ModTime().Equal(pe.modTime)
+			pe.lock.RUnlock()
+			if !changed {
+				continue
+			}
+			if err = pe.reload(); err != nil {
+				log.Printf("policy rules: reload failed, keeping previous rules: %v", err)
+				continue
+			}
+			log.Printf("policy rules reloaded from %s", pe.path)
+		case <-pe.done:
+			return
+		}
+	}
+}
+
+func (pe *policyEngineImpl) Check(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return myerrors.NewInvalidURL(rawURL, err.Error())
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	//адрес перенаправления мог не пройти через валидатор
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	}
+
+	pe.lock.RLock()
+	rule, blocked := pe.rules.find(host, rawURL, pe.defaultDeny)
+	pe.lock.RUnlock()
+	if blocked {
+		return myerrors.NewPolicyViolation(rawURL, rule)
+	}
+	return nil
+}
+
+func (pe *policyEngineImpl) CheckOnRedirect(rawURL string) error {
+	if !pe.checkOnRedirect {
+		return nil
+	}
+	return pe.Check(rawURL)
+}
+
+func (pe *policyEngineImpl) Close() error {
+	close(pe.done)
+	pe.wg.Wait()
+	return nil
+}
@@ -0,0 +1,11 @@
+package policy
+
+var _ PolicyEngine = &policyEngineImpl{}
+
+type PolicyEngine interface {
+	//Check возвращает *myerrors.PolicyViolation, если URL запрещён правилами
+	Check(rawURL string) error
+	//CheckOnRedirect повторяет проверку при переходе по ссылке, если это включено
+	CheckOnRedirect(rawURL string) error
+	Close() error
+}
@@ -0,0 +1,61 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRules = `# phishing
+block suffix tk
+allow domain good.tk
+block domain evil.example.com
+block regex ^https?://[^/]+/login\.php
+block domain пример.рф
+block suffix Испытание.
+`
+
+func TestCheck(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	require.NoError(t, os.WriteFile(path, []byte(testRules), 0644))
+	pe, err := New(config.Config{PolicyRulesPath: path})
+	require.NoError(t, err)
+	defer pe.Close()
+
+	tests := []struct {
+		rawURL        string
+		expectBlocked bool
+	}{
+		{rawURL: "http://ya.ru/", expectBlocked: false},
+		{rawURL: "http://phish.tk/", expectBlocked: true},
+		{rawURL: "http://tk/", expectBlocked: true},
+		{rawURL: "http://good.tk/", expectBlocked: false},
+		{rawURL: "http://evil.example.com/", expectBlocked: true},
+		{rawURL: "http://sub.evil.example.com/", expectBlocked: false},
+		{rawURL: "https://ya.ru/login.php", expectBlocked: true},
+		{rawURL: "http://xn--e1afmkfd.xn--p1ai/", expectBlocked: true},
+		{rawURL: "http://пример.рф/", expectBlocked: true},
+		{rawURL: "http://sub.xn--80akhbyknj4f/", expectBlocked: true},
+		{rawURL: "http://xn--e1afmkfd.com/", expectBlocked: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rawURL, func(t *testing.T) {
+			err := pe.Check(tt.rawURL)
+			var pv *myerrors.PolicyViolation
+			assert.Equal(t, tt.expectBlocked, errors.As(err, &pv))
+		})
+	}
+}
+
+func TestParseRulesError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.txt")
+	require.NoError(t, os.WriteFile(path, []byte("block host ya.ru\n"), 0644))
+	_, err := New(config.Config{PolicyRulesPath: path})
+	assert.Error(t, err)
+}
@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+const (
+	actionAllow = "allow"
+	actionBlock = "block"
+
+	kindDomain = "domain"
+	kindSuffix = "suffix"
+	kindRegex  = "regex"
+)
+
+//rule одно правило файла политики. Формат строки:
+//<allow|block> <domain|suffix|regex> <значение>
+//domain совпадает с хостом целиком, suffix — с доменом и всеми его
+//поддоменами, regex проверяется на полном URL. Домены сравниваются
+//в punycode, как их нормализует валидатор. Пустые строки и строки,
+//начинающиеся с #, пропускаются.
+type rule struct {
+	action string
+	kind   string
+	value  string
+	re     *regexp.Regexp
+}
+
+func (r rule) String() string {
+	return r.action + " " + r.kind + " " + r.value
+}
+
+func (r rule) match(host, rawURL string) bool {
+	switch r.kind {
+	case kindDomain:
+		return host == r.value
+	case kindSuffix:
+		return host == r.value || strings.HasSuffix(host, "."+r.value)
+	case kindRegex:
+		return r.re.MatchString(rawURL)
+	}
+	return false
+}
+
+type ruleSet struct {
+	allow []rule
+	block []rule
+}
+
+func parseRules(r io.Reader) (*ruleSet, error) {
+	rs := &ruleSet{}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected \"<action> <kind> <value>\"", lineNum)
+		}
+		rl := rule{
+			action: strings.ToLower(fields[0]),
+			kind:   strings.ToLower(fields[1]),
+			value:  fields[2],
+		}
+		switch rl.kind {
+		case kindDomain, kindSuffix:
+			value, err := idna.Lookup.ToASCII(strings.Trim(strings.ToLower(rl.value), "."))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			rl.value = value
+		case kindRegex:
+			re, err := regexp.Compile(rl.value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			rl.re = re
+		default:
+			return nil, fmt.Errorf("line %d: unknown rule kind %q", lineNum, rl.kind)
+		}
+		switch rl.action {
+		case actionAllow:
+			rs.allow = append(rs.allow, rl)
+		case actionBlock:
+			rs.block = append(rs.block, rl)
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", lineNum, rl.action)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+//find возвращает сработавшее запрещающее правило. Разрешающие правила
+//имеют приоритет над запрещающими; при defaultDeny запрещено всё,
+//что не разрешено явно.
+func (rs *ruleSet) find(host, rawURL string, defaultDeny bool) (string, bool) {
+	for _, r := range rs.allow {
+		if r.match(host, rawURL) {
+			return "", false
+		}
+	}
+	for _, r := range rs.block {
+		if r.match(host, rawURL) {
+			return r.String(), true
+		}
+	}
+	if defaultDeny {
+		return "default deny", true
+	}
+	return "", false
+}
@@ -0,0 +1,200 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"golang.org/x/net/html"
+)
+
+//maxBodySize описание ищется только в начале страницы
+const maxBodySize = 1 << 20
+
+//maxFieldLength длиннее описания обрезаются
+const maxFieldLength = 300
+
+var (
+	ErrNotHTML        = errors.New("page is not HTML")
+	ErrPrivateAddress = errors.New("fetching private addresses is not allowed")
+)
+
+//privateNetworks адреса, недоступные из интернета
+var privateNetworks = mustParseCIDRs("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16",
+	"100.64.0.0/10", "fc00::/7")
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	res := make([]*net.IPNet, len(cidrs))
+	for i, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		res[i] = n
+	}
+	return res
+}
+
+type httpFetcher struct {
+	client *http.Client
+}
+
+//New создаёт загрузчик с общим ограничением времени PREVIEW_FETCH_TIMEOUT.
+//При PREVIEW_BLOCK_PRIVATE соединения с внутренними адресами запрещены,
+//в том числе после перенаправлений. Прокси из HTTP(S)_PROXY в этом режиме
+//не используется: иначе проверялся бы только адрес прокси.
+func New(cfg config.Config) Fetcher {
+	dialer := &net.Dialer{Timeout: cfg.PreviewFetchTimeout}
+	proxy := http.ProxyFromEnvironment
+	if cfg.PreviewBlockPrivate {
+		dialer.Control = denyPrivate
+		proxy = nil
+	}
+	transport := &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   cfg.PreviewFetchTimeout,
+		ResponseHeaderTimeout: cfg.PreviewFetchTimeout,
+	}
+	return &httpFetcher{client: &http.Client{Transport: transport, Timeout: cfg.PreviewFetchTimeout}}
+}
+
+func denyPrivate(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return fmt.Errorf("%s: %w", address, ErrPrivateAddress)
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return fmt.Errorf("%s: %w", address, ErrPrivateAddress)
+		}
+	}
+	return nil
+}
+
+func (f *httpFetcher) Fetch(ctx context.Context, pageURL string) (common.Preview, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return common.Preview{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "urlshortener-preview/1.0")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return common.Preview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return common.Preview{}, fmt.Errorf("fetch %s: unexpected status %s", pageURL, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return common.Preview{}, fmt.Errorf("fetch %s: %w", pageURL, ErrNotHTML)
+	}
+	return parse(io.LimitReader(resp.Body, maxBodySize), resp.Request.URL), nil
+}
+
+//parse читает заголовок документа. Свойства og:* предпочтительнее <title>
+//и <meta name="description">, адрес картинки разрешается относительно
+//итогового адреса страницы.
+func parse(r io.Reader, base *url.URL) common.Preview {
+	var p, fallback common.Preview
+	z := html.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return finish(p, fallback, base)
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			switch t.Data {
+			case "title":
+				if z.Next() == html.TextToken {
+					fallback.Title = string(z.Text())
+				}
+			case "meta":
+				key, content := metaAttrs(t)
+				switch key {
+				case "og:title":
+					p.Title = content
+				case "og:description":
+					p.Description = content
+				case "og:image", "og:image:url":
+					if p.Image == "" {
+						p.Image = content
+					}
+				case "description":
+					fallback.Description = content
+				}
+			case "body":
+				return finish(p, fallback, base)
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); string(name) == "head" {
+				return finish(p, fallback, base)
+			}
+		}
+	}
+}
+
+func metaAttrs(t html.Token) (key, content string) {
+	for _, a := range t.Attr {
+		switch a.Key {
+		case "property", "name":
+			if key == "" {
+				key = strings.ToLower(a.Val)
+			}
+		case "content":
+			content = a.Val
+		}
+	}
+	return key, content
+}
+
+func finish(p, fallback common.Preview, base *url.URL) common.Preview {
+	if p.Title == "" {
+		p.Title = fallback.Title
+	}
+	if p.Description == "" {
+		p.Description = fallback.Description
+	}
+	p.Title = clean(p.Title)
+	p.Description = clean(p.Description)
+	p.Image = resolveImage(strings.TrimSpace(p.Image), base)
+	return p
+}
+
+//clean схлопывает пробельные символы и обрезает слишком длинный текст
+func clean(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > maxFieldLength {
+		s = string(r[:maxFieldLength]) + "…"
+	}
+	return s
+}
+
+//resolveImage оставляет только http(s)-адреса картинок
+func resolveImage(image string, base *url.URL) string {
+	if image == "" {
+		return ""
+	}
+	u, err := base.Parse(image)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
@@ -0,0 +1,14 @@
+package preview
+
+import (
+	"context"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+)
+
+var _ Fetcher = &httpFetcher{}
+
+type Fetcher interface {
+	//Fetch загружает страницу и извлекает из неё OpenGraph-описание
+	Fetch(ctx context.Context, pageURL string) (common.Preview, error)
+}
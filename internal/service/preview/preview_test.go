@@ -0,0 +1,73 @@
+package preview
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetch(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/og", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head><title>Plain title</title>
+<meta property="og:title" content="  OpenGraph
+ title ">
+<meta name="description" content="Plain description">
+<meta property="og:image" content="/img/cover.png">
+</head><body><meta property="og:description" content="ignored"></body></html>`))
+	})
+	mux.HandleFunc("/plain", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<title>Plain title</title><meta name="description" content="Plain description">` +
+			`<meta property="og:image" content="javascript:alert(1)">`))
+	})
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := New(config.Config{PreviewFetchTimeout: 100 * time.Millisecond})
+	ctx := context.Background()
+
+	p, err := f.Fetch(ctx, srv.URL+"/og")
+	require.NoError(t, err)
+	assert.Equal(t, common.Preview{Title: "OpenGraph title", Description: "Plain description",
+		Image: srv.URL + "/img/cover.png"}, p)
+
+	p, err = f.Fetch(ctx, srv.URL+"/plain")
+	require.NoError(t, err)
+	assert.Equal(t, common.Preview{Title: "Plain title", Description: "Plain description"}, p)
+
+	_, err = f.Fetch(ctx, srv.URL+"/file")
+	assert.ErrorIs(t, err, ErrNotHTML)
+	_, err = f.Fetch(ctx, srv.URL+"/missing")
+	assert.Error(t, err)
+	_, err = f.Fetch(ctx, srv.URL+"/slow")
+	assert.Error(t, err)
+
+	blocking := New(config.Config{PreviewFetchTimeout: time.Second, PreviewBlockPrivate: true})
+	_, err = blocking.Fetch(ctx, srv.URL+"/og")
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+}
+
+func TestBlockPrivateBypassesProxy(t *testing.T) {
+	transport := func(f Fetcher) *http.Transport {
+		return f.(*httpFetcher).client.Transport.(*http.Transport)
+	}
+	assert.NotNil(t, transport(New(config.Config{PreviewFetchTimeout: time.Second})).Proxy)
+	//через прокси denyPrivate увидел бы только адрес прокси
+	assert.Nil(t, transport(New(config.Config{PreviewFetchTimeout: time.Second,
+		PreviewBlockPrivate: true})).Proxy)
+}
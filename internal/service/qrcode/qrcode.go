@@ -0,0 +1,130 @@
+package qrcode
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	goqrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+
+	DefaultSize = 256
+	//MinSize меньше этого модули QR-кода перестают различаться сканером
+	MinSize = 64
+)
+
+var (
+	ErrInvalidSize   = errors.New("invalid QR code size")
+	ErrInvalidFormat = errors.New("QR code format must be png or svg")
+	ErrInvalidLevel  = errors.New("QR code error correction level must be one of L, M, Q, H")
+)
+
+var levels = map[string]goqrcode.RecoveryLevel{
+	"L": goqrcode.Low,
+	"M": goqrcode.Medium,
+	"Q": goqrcode.High,
+	"H": goqrcode.Highest,
+}
+
+//Options параметры изображения, Level — буква уровня коррекции ошибок
+type Options struct {
+	Size   int
+	Format string
+	Level  string
+}
+
+type Image struct {
+	Data        []byte
+	ContentType string
+}
+
+type qrCodeServiceImpl struct {
+	defaultLevel string
+	maxSize      int
+}
+
+func New(cfg config.Config) (QRCodeService, error) {
+	level := strings.ToUpper(cfg.QRErrorCorrection)
+	if _, ok := levels[level]; !ok {
+		return nil, fmt.Errorf("QR_ERROR_CORRECTION %q: %w", cfg.QRErrorCorrection, ErrInvalidLevel)
+	}
+	maxSize := cfg.QRMaxSize
+	if maxSize < DefaultSize {
+		maxSize = DefaultSize
+	}
+	return &qrCodeServiceImpl{defaultLevel: level, maxSize: maxSize}, nil
+}
+
+func (s *qrCodeServiceImpl) ParseOptions(size, format, level string) (Options, error) {
+	opts := Options{Size: DefaultSize, Format: FormatPNG, Level: s.defaultLevel}
+	if size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < MinSize || n > s.maxSize {
+			return Options{}, fmt.Errorf("%w: must be between %d and %d", ErrInvalidSize, MinSize, s.maxSize)
+		}
+		opts.Size = n
+	}
+	if format != "" {
+		opts.Format = strings.ToLower(format)
+		if opts.Format != FormatPNG && opts.Format != FormatSVG {
+			return Options{}, ErrInvalidFormat
+		}
+	}
+	if level != "" {
+		opts.Level = strings.ToUpper(level)
+		if _, ok := levels[opts.Level]; !ok {
+			return Options{}, ErrInvalidLevel
+		}
+	}
+	return opts, nil
+}
+
+func (s *qrCodeServiceImpl) Render(content string, opts Options) (Image, error) {
+	level, ok := levels[opts.Level]
+	if !ok {
+		return Image{}, ErrInvalidLevel
+	}
+	code, err := goqrcode.New(content, level)
+	if err != nil {
+		return Image{}, err
+	}
+	if opts.Format == FormatSVG {
+		return Image{Data: renderSVG(code.Bitmap(), opts.Size), ContentType: "image/svg+xml"}, nil
+	}
+	data, err := code.PNG(opts.Size)
+	if err != nil {
+		return Image{}, err
+	}
+	return Image{Data: data, ContentType: "image/png"}, nil
+}
+
+//renderSVG рисует модули QR-кода в координатах модулей, соседние тёмные
+//модули строки объединяются в один прямоугольник
+func renderSVG(bitmap [][]bool, size int) []byte {
+	n := len(bitmap)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		size, size, n, n)
+	b.WriteString(`<rect width="100%" height="100%" fill="#fff"/><path fill="#000" d="`)
+	for y, row := range bitmap {
+		for x := 0; x < len(row); {
+			if !row[x] {
+				x++
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String())
+}
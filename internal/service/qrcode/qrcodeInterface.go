@@ -0,0 +1,10 @@
+package qrcode
+
+var _ QRCodeService = &qrCodeServiceImpl{}
+
+type QRCodeService interface {
+	//Render кодирует content в QR-код заданного размера, формата и уровня коррекции
+	Render(content string, opts Options) (Image, error)
+	//ParseOptions проверяет параметры запроса, пустые заменяются значениями по умолчанию
+	ParseOptions(size, format, level string) (Options, error)
+}
@@ -0,0 +1,53 @@
+package qrcode
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOptions(t *testing.T) {
+	s, err := New(config.Config{QRErrorCorrection: "q", QRMaxSize: 512})
+	require.NoError(t, err)
+
+	opts, err := s.ParseOptions("", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, Options{Size: DefaultSize, Format: FormatPNG, Level: "Q"}, opts)
+
+	opts, err = s.ParseOptions("128", "SVG", "h")
+	require.NoError(t, err)
+	assert.Equal(t, Options{Size: 128, Format: FormatSVG, Level: "H"}, opts)
+
+	_, err = s.ParseOptions("1024", "", "")
+	assert.ErrorIs(t, err, ErrInvalidSize)
+	_, err = s.ParseOptions("", "gif", "")
+	assert.ErrorIs(t, err, ErrInvalidFormat)
+	_, err = s.ParseOptions("", "", "X")
+	assert.ErrorIs(t, err, ErrInvalidLevel)
+
+	_, err = New(config.Config{QRErrorCorrection: "X"})
+	assert.ErrorIs(t, err, ErrInvalidLevel)
+}
+
+func TestRender(t *testing.T) {
+	s, err := New(config.Config{QRErrorCorrection: "M"})
+	require.NoError(t, err)
+
+	img, err := s.Render("http://localhost:8080/abc", Options{Size: 256, Format: FormatPNG, Level: "M"})
+	require.NoError(t, err)
+	assert.Equal(t, "image/png", img.ContentType)
+	decoded, err := png.Decode(bytes.NewReader(img.Data))
+	require.NoError(t, err)
+	assert.Equal(t, 256, decoded.Bounds().Dx())
+
+	img, err = s.Render("http://localhost:8080/abc", Options{Size: 256, Format: FormatSVG, Level: "M"})
+	require.NoError(t, err)
+	assert.Equal(t, "image/svg+xml", img.ContentType)
+	assert.True(t, strings.HasPrefix(string(img.Data), "<svg"))
+	assert.Contains(t, string(img.Data), `width="256"`)
+}
@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//sweepInterval как часто удаляются корзины, успевшие заполниться полностью
+const sweepInterval = time.Minute
+
+//Limit не более Requests запросов за Period, без ожидания можно сделать
+//все Requests запросов подряд
+type Limit struct {
+	Requests int
+	Period   time.Duration
+}
+
+//Result итог проверки: значения для заголовков X-RateLimit-* и Retry-After
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration
+	RetryAfter time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type tokenBucketLimiter struct {
+	limit Limit
+	//rate скорость пополнения корзины, токенов в секунду
+	rate float64
+	now  func() time.Time
+
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func New(limit Limit) RateLimiter {
+	return &tokenBucketLimiter{
+		limit:   limit,
+		rate:    float64(limit.Requests) / limit.Period.Seconds(),
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *tokenBucketLimiter) Allow(key string) Result {
+	return l.take(key, true)
+}
+
+func (l *tokenBucketLimiter) Peek(key string) Result {
+	return l.take(key, false)
+}
+
+//take пополняет корзину key и, если consume, расходует из неё токен
+func (l *tokenBucketLimiter) take(key string, consume bool) Result {
+	now := l.now()
+	burst := float64(l.limit.Requests)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	res := Result{Limit: l.limit.Requests}
+	if b.tokens >= 1 {
+		if consume {
+			b.tokens--
+		}
+		res.Allowed = true
+	} else {
+		res.RetryAfter = l.duration(1 - b.tokens)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = l.duration(burst - b.tokens)
+	return res
+}
+
+//duration время, за которое в корзину поступит tokens токенов
+func (l *tokenBucketLimiter) duration(tokens float64) time.Duration {
+	return time.Duration(tokens / l.rate * float64(time.Second))
+}
+
+//sweep удаляет полные корзины: они ничем не отличаются от новых.
+//Вызывающий должен удерживать l.lock.
+func (l *tokenBucketLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.limit.Period {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+//ParseLimits разбирает ограничения по маршрутам в формате
+//"/=20/1m,/api/shorten=20/1m,/api/shorten/batch=5/1m"
+func ParseLimits(s string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		route, spec := splitLast(item, "=")
+		requests, period := splitLast(spec, "/")
+		if route == "" || requests == "" || period == "" {
+			return nil, fmt.Errorf("rate limit %q: expected <route>=<requests>/<period>", item)
+		}
+		n, err := strconv.Atoi(requests)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("rate limit %q: invalid number of requests", item)
+		}
+		d, err := time.ParseDuration(period)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("rate limit %q: invalid period", item)
+		}
+		limits[route] = Limit{Requests: n, Period: d}
+	}
+	return limits, nil
+}
+
+func splitLast(s, sep string) (string, string) {
+	i := strings.LastIndex(s, sep)
+	if i < 0 {
+		return "", ""
+	}
+	return s[:i], s[i+len(sep):]
+}
@@ -0,0 +1,10 @@
+package ratelimit
+
+var _ RateLimiter = &tokenBucketLimiter{}
+
+type RateLimiter interface {
+	//Allow расходует один токен из корзины key
+	Allow(key string) Result
+	//Peek сообщает, разрешил бы Allow запрос, не расходуя токен
+	Peek(key string) Result
+}
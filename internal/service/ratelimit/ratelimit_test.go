@@ -0,0 +1,58 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(Limit{Requests: 2, Period: time.Minute}).(*tokenBucketLimiter)
+	l.now = func() time.Time { return now }
+
+	assert.True(t, l.Allow("user").Allowed)
+	res := l.Allow("user")
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	res = l.Allow("user")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 30*time.Second, res.RetryAfter)
+	assert.True(t, l.Allow("other").Allowed)
+
+	now = now.Add(30 * time.Second)
+	assert.True(t, l.Allow("user").Allowed)
+}
+
+func TestPeek(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := New(Limit{Requests: 1, Period: time.Minute}).(*tokenBucketLimiter)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		res := l.Peek("user")
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 1, res.Remaining)
+	}
+	assert.True(t, l.Allow("user").Allowed)
+	res := l.Peek("user")
+	assert.False(t, res.Allowed)
+	assert.Equal(t, time.Minute, res.RetryAfter)
+}
+
+func TestParseLimits(t *testing.T) {
+	limits, err := ParseLimits("/=20/1m, /api/shorten/batch=5/30s")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Limit{
+		"/":                  {Requests: 20, Period: time.Minute},
+		"/api/shorten/batch": {Requests: 5, Period: 30 * time.Second},
+	}, limits)
+
+	for _, s := range []string{"/=20", "/=x/1m", "/=0/1m", "/=1/abc", "=1/1m"} {
+		_, err = ParseLimits(s)
+		assert.Error(t, err, s)
+	}
+}
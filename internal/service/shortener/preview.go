@@ -0,0 +1,60 @@
+package shortener
+
+import (
+	"context"
+	"log"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
+)
+
+//maxPreviewFetches число одновременных загрузок описаний,
+//ссылки сверх него остаются без описания
+const maxPreviewFetches = 16
+
+//fetchPreview в фоне загружает описание страницы назначения новой ссылки.
+//Ссылки с паролем не описываются: предпросмотр не должен раскрывать адрес.
+func (s *urlshortenerServiceImpl) fetchPreview(link common.Link) {
+	if s.fetcher == nil || link.PasswordHash != "" {
+		return
+	}
+	select {
+	case s.previewSlots <- struct{}{}:
+	default:
+		log.Printf("preview of %s skipped: too many fetches in progress", link.ID)
+		return
+	}
+	go func() {
+		defer func() { <-s.previewSlots }()
+		preview, err := s.fetcher.Fetch(context.Background(), link.ExpandURL)
+		if err != nil {
+			log.Printf("unable to fetch preview of %s: %v", link.ExpandURL, err)
+			return
+		}
+		if preview.IsEmpty() {
+			return
+		}
+		ctx, cancel := s.withStorageTimeout(context.Background())
+		defer cancel()
+		if err = s.storage.SetPreview(ctx, link.ID, preview); err != nil {
+			log.Printf("unable to save preview of %s: %v", link.ID, err)
+		}
+	}()
+}
+
+//PreviewURL возвращает ссылку для страницы предпросмотра
+func (s *urlshortenerServiceImpl) PreviewURL(ctx context.Context, urlID string) (common.Link, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.storage.GetLink(ctx, urlID)
+	if err != nil {
+		return common.Link{}, storageError(ctx, err)
+	}
+	if err = unavailable(link, urlID); err != nil {
+		return common.Link{}, err
+	}
+	if link.PasswordHash != "" {
+		return common.Link{}, myerrors.NewPasswordRequired(urlID)
+	}
+	return link, nil
+}
@@ -0,0 +1,132 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/service/validation"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+const (
+	SelfLinkResolve = "resolve"
+	SelfLinkReject  = "reject"
+
+	//maxRedirectHops ограничивает длину цепочки наших же коротких ссылок
+	maxRedirectHops = 10
+)
+
+//ownBase адрес, по которому доступны наши короткие ссылки
+type ownBase struct {
+	host string
+	path string
+}
+
+//selfLinks распознаёт URL, указывающие на сам сервис: на BaseURL
+//и его псевдонимы из конфигурации
+type selfLinks struct {
+	bases  []ownBase
+	policy string
+}
+
+func newSelfLinks(cfg config.Config, v validation.URLValidator) selfLinks {
+	sl := selfLinks{policy: cfg.SelfLinkPolicy}
+	for _, raw := range append([]string{cfg.BaseURL}, cfg.BaseURLAliases...) {
+		//базовые адреса приводятся к тому же виду, что и проверяемые URL
+		if normalized, err := v.Normalize(raw); err == nil {
+			raw = normalized
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		sl.bases = append(sl.bases, ownBase{
+			host: strings.ToLower(u.Host),
+			path: strings.TrimSuffix(u.Path, "/") + "/",
+		})
+	}
+	return sl
+}
+
+//match сообщает, указывает ли нормализованный URL на сервис, и возвращает
+//идентификатор короткой ссылки, если URL на неё похож
+func (sl selfLinks) match(normalizedURL string) (id string, isOwn bool) {
+	u, err := url.Parse(normalizedURL)
+	if err != nil {
+		return "", false
+	}
+	host := strings.ToLower(u.Host)
+	for _, b := range sl.bases {
+		if host != b.host {
+			continue
+		}
+		path := u.Path + "/"
+		if !strings.HasPrefix(path, b.path) {
+			continue
+		}
+		id = strings.Trim(strings.TrimPrefix(u.Path, strings.TrimSuffix(b.path, "/")), "/")
+		if strings.Contains(id, "/") {
+			id = ""
+		}
+		return id, true
+	}
+	return "", false
+}
+
+//follow проходит по цепочке наших коротких ссылок, начиная с target,
+//и возвращает первый адрес, который не является короткой ссылкой.
+//На ссылке с паролем или ограничением переходов цепочка обрывается:
+//переход по ней должен пройти собственные проверки. visited содержит
+//уже пройденные идентификаторы и позволяет обнаружить цикл.
+func (s *urlshortenerServiceImpl) follow(ctx context.Context, target string, visited map[string]bool) (string, error) {
+	for hops := 0; ; hops++ {
+		id, isOwn := s.selfLinks.match(target)
+		if !isOwn || id == "" {
+			return target, nil
+		}
+		if visited[id] || hops >= maxRedirectHops {
+			return "", myerrors.NewRedirectLoop(target)
+		}
+		visited[id] = true
+
+		next, err := s.storage.GetLink(ctx, "/"+id)
+		if err != nil {
+			return "", storageError(ctx, err)
+		}
+		if next.Restricted() {
+			return target, nil
+		}
+		target = next.ExpandURL
+	}
+}
+
+//resolveSelfLink применяет политику к URL, указывающему на сервис:
+//reject запрещает такие ссылки, resolve заменяет их конечным адресом
+func (s *urlshortenerServiceImpl) resolveSelfLink(ctx context.Context, normalizedURL string) (string, error) {
+	if _, isOwn := s.selfLinks.match(normalizedURL); !isOwn {
+		return normalizedURL, nil
+	}
+	if s.selfLinks.policy == SelfLinkReject {
+		return "", myerrors.NewSelfReference(normalizedURL)
+	}
+
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	final, err := s.follow(ctx, normalizedURL, make(map[string]bool))
+	//адрес сервиса, не являющийся короткой ссылкой (например /ping),
+	//заменить нечем
+	if errors.Is(err, storages.ErrNotFound) {
+		return "", myerrors.NewSelfReference(normalizedURL)
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, isOwn := s.selfLinks.match(final); isOwn {
+		return "", myerrors.NewSelfReference(normalizedURL)
+	}
+	return final, nil
+}
@@ -3,24 +3,160 @@ package shortener
 import (
 	"context"
 	"crypto/md5"
+	"crypto/rand"
 	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/sandor-clegane/urlshortener/internal/common"
 	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/service/audit"
+	"github.com/sandor-clegane/urlshortener/internal/service/policy"
+	"github.com/sandor-clegane/urlshortener/internal/service/preview"
+	"github.com/sandor-clegane/urlshortener/internal/service/targeting"
+	"github.com/sandor-clegane/urlshortener/internal/service/validation"
+	"github.com/sandor-clegane/urlshortener/internal/service/workspace"
 	"github.com/sandor-clegane/urlshortener/internal/storages"
+	"golang.org/x/crypto/bcrypt"
+)
+
+//maxPasswordLength bcrypt учитывает только первые 72 байта пароля
+const maxPasswordLength = 72
+
+var (
+	ErrPasswordTooLong  = errors.New("password must not exceed 72 bytes")
+	ErrInvalidMaxClicks = errors.New("max_clicks must not be negative")
 )
 
 type urlshortenerServiceImpl struct {
-	storage storages.Storage
-	baseURL string
+	storage        storages.Storage
+	validator      validation.URLValidator
+	policy         policy.PolicyEngine
+	selfLinks      selfLinks
+	baseURL        string
+	storageTimeout time.Duration
+	//maxLinksPerUser 0 означает отсутствие ограничения
+	maxLinksPerUser int
+	//fetcher nil, если описания страниц не загружаются
+	fetcher      preview.Fetcher
+	previewSlots chan struct{}
+	//workspaces проверяет роли в рабочих пространствах ссылок
+	workspaces workspace.WorkspaceService
+	audit      audit.AuditService
 }
 
-func New(stg storages.Storage, baseURL string) URLshortenerService {
+func New(stg storages.Storage, pe policy.PolicyEngine, cfg config.Config) URLshortenerService {
+	v := validation.New(cfg)
+	var fetcher preview.Fetcher
+	if cfg.PreviewFetch {
+		fetcher = preview.New(cfg)
+	}
 	return &urlshortenerServiceImpl{
-		storage: stg,
-		baseURL: baseURL,
+		storage:         stg,
+		validator:       v,
+		policy:          pe,
+		selfLinks:       newSelfLinks(cfg, v),
+		baseURL:         cfg.BaseURL,
+		storageTimeout:  cfg.StorageTimeout,
+		maxLinksPerUser: cfg.MaxLinksPerUser,
+		fetcher:         fetcher,
+		previewSlots:    make(chan struct{}, maxPreviewFetches),
+		workspaces:      workspace.New(stg, cfg),
+		audit:           audit.New(stg, cfg),
+	}
+}
+
+//withStorageTimeout ограничивает время одного обращения к хранилищу
+func (s *urlshortenerServiceImpl) withStorageTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.storageTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.storageTimeout)
+}
+
+//storageError подменяет ошибку хранилища ошибкой контекста, если запрос
+//был отменён или не уложился в отведённое время: драйверы возвращают
+//их в собственном виде, а обработчикам нужно отличать такие ситуации
+func storageError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+//prepareURL проверяет URL перед сокращением: нормализует его,
+//применяет политику доменов и обрабатывает ссылки на сам сервис
+func (s *urlshortenerServiceImpl) prepareURL(ctx context.Context, rawURL string) (string, error) {
+	normalizedURL, err := s.validator.Normalize(rawURL)
+	if err != nil {
+		return "", err
+	}
+	normalizedURL, err = s.resolveSelfLink(ctx, normalizedURL)
+	if err != nil {
+		return "", err
+	}
+	if err = s.policy.Check(normalizedURL); err != nil {
+		return "", err
+	}
+	return normalizedURL, nil
+}
+
+//prepareRules проверяет условия правил перенаправления и их адреса
+//так же, как адрес самой ссылки
+func (s *urlshortenerServiceImpl) prepareRules(ctx context.Context,
+	rules []common.RedirectRule) ([]common.RedirectRule, error) {
+	prepared := make([]common.RedirectRule, 0, len(rules))
+	for i, rule := range rules {
+		rule, err := targeting.NormalizeRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		if rule.Target, err = s.prepareURL(ctx, rule.Target); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		prepared = append(prepared, rule)
+	}
+	return prepared, nil
+}
+
+//checkQuota проверяет, что пользователь может создать ещё n ссылок
+func (s *urlshortenerServiceImpl) checkQuota(ctx context.Context, userID string, n int) error {
+	if s.maxLinksPerUser <= 0 {
+		return nil
+	}
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	count, err := s.storage.CountByUser(ctx, userID)
+	if err != nil {
+		return storageError(ctx, err)
+	}
+	if count+n > s.maxLinksPerUser {
+		return myerrors.NewQuotaExceeded(userID, s.maxLinksPerUser)
 	}
+	return nil
+}
+
+//prepareVariants проверяет варианты A/B-теста и их адреса
+func (s *urlshortenerServiceImpl) prepareVariants(ctx context.Context,
+	variants []common.Variant) ([]common.Variant, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	variants, err := targeting.NormalizeVariants(variants)
+	if err != nil {
+		return nil, err
+	}
+	for i := range variants {
+		if variants[i].Target, err = s.prepareURL(ctx, variants[i].Target); err != nil {
+			return nil, fmt.Errorf("variant %s: %w", variants[i].Name, err)
+		}
+	}
+	return variants, nil
 }
 
 func (s *urlshortenerServiceImpl) shorten(url *url.URL) (*url.URL, error) {
@@ -28,36 +164,309 @@ func (s *urlshortenerServiceImpl) shorten(url *url.URL) (*url.URL, error) {
 	return common.Join(s.baseURL, hex.EncodeToString(hash[:]))
 }
 
-func (s *urlshortenerServiceImpl) ShortenURL(ctx context.Context, userID, rawURL string) (string, error) {
-	urlParsed, err := url.Parse(rawURL)
+//shortenUnique создаёт короткую ссылку со случайным ID. Используется для
+//ссылок с параметрами: они не должны совпадать с обычной ссылкой на тот же URL.
+func (s *urlshortenerServiceImpl) shortenUnique() (*url.URL, error) {
+	id := make([]byte, md5.Size)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return common.Join(s.baseURL, hex.EncodeToString(id))
+}
+
+//newLink применяет параметры к создаваемой ссылке
+func newLink(shortURL *url.URL, normalizedURL, userID string, opts common.LinkOptions) (common.Link, error) {
+	link := common.Link{
+		ID:          shortURL.Path,
+		ExpandURL:   normalizedURL,
+		UserID:      userID,
+		WorkspaceID: opts.WorkspaceID,
+	}
+	if opts.Password != "" {
+		if len(opts.Password) > maxPasswordLength {
+			return link, ErrPasswordTooLong
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return link, err
+		}
+		link.PasswordHash = string(hash)
+	}
+	if opts.MaxClicks < 0 {
+		return link, ErrInvalidMaxClicks
+	}
+	link.ClickLimit = opts.MaxClicks
+	link.ClicksLeft = opts.MaxClicks
+	return link, nil
+}
+
+func (s *urlshortenerServiceImpl) ShortenURL(ctx context.Context, userID, rawURL string,
+	opts common.LinkOptions) (string, error) {
+	normalizedURL, err := s.prepareURL(ctx, rawURL)
 	if err != nil {
 		return "", err
 	}
-	shortURL, err := s.shorten(urlParsed)
+	urlParsed, err := url.Parse(normalizedURL)
 	if err != nil {
 		return "", err
 	}
-	err = s.storage.Insert(ctx, shortURL.Path, rawURL, userID)
+	var shortURL *url.URL
+	if opts.IsEmpty() {
+		shortURL, err = s.shorten(urlParsed)
+	} else {
+		shortURL, err = s.shortenUnique()
+	}
 	if err != nil {
-		return "", myerrors.NewUniqueViolation(shortURL.String(), err)
+		return "", err
+	}
+	link, err := newLink(shortURL, normalizedURL, userID, opts)
+	if err != nil {
+		return "", err
+	}
+	if link.Rules, err = s.prepareRules(ctx, opts.Rules); err != nil {
+		return "", err
+	}
+	if link.Variants, err = s.prepareVariants(ctx, opts.Variants); err != nil {
+		return "", err
+	}
+	link.UTM = normalizeUTM(opts.UTM)
+	if err = s.checkWorkspace(ctx, userID, opts.WorkspaceID, common.RoleEditor); err != nil {
+		return "", err
 	}
+	if err = s.checkQuota(ctx, userID, 1); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	err = s.storage.InsertLink(ctx, link)
+	if err != nil {
+		if errors.Is(err, storages.ErrAlreadyExists) {
+			return "", myerrors.NewUniqueViolation(shortURL.String(), err)
+		}
+		return "", storageError(ctx, err)
+	}
+	s.audit.Record(ctx, userID, common.AuditCreate, link.ID, nil, link)
+	s.fetchPreview(link)
 
 	return shortURL.String(), nil
 }
 
-func (s *urlshortenerServiceImpl) ExpandURL(ctx context.Context, shortURL string) (string, error) {
-	res, err := s.storage.LookUp(ctx, shortURL)
+func (s *urlshortenerServiceImpl) ExpandURL(ctx context.Context, shortURL string,
+	client common.ClientInfo) (common.Redirect, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.storage.GetLink(ctx, shortURL)
+	if err != nil {
+		return common.Redirect{}, storageError(ctx, err)
+	}
+	if err = unavailable(link, shortURL); err != nil {
+		return common.Redirect{}, err
+	}
+	if link.PasswordHash != "" {
+		return common.Redirect{}, myerrors.NewPasswordRequired(shortURL)
+	}
+	return s.visit(ctx, link, client)
+}
+
+//UnlockURL возвращает адрес перенаправления для ссылки, защищённой
+//паролем, если пароль верен
+func (s *urlshortenerServiceImpl) UnlockURL(ctx context.Context, shortURL, password string,
+	client common.ClientInfo) (common.Redirect, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.storage.GetLink(ctx, shortURL)
+	if err != nil {
+		return common.Redirect{}, storageError(ctx, err)
+	}
+	if err = unavailable(link, shortURL); err != nil {
+		return common.Redirect{}, err
+	}
+	if link.PasswordHash != "" &&
+		bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+		return common.Redirect{}, myerrors.NewInvalidPassword(shortURL)
+	}
+	return s.visit(ctx, link, client)
+}
+
+func (s *urlshortenerServiceImpl) ShortURL(ctx context.Context, urlID string) (string, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.storage.GetLink(ctx, urlID)
+	if err != nil {
+		return "", storageError(ctx, err)
+	}
+	shortURL, err := common.Join(s.baseURL, link.ID)
 	if err != nil {
 		return "", err
 	}
+	return shortURL.String(), nil
+}
+
+//unavailable возвращает ошибку, если переходы по ссылке невозможны:
+//она заблокирована администратором или её переходы закончились
+func unavailable(link common.Link, shortURL string) error {
+	if link.Disabled != nil {
+		return myerrors.NewLinkDisabled(shortURL, link.Disabled.Legal)
+	}
+	if link.Exhausted() {
+		return myerrors.NewLinkGone(shortURL)
+	}
+	return nil
+}
+
+//visit выбирает адрес перенаправления: по правилам ссылки, а если ни одно
+//не подошло — среди вариантов A/B-теста, и добавляет к нему UTM-метки.
+//Переход по ссылке с ограничением засчитывается только после всех проверок.
+func (s *urlshortenerServiceImpl) visit(ctx context.Context, link common.Link,
+	client common.ClientInfo) (common.Redirect, error) {
+	var redirect common.Redirect
+	target, ok := targeting.Select(link.Rules, client)
+	if !ok {
+		target = link.ExpandURL
+		if v, chosen := targeting.ChooseVariant(link.Variants, client.Variant); chosen {
+			target, redirect.Variant = v.Target, v.Name
+		}
+	}
+	res, err := s.redirectTarget(ctx, link.ID, target)
+	if err != nil {
+		return common.Redirect{}, err
+	}
+	if link.ClickLimit > 0 {
+		err = s.storage.UseClick(ctx, link.ID)
+		if errors.Is(err, storages.ErrNoClicksLeft) {
+			return common.Redirect{}, myerrors.NewLinkGone(link.ID)
+		}
+		if err != nil {
+			return common.Redirect{}, storageError(ctx, err)
+		}
+	}
+	//потерянный переход в статистике не повод отказывать в перенаправлении
+	if redirect.Variant != "" {
+		if err = s.storage.CountVariantClick(ctx, link.ID, redirect.Variant); err != nil {
+			log.Printf("unable to count click on variant %s of %s: %v", redirect.Variant, link.ID, err)
+		}
+	}
+	redirect.URL = s.tag(ctx, link, res)
+	return redirect, nil
+}
+
+//redirectTarget разворачивает цепочку наших ссылок, начиная с адреса
+//target ссылки id, и проверяет конечный адрес политикой доменов
+func (s *urlshortenerServiceImpl) redirectTarget(ctx context.Context, id, target string) (string, error) {
+	//ссылка могла указывать на другую нашу ссылку ещё до появления проверки
+	//при сокращении или стать такой после редактирования
+	visited := map[string]bool{strings.Trim(id, "/"): true}
+	res, err := s.follow(ctx, target, visited)
+	if err != nil {
+		return "", err
+	}
+	if err = s.policy.CheckOnRedirect(res); err != nil {
+		return "", err
+	}
 	return res, nil
 }
 
-func (s *urlshortenerServiceImpl) GetAllURL(ctx context.Context, userID string) ([]common.PairURL, error) {
-	res, err := s.storage.GetPairsByID(ctx, userID)
+//checkWorkspace проверяет, что роль пользователя в рабочем пространстве
+//не ниже required. Пустой workspaceID означает личные ссылки пользователя.
+func (s *urlshortenerServiceImpl) checkWorkspace(ctx context.Context, userID, workspaceID string,
+	required common.Role) error {
+	if workspaceID == "" {
+		return nil
+	}
+	_, err := s.workspaces.Authorize(ctx, userID, workspaceID, required)
+	return storageError(ctx, err)
+}
+
+//authorizeLink возвращает ссылку, если пользователю разрешено действие
+//с ней: личной ссылкой распоряжается только владелец, ссылкой рабочего
+//пространства — участники с ролью не ниже required. Недоступные ссылки
+//неотличимы от несуществующих, кроме случая, когда участнику
+//пространства не хватает роли.
+func (s *urlshortenerServiceImpl) authorizeLink(ctx context.Context, userID, urlID string,
+	required common.Role) (common.Link, error) {
+	link, err := s.storage.GetLink(ctx, urlID)
+	if err != nil {
+		return common.Link{}, storageError(ctx, err)
+	}
+	if link.WorkspaceID != "" {
+		err = s.checkWorkspace(ctx, userID, link.WorkspaceID, required)
+		if errors.Is(err, storages.ErrNotFound) {
+			return common.Link{}, fmt.Errorf("short URL %s: %w", urlID, storages.ErrNotFound)
+		}
+		if err != nil {
+			return common.Link{}, err
+		}
+		return link, nil
+	}
+	if link.UserID != userID {
+		return common.Link{}, fmt.Errorf("short URL %s: %w", urlID, storages.ErrNotFound)
+	}
+	return link, nil
+}
+
+func (s *urlshortenerServiceImpl) GetRules(ctx context.Context, userID, urlID string) ([]common.RedirectRule, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.authorizeLink(ctx, userID, urlID, common.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	return link.Rules, nil
+}
+
+//SetRules заменяет правила перенаправления ссылки пользователя
+//и возвращает их после нормализации
+func (s *urlshortenerServiceImpl) SetRules(ctx context.Context, userID, urlID string,
+	rules []common.RedirectRule) ([]common.RedirectRule, error) {
+	rules, err := s.prepareRules(ctx, rules)
 	if err != nil {
 		return nil, err
 	}
+
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.authorizeLink(ctx, userID, urlID, common.RoleEditor)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.storage.SetRules(ctx, urlID, rules); err != nil {
+		return nil, storageError(ctx, err)
+	}
+	s.audit.Record(ctx, userID, common.AuditUpdateRules, link.ID, link.Rules, rules)
+	return rules, nil
+}
+
+//GetVariants возвращает варианты A/B-теста ссылки пользователя
+//вместе с числом переходов по каждому
+func (s *urlshortenerServiceImpl) GetVariants(ctx context.Context, userID, urlID string) ([]common.Variant, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.authorizeLink(ctx, userID, urlID, common.RoleViewer)
+	if err != nil {
+		return nil, err
+	}
+	return link.Variants, nil
+}
+
+//GetAllURL возвращает личные ссылки пользователя или, если задан
+//workspaceID, ссылки рабочего пространства, в котором он состоит
+func (s *urlshortenerServiceImpl) GetAllURL(ctx context.Context, userID, workspaceID string) ([]common.PairURL, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	if err := s.checkWorkspace(ctx, userID, workspaceID, common.RoleViewer); err != nil {
+		return nil, err
+	}
+	var res []common.PairURL
+	var err error
+	if workspaceID == "" {
+		res, err = s.storage.GetPairsByID(ctx, userID)
+	} else {
+		res, err = s.storage.GetPairsByWorkspace(ctx, workspaceID)
+	}
+	if err != nil {
+		return nil, storageError(ctx, err)
+	}
 	for i := 0; i < len(res); i++ {
 		shortWithBase, _ := common.Join(s.baseURL, res[i].ShortURL)
 		res[i].ShortURL = (*shortWithBase).String()
@@ -66,25 +475,62 @@ func (s *urlshortenerServiceImpl) GetAllURL(ctx context.Context, userID string)
 	return res, nil
 }
 
-func (s *urlshortenerServiceImpl) ShortenSomeURL(ctx context.Context,
-	userID string, expandURLwIDslice []common.PairURLwithCIDin) ([]common.PairURLwithCIDout, error) {
+//newLinks отбирает ссылки, которых ещё нет в хранилище: хранилище
+//пропускает уже существующие, и запись о создании в журнале аудита
+//и загрузка описания нужны только новым. Проверка идёт одним запросом
+//к основному хранилищу, чтобы отставшая реплика не выдала старую ссылку
+//за новую.
+func (s *urlshortenerServiceImpl) newLinks(ctx context.Context, links []common.Link) ([]common.Link, error) {
+	ids := make([]string, len(links))
+	for i, l := range links {
+		ids[i] = l.ID
+	}
+	existing, err := s.storage.ExistingIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]common.Link, 0, len(links))
+	for _, l := range links {
+		id := strings.TrimPrefix(l.ID, "/")
+		if existing[id] {
+			continue
+		}
+		existing[id] = true
+		res = append(res, l)
+	}
+	return res, nil
+}
+
+//ShortenSomeURL сокращает несколько URL. Ссылки рабочего пространства
+//получают случайные ID, как и ссылки с параметрами.
+func (s *urlshortenerServiceImpl) ShortenSomeURL(ctx context.Context, userID, workspaceID string,
+	expandURLwIDslice []common.PairURLwithCIDin) ([]common.PairURLwithCIDout, error) {
 	cap := len(expandURLwIDslice)
 	ResponseURLwIDslice := make([]common.PairURLwithCIDout, 0, cap)
 	tempURLpairSlice := make([]common.PairURL, 0, cap)
 
 	for _, v := range expandURLwIDslice {
 		correlationID := v.CorrelationID
-		urlParsed, err := url.Parse(v.OriginalURL)
+		normalizedURL, err := s.prepareURL(ctx, v.OriginalURL)
 		if err != nil {
 			return nil, err
 		}
-		shortURL, err := s.shorten(urlParsed)
+		urlParsed, err := url.Parse(normalizedURL)
+		if err != nil {
+			return nil, err
+		}
+		var shortURL *url.URL
+		if workspaceID == "" {
+			shortURL, err = s.shorten(urlParsed)
+		} else {
+			shortURL, err = s.shortenUnique()
+		}
 		if err != nil {
 			return nil, err
 		}
 
 		pairURL := common.PairURL{
-			ExpandURL: v.OriginalURL,
+			ExpandURL: normalizedURL,
 			ShortURL:  shortURL.Path,
 		}
 
@@ -96,8 +542,38 @@ func (s *urlshortenerServiceImpl) ShortenSomeURL(ctx context.Context,
 		ResponseURLwIDslice = append(ResponseURLwIDslice, URLwCIDout)
 		tempURLpairSlice = append(tempURLpairSlice, pairURL)
 	}
+	if err := s.checkWorkspace(ctx, userID, workspaceID, common.RoleEditor); err != nil {
+		return nil, err
+	}
 
-	s.storage.InsertSome(ctx, tempURLpairSlice, userID)
+	links := make([]common.Link, 0, len(tempURLpairSlice))
+	for _, p := range tempURLpairSlice {
+		links = append(links, common.Link{ID: p.ShortURL, ExpandURL: p.ExpandURL, UserID: userID,
+			WorkspaceID: workspaceID})
+	}
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	created, err := s.newLinks(ctx, links)
+	if err != nil {
+		return nil, storageError(ctx, err)
+	}
+	//в квоту идут только ссылки, которые будут созданы: повтор пачки
+	//и повторы внутри неё новых ссылок не добавляют
+	if err = s.checkQuota(ctx, userID, len(created)); err != nil {
+		return nil, err
+	}
+	if workspaceID == "" {
+		err = s.storage.InsertSome(ctx, tempURLpairSlice, userID)
+	} else {
+		err = s.storage.InsertLinks(ctx, links)
+	}
+	if err != nil {
+		return nil, storageError(ctx, err)
+	}
+	for _, l := range created {
+		s.audit.Record(ctx, userID, common.AuditCreate, l.ID, nil, l)
+		s.fetchPreview(l)
+	}
 
 	return ResponseURLwIDslice, nil
 }
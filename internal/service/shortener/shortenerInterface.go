@@ -11,9 +11,24 @@ var _ URLshortenerService = &urlshortenerServiceImpl{}
 
 type URLshortenerService interface {
 	shorten(_ *url.URL) (*url.URL, error)
-	ShortenURL(ctx context.Context, userID, url string) (string, error)
-	ExpandURL(ctx context.Context, urlID string) (string, error)
-	GetAllURL(ctx context.Context, userID string) ([]common.PairURL, error)
-	ShortenSomeURL(ctx context.Context,
-		userID string, expandURLwIDslice []common.PairURLwithCIDin) ([]common.PairURLwithCIDout, error)
+	ShortenURL(ctx context.Context, userID, url string, opts common.LinkOptions) (string, error)
+	ExpandURL(ctx context.Context, urlID string, client common.ClientInfo) (common.Redirect, error)
+	UnlockURL(ctx context.Context, urlID, password string, client common.ClientInfo) (common.Redirect, error)
+	//ShortURL возвращает полный короткий адрес существующей ссылки
+	ShortURL(ctx context.Context, urlID string) (string, error)
+	//PreviewURL возвращает ссылку с описанием страницы назначения,
+	//PasswordRequired для ссылок с паролем
+	PreviewURL(ctx context.Context, urlID string) (common.Link, error)
+	GetRules(ctx context.Context, userID, urlID string) ([]common.RedirectRule, error)
+	SetRules(ctx context.Context, userID, urlID string, rules []common.RedirectRule) ([]common.RedirectRule, error)
+	GetVariants(ctx context.Context, userID, urlID string) ([]common.Variant, error)
+	GetUTM(ctx context.Context, userID, urlID string) (common.UTM, error)
+	SetUTM(ctx context.Context, userID, urlID string, utm common.UTM) (common.UTM, error)
+	GetUserUTM(ctx context.Context, userID string) (common.UTM, error)
+	SetUserUTM(ctx context.Context, userID string, utm common.UTM) (common.UTM, error)
+	//GetAllURL возвращает личные ссылки пользователя или ссылки рабочего
+	//пространства workspaceID, если оно задано
+	GetAllURL(ctx context.Context, userID, workspaceID string) ([]common.PairURL, error)
+	ShortenSomeURL(ctx context.Context, userID, workspaceID string,
+		expandURLwIDslice []common.PairURLwithCIDin) ([]common.PairURLwithCIDout, error)
 }
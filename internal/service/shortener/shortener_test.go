@@ -0,0 +1,344 @@
+package shortener
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/service/policy"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestService(t *testing.T, cfg config.Config) (URLshortenerService, storages.Storage) {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = config.DefaultBaseURL
+	}
+	stg, _ := storages.NewInMemoryStorage()
+	pe, err := policy.New(cfg)
+	require.NoError(t, err)
+	return New(stg, pe, cfg), stg
+}
+
+func TestShortenSelfLink(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, config.Config{
+		BaseURLAliases: []string{"https://sho.rt"},
+		SelfLinkPolicy: SelfLinkResolve,
+	})
+
+	short, err := s.ShortenURL(ctx, "user", "http://ya.ru/", common.LinkOptions{})
+	require.NoError(t, err)
+
+	//короткая ссылка на короткую ссылку заменяется конечным адресом
+	_, err = s.ShortenURL(ctx, "user", short, common.LinkOptions{})
+	var uv *myerrors.UniqueViolation
+	require.True(t, errors.As(err, &uv))
+	assert.Equal(t, short, uv.ExistedShortURL)
+
+	_, err = s.ShortenURL(ctx, "user", "https://sho.rt/api/user/urls", common.LinkOptions{})
+	var sr *myerrors.SelfReference
+	assert.True(t, errors.As(err, &sr))
+	//адрес сервиса, похожий на короткую ссылку, но не являющийся ею
+	_, err = s.ShortenURL(ctx, "user", config.DefaultBaseURL+"ping", common.LinkOptions{})
+	assert.True(t, errors.As(err, &sr))
+
+	rejecting, _ := newTestService(t, config.Config{SelfLinkPolicy: SelfLinkReject})
+	_, err = rejecting.ShortenURL(ctx, "user", short, common.LinkOptions{})
+	assert.True(t, errors.As(err, &sr))
+}
+
+func TestExpandRedirectLoop(t *testing.T) {
+	ctx := context.Background()
+	s, stg := newTestService(t, config.Config{})
+
+	require.NoError(t, stg.Insert(ctx, "/a", config.DefaultBaseURL+"b", "user"))
+	require.NoError(t, stg.Insert(ctx, "/b", config.DefaultBaseURL+"a", "user"))
+	require.NoError(t, stg.Insert(ctx, "/c", config.DefaultBaseURL+"d", "user"))
+	require.NoError(t, stg.Insert(ctx, "/d", "http://ya.ru/", "user"))
+
+	_, err := s.ExpandURL(ctx, "/a", common.ClientInfo{})
+	var rl *myerrors.RedirectLoop
+	assert.True(t, errors.As(err, &rl))
+
+	res, err := s.ExpandURL(ctx, "/c", common.ClientInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "http://ya.ru/", res.URL)
+}
+
+func TestPasswordProtectedLink(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, config.Config{})
+
+	plain, err := s.ShortenURL(ctx, "user", "http://ya.ru/", common.LinkOptions{})
+	require.NoError(t, err)
+	protected, err := s.ShortenURL(ctx, "user", "http://ya.ru/", common.LinkOptions{Password: "secret"})
+	require.NoError(t, err)
+	assert.NotEqual(t, plain, protected)
+
+	id := strings.TrimPrefix(protected, config.DefaultBaseURL)
+	_, err = s.ExpandURL(ctx, "/"+id, common.ClientInfo{})
+	var pr *myerrors.PasswordRequired
+	assert.True(t, errors.As(err, &pr))
+
+	_, err = s.UnlockURL(ctx, "/"+id, "wrong", common.ClientInfo{})
+	var ip *myerrors.InvalidPassword
+	assert.True(t, errors.As(err, &ip))
+
+	res, err := s.UnlockURL(ctx, "/"+id, "secret", common.ClientInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "http://ya.ru/", res.URL)
+
+	//короткая ссылка на защищённую не раскрывает её адрес
+	_, err = s.ShortenURL(ctx, "user", protected, common.LinkOptions{})
+	var sr *myerrors.SelfReference
+	assert.True(t, errors.As(err, &sr))
+}
+
+func TestOneTimeLink(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, config.Config{})
+
+	short, err := s.ShortenURL(ctx, "user", "http://ya.ru/", common.LinkOptions{MaxClicks: 1})
+	require.NoError(t, err)
+	id := "/" + strings.TrimPrefix(short, config.DefaultBaseURL)
+
+	res, err := s.ExpandURL(ctx, id, common.ClientInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "http://ya.ru/", res.URL)
+
+	_, err = s.ExpandURL(ctx, id, common.ClientInfo{})
+	var lg *myerrors.LinkGone
+	assert.True(t, errors.As(err, &lg))
+}
+
+func TestDisabledLink(t *testing.T) {
+	ctx := context.Background()
+	s, stg := newTestService(t, config.Config{})
+
+	short, err := s.ShortenURL(ctx, "user", "http://ya.ru/", common.LinkOptions{})
+	require.NoError(t, err)
+	id := "/" + strings.TrimPrefix(short, config.DefaultBaseURL)
+	require.NoError(t, stg.SetDisabled(ctx, id, &common.LinkBlock{Legal: true}))
+
+	_, err = s.ExpandURL(ctx, id, common.ClientInfo{})
+	var ld *myerrors.LinkDisabled
+	require.True(t, errors.As(err, &ld))
+	assert.True(t, ld.Legal)
+	_, err = s.PreviewURL(ctx, id)
+	assert.True(t, errors.As(err, &ld))
+
+	require.NoError(t, stg.SetDisabled(ctx, id, nil))
+	_, err = s.ExpandURL(ctx, id, common.ClientInfo{})
+	assert.NoError(t, err)
+}
+
+func TestRedirectRules(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, config.Config{})
+
+	short, err := s.ShortenURL(ctx, "user", "https://example.com/app", common.LinkOptions{
+		Rules: []common.RedirectRule{{Platforms: []string{"ios"}, Target: "https://APPS.apple.com/app"}},
+	})
+	require.NoError(t, err)
+	id := strings.TrimPrefix(short, config.DefaultBaseURL)
+
+	iPhone := common.ClientInfo{UserAgent: "Mozilla/5.0 (iPhone; CPU iPhone OS 16_0 like Mac OS X)"}
+	res, err := s.ExpandURL(ctx, "/"+id, iPhone)
+	require.NoError(t, err)
+	assert.Equal(t, "https://apps.apple.com/app", res.URL)
+
+	_, err = s.SetRules(ctx, "other", id, nil)
+	assert.ErrorIs(t, err, storages.ErrNotFound)
+
+	rules, err := s.SetRules(ctx, "user", id, []common.RedirectRule{
+		{Languages: []string{"RU"}, Target: "https://example.com/ru/app"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ru"}, rules[0].Languages)
+
+	res, err = s.ExpandURL(ctx, "/"+id, iPhone)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/app", res.URL)
+}
+
+func TestSplitVariants(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, config.Config{})
+
+	short, err := s.ShortenURL(ctx, "user", "https://example.com/", common.LinkOptions{
+		Variants: []common.Variant{
+			{Name: "a", Target: "https://example.com/a", Weight: 1},
+			{Name: "b", Target: "https://example.com/b", Weight: 1},
+		},
+	})
+	require.NoError(t, err)
+	id := strings.TrimPrefix(short, config.DefaultBaseURL)
+
+	first, err := s.ExpandURL(ctx, "/"+id, common.ClientInfo{})
+	require.NoError(t, err)
+	require.NotEmpty(t, first.Variant)
+	//посетитель с назначенным вариантом всегда попадает в него
+	for i := 0; i < 10; i++ {
+		res, err := s.ExpandURL(ctx, "/"+id, common.ClientInfo{Variant: first.Variant})
+		require.NoError(t, err)
+		assert.Equal(t, first, res)
+	}
+
+	variants, err := s.GetVariants(ctx, "user", id)
+	require.NoError(t, err)
+	var clicks int64
+	for _, v := range variants {
+		clicks += v.Clicks
+		if v.Name == first.Variant {
+			assert.Equal(t, int64(11), v.Clicks)
+		}
+	}
+	assert.Equal(t, int64(11), clicks)
+}
+
+func TestUTMTagging(t *testing.T) {
+	ctx := context.Background()
+	s, stg := newTestService(t, config.Config{})
+
+	_, err := s.SetUserUTM(ctx, "user", common.UTM{Source: "newsletter", Medium: "email"})
+	require.NoError(t, err)
+	short, err := s.ShortenURL(ctx, "user", "https://example.com/?utm_medium=banner&q=a%20b",
+		common.LinkOptions{UTM: common.UTM{Campaign: "spring"}})
+	require.NoError(t, err)
+	id := strings.TrimPrefix(short, config.DefaultBaseURL)
+
+	res, err := s.ExpandURL(ctx, "/"+id, common.ClientInfo{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/?utm_medium=banner&q=a%20b&utm_campaign=spring&utm_source=newsletter",
+		res.URL)
+
+	//исходный адрес не меняется, ссылку можно перемечать
+	_, err = s.SetUTM(ctx, "user", id, common.UTM{Campaign: "summer"})
+	require.NoError(t, err)
+	link, err := stg.GetLink(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/?utm_medium=banner&q=a%20b", link.ExpandURL)
+	res, err = s.ExpandURL(ctx, "/"+id, common.ClientInfo{})
+	require.NoError(t, err)
+	assert.Contains(t, res.URL, "utm_campaign=summer")
+}
+
+func TestLinkPreview(t *testing.T) {
+	ctx := context.Background()
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<head><meta property="og:title" content="Example"></head>`))
+	}))
+	defer page.Close()
+	s, _ := newTestService(t, config.Config{PreviewFetch: true, PreviewFetchTimeout: time.Second})
+
+	short, err := s.ShortenURL(ctx, "user", page.URL+"/", common.LinkOptions{})
+	require.NoError(t, err)
+	id := strings.TrimPrefix(short, config.DefaultBaseURL)
+	//описание загружается в фоне после создания ссылки
+	assert.Eventually(t, func() bool {
+		link, err := s.PreviewURL(ctx, id)
+		return err == nil && link.Preview.Title == "Example"
+	}, time.Second, 10*time.Millisecond)
+
+	protected, err := s.ShortenURL(ctx, "user", page.URL+"/", common.LinkOptions{Password: "secret"})
+	require.NoError(t, err)
+	_, err = s.PreviewURL(ctx, strings.TrimPrefix(protected, config.DefaultBaseURL))
+	var pr *myerrors.PasswordRequired
+	assert.True(t, errors.As(err, &pr))
+}
+
+func TestBatchKeepsOtherUsersLink(t *testing.T) {
+	ctx := context.Background()
+	s, stg := newTestService(t, config.Config{})
+
+	short, err := s.ShortenURL(ctx, "alice", "http://ya.ru/", common.LinkOptions{})
+	require.NoError(t, err)
+	id := strings.TrimPrefix(short, config.DefaultBaseURL)
+	_, err = s.SetUTM(ctx, "alice", id, common.UTM{Source: "news"})
+	require.NoError(t, err)
+	require.NoError(t, stg.SetDisabled(ctx, id, &common.LinkBlock{Reason: "spam"}))
+
+	res, err := s.ShortenSomeURL(ctx, "bob", "", []common.PairURLwithCIDin{
+		{CorrelationID: "1", OriginalURL: "http://ya.ru/"},
+		{CorrelationID: "2", OriginalURL: "http://ya.ru/new"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, short, res[0].ShortURL)
+
+	link, err := stg.GetLink(ctx, id)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", link.UserID)
+	assert.Equal(t, common.UTM{Source: "news"}, link.UTM)
+	assert.NotNil(t, link.Disabled)
+	_, err = s.SetRules(ctx, "bob", id, nil)
+	assert.ErrorIs(t, err, storages.ErrNotFound)
+
+	records, err := stg.GetAudit(ctx, common.AuditFilter{ActorID: "bob", Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.NotEqual(t, id, records[0].LinkID)
+}
+
+func TestBatchQuotaCountsNewLinksOnly(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestService(t, config.Config{MaxLinksPerUser: 2})
+
+	batch := []common.PairURLwithCIDin{
+		{CorrelationID: "1", OriginalURL: "http://ya.ru/a"},
+		{CorrelationID: "2", OriginalURL: "http://ya.ru/a"},
+		{CorrelationID: "3", OriginalURL: "http://ya.ru/b"},
+	}
+	_, err := s.ShortenSomeURL(ctx, "user", "", batch)
+	require.NoError(t, err)
+	//повтор пачки ничего не создаёт и в квоту не упирается
+	_, err = s.ShortenSomeURL(ctx, "user", "", batch)
+	require.NoError(t, err)
+
+	_, err = s.ShortenSomeURL(ctx, "user", "", append(batch,
+		common.PairURLwithCIDin{CorrelationID: "4", OriginalURL: "http://ya.ru/c"}))
+	var qe *myerrors.QuotaExceeded
+	assert.True(t, errors.As(err, &qe))
+}
+
+func TestWorkspaceLinks(t *testing.T) {
+	ctx := context.Background()
+	s, stg := newTestService(t, config.Config{})
+	require.NoError(t, stg.SetMember(ctx, common.Member{WorkspaceID: "ws", UserID: "editor", Role: common.RoleEditor}))
+	require.NoError(t, stg.SetMember(ctx, common.Member{WorkspaceID: "ws", UserID: "viewer", Role: common.RoleViewer}))
+
+	short, err := s.ShortenURL(ctx, "editor", "http://ya.ru/", common.LinkOptions{WorkspaceID: "ws"})
+	require.NoError(t, err)
+	id := strings.TrimPrefix(short, config.DefaultBaseURL)
+
+	_, err = s.ShortenURL(ctx, "viewer", "http://ya.ru/", common.LinkOptions{WorkspaceID: "ws"})
+	var forbidden *myerrors.Forbidden
+	assert.True(t, errors.As(err, &forbidden))
+	_, err = s.ShortenURL(ctx, "stranger", "http://ya.ru/", common.LinkOptions{WorkspaceID: "ws"})
+	assert.ErrorIs(t, err, storages.ErrNotFound)
+
+	pairs, err := s.GetAllURL(ctx, "viewer", "ws")
+	require.NoError(t, err)
+	require.Len(t, pairs, 1)
+	assert.Equal(t, short, pairs[0].ShortURL)
+	_, err = s.GetAllURL(ctx, "stranger", "ws")
+	assert.ErrorIs(t, err, storages.ErrNotFound)
+
+	_, err = s.GetUTM(ctx, "viewer", id)
+	assert.NoError(t, err)
+	_, err = s.SetUTM(ctx, "viewer", id, common.UTM{Source: "x"})
+	assert.True(t, errors.As(err, &forbidden))
+	_, err = s.SetUTM(ctx, "editor", id, common.UTM{Source: "x"})
+	assert.NoError(t, err)
+	_, err = s.GetRules(ctx, "stranger", id)
+	assert.ErrorIs(t, err, storages.ErrNotFound)
+}
@@ -0,0 +1,120 @@
+package shortener
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+)
+
+//normalizeUTM убирает пробелы по краям меток
+func normalizeUTM(utm common.UTM) common.UTM {
+	return common.UTM{
+		Source:   strings.TrimSpace(utm.Source),
+		Medium:   strings.TrimSpace(utm.Medium),
+		Campaign: strings.TrimSpace(utm.Campaign),
+		Term:     strings.TrimSpace(utm.Term),
+		Content:  strings.TrimSpace(utm.Content),
+	}
+}
+
+//applyUTM дописывает метки в строку запроса rawURL. Параметры, которые
+//уже есть в адресе, не перезаписываются, а сам запрос не перекодируется.
+func applyUTM(rawURL string, utm common.UTM) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	present := u.Query()
+	extra := url.Values{}
+	for _, p := range []struct{ key, value string }{
+		{"utm_source", utm.Source},
+		{"utm_medium", utm.Medium},
+		{"utm_campaign", utm.Campaign},
+		{"utm_term", utm.Term},
+		{"utm_content", utm.Content},
+	} {
+		if _, ok := present[p.key]; p.value != "" && !ok {
+			extra.Set(p.key, p.value)
+		}
+	}
+	if len(extra) == 0 {
+		return rawURL, nil
+	}
+	if u.RawQuery != "" {
+		u.RawQuery += "&"
+	}
+	u.RawQuery += extra.Encode()
+	return u.String(), nil
+}
+
+//tag добавляет к адресу перенаправления метки ссылки и метки её владельца.
+//Без меток переход всё равно состоится, поэтому ошибки только логируются.
+func (s *urlshortenerServiceImpl) tag(ctx context.Context, link common.Link, target string) string {
+	userUTM, err := s.storage.GetUserUTM(ctx, link.UserID)
+	if err != nil {
+		log.Printf("unable to load UTM defaults of user %s: %v", link.UserID, err)
+	}
+	utm := link.UTM.Merge(userUTM)
+	if utm.IsEmpty() {
+		return target
+	}
+	tagged, err := applyUTM(target, utm)
+	if err != nil {
+		log.Printf("unable to tag %s: %v", target, err)
+		return target
+	}
+	return tagged
+}
+
+func (s *urlshortenerServiceImpl) GetUTM(ctx context.Context, userID, urlID string) (common.UTM, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.authorizeLink(ctx, userID, urlID, common.RoleViewer)
+	if err != nil {
+		return common.UTM{}, err
+	}
+	return link.UTM, nil
+}
+
+//SetUTM заменяет метки ссылки пользователя. Исходный адрес ссылки
+//не меняется, поэтому её можно перемечать сколько угодно раз.
+func (s *urlshortenerServiceImpl) SetUTM(ctx context.Context, userID, urlID string,
+	utm common.UTM) (common.UTM, error) {
+	utm = normalizeUTM(utm)
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	link, err := s.authorizeLink(ctx, userID, urlID, common.RoleEditor)
+	if err != nil {
+		return common.UTM{}, err
+	}
+	if err = s.storage.SetUTM(ctx, urlID, utm); err != nil {
+		return common.UTM{}, storageError(ctx, err)
+	}
+	s.audit.Record(ctx, userID, common.AuditUpdateUTM, link.ID, link.UTM, utm)
+	return utm, nil
+}
+
+func (s *urlshortenerServiceImpl) GetUserUTM(ctx context.Context, userID string) (common.UTM, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	utm, err := s.storage.GetUserUTM(ctx, userID)
+	if err != nil {
+		return common.UTM{}, storageError(ctx, err)
+	}
+	return utm, nil
+}
+
+//SetUserUTM задаёт метки по умолчанию для всех ссылок пользователя
+func (s *urlshortenerServiceImpl) SetUserUTM(ctx context.Context, userID string,
+	utm common.UTM) (common.UTM, error) {
+	utm = normalizeUTM(utm)
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	if err := s.storage.SetUserUTM(ctx, userID, utm); err != nil {
+		return common.UTM{}, storageError(ctx, err)
+	}
+	return utm, nil
+}
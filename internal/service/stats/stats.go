@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"context"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+type statsServiceImpl struct {
+	storage        storages.Storage
+	storageTimeout time.Duration
+}
+
+func New(stg storages.Storage, cfg config.Config) StatsService {
+	return &statsServiceImpl{
+		storage:        stg,
+		storageTimeout: cfg.StorageTimeout,
+	}
+}
+
+func (s *statsServiceImpl) withStorageTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.storageTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.storageTimeout)
+}
+
+func (s *statsServiceImpl) Stats(ctx context.Context) (common.Stats, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	urls, err := s.storage.CountURLs(ctx)
+	if err != nil {
+		return common.Stats{}, err
+	}
+	users, err := s.storage.CountUsers(ctx)
+	if err != nil {
+		return common.Stats{}, err
+	}
+	return common.Stats{URLs: urls, Users: users}, nil
+}
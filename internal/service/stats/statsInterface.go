@@ -0,0 +1,13 @@
+package stats
+
+import (
+	"context"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+)
+
+var _ StatsService = &statsServiceImpl{}
+
+type StatsService interface {
+	Stats(ctx context.Context) (common.Stats, error)
+}
@@ -0,0 +1,66 @@
+package targeting
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strconv"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+)
+
+//variantName имя варианта попадает в значение cookie
+var variantName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+//ChooseVariant возвращает вариант, назначенный посетителю ранее,
+//или выбирает новый случайно пропорционально весам
+func ChooseVariant(variants []common.Variant, assigned string) (common.Variant, bool) {
+	if len(variants) == 0 {
+		return common.Variant{}, false
+	}
+	total := 0
+	for _, v := range variants {
+		if assigned != "" && v.Name == assigned {
+			return v, true
+		}
+		total += v.Weight
+	}
+	if total <= 0 {
+		return variants[0], true
+	}
+	n := rand.Intn(total)
+	for _, v := range variants {
+		if n < v.Weight {
+			return v, true
+		}
+		n -= v.Weight
+	}
+	return variants[len(variants)-1], true
+}
+
+//NormalizeVariants проверяет веса и имена вариантов. Варианты без имени
+//получают порядковый номер, счётчики переходов обнуляются.
+//Адреса вариантов проверяются вызывающим.
+func NormalizeVariants(variants []common.Variant) ([]common.Variant, error) {
+	res := make([]common.Variant, 0, len(variants))
+	names := make(map[string]bool, len(variants))
+	for i, v := range variants {
+		if v.Name == "" {
+			v.Name = strconv.Itoa(i + 1)
+		}
+		if !variantName.MatchString(v.Name) {
+			return nil, fmt.Errorf("%w: variant name %q must be up to 32 letters, digits, '-' or '_'",
+				ErrInvalidRule, v.Name)
+		}
+		if names[v.Name] {
+			return nil, fmt.Errorf("%w: duplicate variant name %q", ErrInvalidRule, v.Name)
+		}
+		names[v.Name] = true
+		if v.Weight <= 0 {
+			return nil, fmt.Errorf("%w: variant %q must have a positive weight", ErrInvalidRule, v.Name)
+		}
+		v.Clicks = 0
+		res = append(res, v)
+	}
+	return res, nil
+}
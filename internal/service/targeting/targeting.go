@@ -0,0 +1,168 @@
+package targeting
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+)
+
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+	PlatformWindows = "windows"
+	PlatformMacOS   = "macos"
+	PlatformLinux   = "linux"
+)
+
+var ErrInvalidRule = errors.New("invalid redirect rule")
+
+var knownPlatforms = map[string]bool{
+	PlatformIOS:     true,
+	PlatformAndroid: true,
+	PlatformWindows: true,
+	PlatformMacOS:   true,
+	PlatformLinux:   true,
+}
+
+//platformMarkers проверяются по порядку: User-Agent iOS содержит
+//"Mac OS X", а Android — "Linux"
+var platformMarkers = []struct {
+	marker   string
+	platform string
+}{
+	{"iphone", PlatformIOS},
+	{"ipad", PlatformIOS},
+	{"ipod", PlatformIOS},
+	{"android", PlatformAndroid},
+	{"windows", PlatformWindows},
+	{"macintosh", PlatformMacOS},
+	{"mac os x", PlatformMacOS},
+	{"linux", PlatformLinux},
+}
+
+//Platform определяет операционную систему клиента по User-Agent,
+//пустая строка если она не распознана
+func Platform(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	for _, pm := range platformMarkers {
+		if strings.Contains(ua, pm.marker) {
+			return pm.platform
+		}
+	}
+	return ""
+}
+
+//Languages возвращает языки из Accept-Language в нижнем регистре,
+//кроме явно отвергнутых с q=0
+func Languages(acceptLanguage string) []string {
+	var langs []string
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if lang == "" || lang == "*" {
+			continue
+		}
+		rejected := false
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				rejected = err == nil && q == 0
+			}
+		}
+		if !rejected {
+			langs = append(langs, lang)
+		}
+	}
+	return langs
+}
+
+//Select возвращает адрес первого подошедшего правила, ok равен false,
+//если ни одно правило не подошло
+func Select(rules []common.RedirectRule, client common.ClientInfo) (target string, ok bool) {
+	if len(rules) == 0 {
+		return "", false
+	}
+	platform := Platform(client.UserAgent)
+	langs := Languages(client.AcceptLanguage)
+	for _, rule := range rules {
+		if matches(rule, client, platform, langs) {
+			return rule.Target, true
+		}
+	}
+	return "", false
+}
+
+func matches(rule common.RedirectRule, client common.ClientInfo, platform string, langs []string) bool {
+	if len(rule.Platforms) > 0 && !contains(rule.Platforms, platform) {
+		return false
+	}
+	if rule.UserAgent != "" &&
+		!strings.Contains(strings.ToLower(client.UserAgent), strings.ToLower(rule.UserAgent)) {
+		return false
+	}
+	if len(rule.Languages) > 0 && !matchLanguage(rule.Languages, langs) {
+		return false
+	}
+	for key, value := range rule.Query {
+		values, ok := client.Query[key]
+		if !ok || value != "" && !contains(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+//matchLanguage язык правила подходит к языку клиента целиком
+//или как основной подтег: "en" подходит для "en-us"
+func matchLanguage(ruleLangs, clientLangs []string) bool {
+	for _, cl := range clientLangs {
+		for _, rl := range ruleLangs {
+			if cl == rl || strings.HasPrefix(cl, rl+"-") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+//NormalizeRule проверяет условия правила и приводит платформы и языки
+//к нижнему регистру. Адрес правила проверяется вызывающим.
+func NormalizeRule(rule common.RedirectRule) (common.RedirectRule, error) {
+	res := common.RedirectRule{
+		UserAgent: strings.TrimSpace(rule.UserAgent),
+		Query:     rule.Query,
+		Target:    rule.Target,
+	}
+	for _, p := range rule.Platforms {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if !knownPlatforms[p] {
+			return res, fmt.Errorf("%w: unknown platform %q", ErrInvalidRule, p)
+		}
+		res.Platforms = append(res.Platforms, p)
+	}
+	for _, l := range rule.Languages {
+		l = strings.ToLower(strings.TrimSpace(l))
+		if l == "" {
+			return res, fmt.Errorf("%w: empty language", ErrInvalidRule)
+		}
+		res.Languages = append(res.Languages, l)
+	}
+	if len(res.Platforms) == 0 && res.UserAgent == "" &&
+		len(res.Languages) == 0 && len(res.Query) == 0 {
+		return res, fmt.Errorf("%w: no conditions, use the link URL as the default target", ErrInvalidRule)
+	}
+	return res, nil
+}
@@ -0,0 +1,89 @@
+package targeting
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 16_0 like Mac OS X) AppleWebKit/605.1.15"
+	androidUA = "Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 Chrome/116.0 Mobile"
+	desktopUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 Chrome/116.0"
+)
+
+func TestSelect(t *testing.T) {
+	link := common.Link{
+		ExpandURL: "https://example.com/app",
+		Rules: []common.RedirectRule{
+			{Platforms: []string{PlatformIOS}, Target: "https://apps.apple.com/app"},
+			{Platforms: []string{PlatformAndroid}, Target: "https://play.google.com/app"},
+			{Languages: []string{"ru"}, Target: "https://example.com/ru/app"},
+			{Query: map[string]string{"beta": ""}, Target: "https://example.com/beta"},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		client common.ClientInfo
+		want   string
+	}{
+		{"ios", common.ClientInfo{UserAgent: iPhoneUA}, "https://apps.apple.com/app"},
+		{"android", common.ClientInfo{UserAgent: androidUA}, "https://play.google.com/app"},
+		{"language", common.ClientInfo{UserAgent: desktopUA, AcceptLanguage: "ru-RU,ru;q=0.9"},
+			"https://example.com/ru/app"},
+		{"rejected language", common.ClientInfo{UserAgent: desktopUA, AcceptLanguage: "en, ru;q=0"},
+			"https://example.com/app"},
+		{"query", common.ClientInfo{UserAgent: desktopUA, Query: url.Values{"beta": {"1"}}},
+			"https://example.com/beta"},
+		{"default", common.ClientInfo{UserAgent: desktopUA}, "https://example.com/app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, ok := Select(link.Rules, tt.client)
+			if !ok {
+				target = link.ExpandURL
+			}
+			assert.Equal(t, tt.want, target)
+		})
+	}
+}
+
+func TestNormalizeRule(t *testing.T) {
+	rule, err := NormalizeRule(common.RedirectRule{Platforms: []string{" iOS "}, Languages: []string{"EN"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{PlatformIOS}, rule.Platforms)
+	assert.Equal(t, []string{"en"}, rule.Languages)
+
+	_, err = NormalizeRule(common.RedirectRule{Platforms: []string{"symbian"}})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+	_, err = NormalizeRule(common.RedirectRule{Target: "https://example.com"})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+}
+
+func TestChooseVariant(t *testing.T) {
+	variants, err := NormalizeVariants([]common.Variant{
+		{Target: "https://example.com/a", Weight: 3},
+		{Target: "https://example.com/b", Weight: 1},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "1", variants[0].Name)
+
+	counts := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		v, ok := ChooseVariant(variants, "")
+		assert.True(t, ok)
+		counts[v.Name]++
+	}
+	assert.InDelta(t, 3000, counts["1"], 200)
+
+	v, _ := ChooseVariant(variants, "2")
+	assert.Equal(t, "https://example.com/b", v.Target)
+
+	_, err = NormalizeVariants([]common.Variant{{Name: "a", Weight: 1}, {Name: "a", Weight: 1}})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+	_, err = NormalizeVariants([]common.Variant{{Name: "a", Weight: 0}})
+	assert.ErrorIs(t, err, ErrInvalidRule)
+}
@@ -0,0 +1,109 @@
+package validation
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"golang.org/x/net/idna"
+)
+
+var errEmptyHost = errors.New("host is empty")
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+type urlValidatorImpl struct {
+	allowedSchemes map[string]bool
+	maxLength      int
+	sortQuery      bool
+}
+
+//DefaultSchemes используются, если список разрешённых схем не задан
+var DefaultSchemes = []string{"http", "https"}
+
+func New(cfg config.Config) URLValidator {
+	allowed := cfg.AllowedURLSchemes
+	if len(allowed) == 0 {
+		allowed = DefaultSchemes
+	}
+	schemes := make(map[string]bool, len(allowed))
+	for _, s := range allowed {
+		schemes[strings.ToLower(strings.TrimSpace(s))] = true
+	}
+	return &urlValidatorImpl{
+		allowedSchemes: schemes,
+		maxLength:      cfg.MaxURLLength,
+		sortQuery:      cfg.SortQueryParams,
+	}
+}
+
+//Normalize принимает только абсолютные URL с разрешённой схемой и
+//приводит их к каноническому виду: схема и хост в нижнем регистре,
+//интернационализированный домен в punycode, порт по умолчанию убран,
+//параметры запроса по желанию отсортированы. Эквивалентные URL после
+//нормализации совпадают и получают одну и ту же короткую ссылку.
+func (v *urlValidatorImpl) Normalize(rawURL string) (string, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return "", myerrors.NewInvalidURL(rawURL, "URL is empty")
+	}
+	if v.maxLength > 0 && len(rawURL) > v.maxLength {
+		return "", myerrors.NewInvalidURL(rawURL, "URL is too long")
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", myerrors.NewInvalidURL(rawURL, err.Error())
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !u.IsAbs() || u.Host == "" {
+		return "", myerrors.NewInvalidURL(rawURL, "URL must be absolute")
+	}
+	if !v.allowedSchemes[u.Scheme] {
+		return "", myerrors.NewInvalidURL(rawURL, "scheme "+u.Scheme+" is not allowed")
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", myerrors.NewInvalidURL(rawURL, err.Error())
+	}
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	if port != "" {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		//IPv6 адрес без порта
+		host = "[" + host + "]"
+	}
+	u.Host = host
+
+	if v.sortQuery && u.RawQuery != "" {
+		u.RawQuery = u.Query().Encode()
+	}
+
+	normalized := u.String()
+	if v.maxLength > 0 && len(normalized) > v.maxLength {
+		return "", myerrors.NewInvalidURL(rawURL, "URL is too long")
+	}
+	return normalized, nil
+}
+
+//normalizeHost переводит хост в нижний регистр и punycode (IDNA)
+func normalizeHost(host string) (string, error) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return "", errEmptyHost
+	}
+	if net.ParseIP(host) != nil {
+		return host, nil
+	}
+	return idna.Lookup.ToASCII(host)
+}
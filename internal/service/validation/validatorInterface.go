@@ -0,0 +1,8 @@
+package validation
+
+var _ URLValidator = &urlValidatorImpl{}
+
+type URLValidator interface {
+	//Normalize проверяет URL и приводит его к каноническому виду
+	Normalize(rawURL string) (string, error)
+}
@@ -0,0 +1,91 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       config.Config
+		rawURL    string
+		want      string
+		expectErr bool
+	}{
+		{
+			name:   "host and scheme lowercased, default port stripped",
+			rawURL: "HTTP://Ya.RU:80/Path?q=1",
+			want:   "http://ya.ru/Path?q=1",
+		},
+		{
+			name:   "non default port kept",
+			rawURL: "https://ya.ru:8443/",
+			want:   "https://ya.ru:8443/",
+		},
+		{
+			name:   "IDN converted to punycode",
+			rawURL: "http://пример.рф/",
+			want:   "http://xn--e1afmkfd.xn--p1ai/",
+		},
+		{
+			name:   "query sorted",
+			cfg:    config.Config{SortQueryParams: true},
+			rawURL: "http://ya.ru/?b=2&a=1",
+			want:   "http://ya.ru/?a=1&b=2",
+		},
+		{
+			name:      "empty",
+			rawURL:    "  ",
+			expectErr: true,
+		},
+		{
+			name:      "relative path",
+			rawURL:    "/some/path",
+			expectErr: true,
+		},
+		{
+			name:      "javascript scheme",
+			rawURL:    "javascript:alert(1)",
+			expectErr: true,
+		},
+		{
+			name:      "scheme not in allowlist",
+			cfg:       config.Config{AllowedURLSchemes: []string{"https"}},
+			rawURL:    "http://ya.ru",
+			expectErr: true,
+		},
+		{
+			name:      "too long",
+			cfg:       config.Config{MaxURLLength: 16},
+			rawURL:    "http://ya.ru/some/long/path",
+			expectErr: true,
+		},
+		{
+			name:   "longest allowed by default",
+			cfg:    config.Config{MaxURLLength: 2048},
+			rawURL: "http://ya.ru/" + strings.Repeat("a", 2048-len("http://ya.ru/")),
+			want:   "http://ya.ru/" + strings.Repeat("a", 2048-len("http://ya.ru/")),
+		},
+		{
+			name:      "one over the default limit",
+			cfg:       config.Config{MaxURLLength: 2048},
+			rawURL:    "http://ya.ru/" + strings.Repeat("a", 2049-len("http://ya.ru/")),
+			expectErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := New(tt.cfg).Normalize(tt.rawURL)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package workspace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+)
+
+const maxNameLength = 100
+
+var (
+	ErrInvalidName     = errors.New("workspace name must be 1 to 100 characters")
+	ErrInvalidRole     = errors.New("role must be one of owner, editor, viewer")
+	ErrAccountRequired = errors.New("workspaces are available to registered accounts only")
+	ErrLastOwner       = errors.New("workspace must keep at least one owner")
+)
+
+type workspaceServiceImpl struct {
+	storage        storages.Storage
+	storageTimeout time.Duration
+	now            func() time.Time
+}
+
+func New(stg storages.Storage, cfg config.Config) WorkspaceService {
+	return &workspaceServiceImpl{
+		storage:        stg,
+		storageTimeout: cfg.StorageTimeout,
+		now:            time.Now,
+	}
+}
+
+func (s *workspaceServiceImpl) withStorageTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.storageTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.storageTimeout)
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+//Create доступен только учётным записям: анонимный пользователь теряет
+//свой ID вместе с cookie, и пространство осталось бы без владельца
+func (s *workspaceServiceImpl) Create(ctx context.Context, userID, name string) (common.Workspace, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+		return common.Workspace{}, ErrInvalidName
+	}
+	id, err := randomHex(8)
+	if err != nil {
+		return common.Workspace{}, err
+	}
+
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	_, err = s.storage.GetAccount(ctx, userID)
+	if errors.Is(err, storages.ErrNotFound) {
+		return common.Workspace{}, ErrAccountRequired
+	}
+	if err != nil {
+		return common.Workspace{}, err
+	}
+	workspace := common.Workspace{ID: id, Name: name, CreatedAt: s.now().UTC()}
+	if err = s.storage.InsertWorkspace(ctx, workspace); err != nil {
+		return common.Workspace{}, err
+	}
+	owner := common.Member{WorkspaceID: id, UserID: userID, Role: common.RoleOwner}
+	if err = s.storage.SetMember(ctx, owner); err != nil {
+		return common.Workspace{}, err
+	}
+	return workspace, nil
+}
+
+func (s *workspaceServiceImpl) List(ctx context.Context, userID string) ([]common.UserWorkspace, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	memberships, err := s.storage.GetMemberships(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]common.UserWorkspace, 0, len(memberships))
+	for _, m := range memberships {
+		workspace, err := s.storage.GetWorkspace(ctx, m.WorkspaceID)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, common.UserWorkspace{Workspace: workspace, Role: m.Role})
+	}
+	return res, nil
+}
+
+func (s *workspaceServiceImpl) Members(ctx context.Context, userID, workspaceID string) ([]common.Member, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	if _, err := s.authorize(ctx, userID, workspaceID, common.RoleViewer); err != nil {
+		return nil, err
+	}
+	return s.storage.GetMembers(ctx, workspaceID)
+}
+
+func (s *workspaceServiceImpl) SetMember(ctx context.Context, userID, workspaceID, email string,
+	role common.Role) (common.Member, error) {
+	role = common.Role(strings.ToLower(strings.TrimSpace(string(role))))
+	if !role.Valid() {
+		return common.Member{}, ErrInvalidRole
+	}
+
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	if _, err := s.authorize(ctx, userID, workspaceID, common.RoleOwner); err != nil {
+		return common.Member{}, err
+	}
+	account, err := s.storage.GetAccountByEmail(ctx, strings.ToLower(strings.TrimSpace(email)))
+	if err != nil {
+		return common.Member{}, err
+	}
+	member := common.Member{WorkspaceID: workspaceID, UserID: account.ID, Role: role}
+	if role != common.RoleOwner {
+		if err = s.keepOwner(ctx, workspaceID, account.ID); err != nil {
+			return common.Member{}, err
+		}
+	}
+	if err = s.storage.SetMember(ctx, member); err != nil {
+		return common.Member{}, err
+	}
+	return member, nil
+}
+
+func (s *workspaceServiceImpl) RemoveMember(ctx context.Context, userID, workspaceID, memberID string) error {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	required := common.RoleOwner
+	if memberID == userID {
+		required = common.RoleViewer
+	}
+	if _, err := s.authorize(ctx, userID, workspaceID, required); err != nil {
+		return err
+	}
+	if err := s.keepOwner(ctx, workspaceID, memberID); err != nil {
+		return err
+	}
+	return s.storage.DeleteMember(ctx, workspaceID, memberID)
+}
+
+//keepOwner проверяет, что после снятия роли владельца с memberID
+//в рабочем пространстве останется другой владелец
+func (s *workspaceServiceImpl) keepOwner(ctx context.Context, workspaceID, memberID string) error {
+	members, err := s.storage.GetMembers(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.Role == common.RoleOwner && m.UserID != memberID {
+			return nil
+		}
+	}
+	for _, m := range members {
+		if m.UserID == memberID && m.Role == common.RoleOwner {
+			return ErrLastOwner
+		}
+	}
+	return nil
+}
+
+func (s *workspaceServiceImpl) Authorize(ctx context.Context, userID, workspaceID string,
+	required common.Role) (common.Member, error) {
+	ctx, cancel := s.withStorageTimeout(ctx)
+	defer cancel()
+	return s.authorize(ctx, userID, workspaceID, required)
+}
+
+func (s *workspaceServiceImpl) authorize(ctx context.Context, userID, workspaceID string,
+	required common.Role) (common.Member, error) {
+	member, err := s.storage.GetMember(ctx, workspaceID, userID)
+	if err != nil {
+		return common.Member{}, err
+	}
+	if !member.Role.Includes(required) {
+		return common.Member{}, myerrors.NewForbidden(workspaceID, string(member.Role), string(required))
+	}
+	return member, nil
+}
@@ -0,0 +1,27 @@
+package workspace
+
+import (
+	"context"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+)
+
+var _ WorkspaceService = &workspaceServiceImpl{}
+
+type WorkspaceService interface {
+	//Create создаёт рабочее пространство, userID становится его владельцем
+	Create(ctx context.Context, userID, name string) (common.Workspace, error)
+	//List возвращает рабочие пространства пользователя с его ролями в них
+	List(ctx context.Context, userID string) ([]common.UserWorkspace, error)
+	Members(ctx context.Context, userID, workspaceID string) ([]common.Member, error)
+	//SetMember добавляет в рабочее пространство учётную запись с адресом
+	//email или меняет её роль. Доступно только владельцам.
+	SetMember(ctx context.Context, userID, workspaceID, email string, role common.Role) (common.Member, error)
+	//RemoveMember исключает участника. Владельцы исключают любого,
+	//остальные могут только выйти сами.
+	RemoveMember(ctx context.Context, userID, workspaceID, memberID string) error
+	//Authorize возвращает участие пользователя в рабочем пространстве, если
+	//его роль не ниже required. Не состоящим в пространстве оно неотличимо
+	//от несуществующего: возвращается storages.ErrNotFound.
+	Authorize(ctx context.Context, userID, workspaceID string, required common.Role) (common.Member, error)
+}
@@ -0,0 +1,54 @@
+package workspace
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+	"github.com/sandor-clegane/urlshortener/internal/storages"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkspaceMembers(t *testing.T) {
+	ctx := context.Background()
+	stg, _ := storages.NewInMemoryStorage()
+	s := New(stg, config.Config{})
+	require.NoError(t, stg.InsertAccount(ctx, common.Account{ID: "alice", Email: "alice@example.com"}))
+	require.NoError(t, stg.InsertAccount(ctx, common.Account{ID: "bob", Email: "bob@example.com"}))
+
+	_, err := s.Create(ctx, "anonymous", "team")
+	assert.ErrorIs(t, err, ErrAccountRequired)
+	_, err = s.Create(ctx, "alice", " ")
+	assert.ErrorIs(t, err, ErrInvalidName)
+	ws, err := s.Create(ctx, "alice", "team")
+	require.NoError(t, err)
+
+	_, err = s.SetMember(ctx, "alice", ws.ID, "bob@example.com", "admin")
+	assert.ErrorIs(t, err, ErrInvalidRole)
+	member, err := s.SetMember(ctx, "alice", ws.ID, "BOB@example.com", "Viewer")
+	require.NoError(t, err)
+	assert.Equal(t, common.Member{WorkspaceID: ws.ID, UserID: "bob", Role: common.RoleViewer}, member)
+
+	list, err := s.List(ctx, "bob")
+	require.NoError(t, err)
+	assert.Equal(t, []common.UserWorkspace{{Workspace: ws, Role: common.RoleViewer}}, list)
+
+	_, err = s.SetMember(ctx, "bob", ws.ID, "bob@example.com", common.RoleOwner)
+	var forbidden *myerrors.Forbidden
+	assert.True(t, errors.As(err, &forbidden))
+	_, err = s.Members(ctx, "stranger", ws.ID)
+	assert.ErrorIs(t, err, storages.ErrNotFound)
+
+	_, err = s.SetMember(ctx, "alice", ws.ID, "alice@example.com", common.RoleEditor)
+	assert.ErrorIs(t, err, ErrLastOwner)
+	assert.ErrorIs(t, s.RemoveMember(ctx, "alice", ws.ID, "alice"), ErrLastOwner)
+
+	require.NoError(t, s.RemoveMember(ctx, "bob", ws.ID, "bob"))
+	members, err := s.Members(ctx, "alice", ws.ID)
+	require.NoError(t, err)
+	assert.Len(t, members, 1)
+}
@@ -0,0 +1,157 @@
+package storages
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/common/myerrors"
+	"github.com/sandor-clegane/urlshortener/internal/config"
+)
+
+const (
+	replicaLagQuery = "SELECT CASE " +
+		"WHEN NOT pg_is_in_recovery() THEN 0 " +
+		"WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0 " +
+		"ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0) " +
+		"END"
+	replicaCheckInterval = 5 * time.Second
+)
+
+//DBPool общий пул соединений с основной базой и, если она задана,
+//с репликой для чтения. Пул создаётся один раз на всё приложение.
+type DBPool struct {
+	Primary *sql.DB
+	Replica *sql.DB
+
+	maxLag time.Duration
+	//replicaOK равен 1, если реплика доступна и её отставание не превышает maxLag
+	replicaOK int32
+	done      chan struct{}
+}
+
+func OpenDBPool(cfg config.Config) (*DBPool, error) {
+	primary, err := openDB(cfg.DatabaseDSN, cfg)
+	if err != nil {
+		return nil, err
+	}
+	p := &DBPool{
+		Primary: primary,
+		maxLag:  cfg.DBReplicaMaxLag,
+		done:    make(chan struct{}),
+	}
+	if cfg.DatabaseReplicaDSN == config.DefaultDatabaseReplicaDSN {
+		return p, nil
+	}
+
+	p.Replica, err = openDB(cfg.DatabaseReplicaDSN, cfg)
+	if err != nil {
+		primary.Close()
+		return nil, err
+	}
+	p.checkReplica()
+	go p.watchReplica()
+
+	return p, nil
+}
+
+func openDB(dsn string, cfg config.Config) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	db.SetMaxIdleConns(cfg.DBMaxIdleConns)
+	db.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.DBConnMaxIdleTime)
+	return db, nil
+}
+
+//Reader возвращает соединение для чтения: реплику, если она в порядке,
+//иначе основную базу
+func (p *DBPool) Reader() *sql.DB {
+	if p.Replica != nil && atomic.LoadInt32(&p.replicaOK) == 1 {
+		return p.Replica
+	}
+	return p.Primary
+}
+
+//markReplicaDown исключает реплику из чтения до следующей успешной проверки
+func (p *DBPool) markReplicaDown(err error) {
+	if atomic.CompareAndSwapInt32(&p.replicaOK, 1, 0) {
+		log.Printf("read replica disabled: %v", err)
+	}
+}
+
+//PingReplica проверяет доступность реплики и её отставание от основной базы
+func (p *DBPool) PingReplica(ctx context.Context) error {
+	if p.Replica == nil {
+		return nil
+	}
+	var lagSeconds float64
+	err := p.Replica.QueryRowContext(ctx, replicaLagQuery).Scan(&lagSeconds)
+	if err != nil {
+		return err
+	}
+	lag := time.Duration(lagSeconds * float64(time.Second))
+	if p.maxLag > 0 && lag > p.maxLag {
+		return myerrors.NewReplicaLag(lag, p.maxLag)
+	}
+	return nil
+}
+
+func (p *DBPool) checkReplica() {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaCheckInterval)
+	defer cancel()
+
+	err := p.PingReplica(ctx)
+	if err != nil {
+		p.markReplicaDown(err)
+		return
+	}
+	if atomic.CompareAndSwapInt32(&p.replicaOK, 0, 1) {
+		log.Printf("read replica enabled")
+	}
+}
+
+func (p *DBPool) watchReplica() {
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.checkReplica()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+//queryReplicaFallback выполняет чтение на реплике и повторяет его на основной
+//базе, если реплика не ответила или не нашла строку: отстающая реплика
+//может ещё не знать о только что созданной ссылке. Отсутствие строки
+//и отмена контекста не считаются отказом реплики.
+func (p *DBPool) queryReplicaFallback(ctx context.Context, query func(db *sql.DB) error) error {
+	db := p.Reader()
+	err := query(db)
+	if err == nil || db == p.Primary || ctx.Err() != nil {
+		return err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		p.markReplicaDown(err)
+	}
+	return query(p.Primary)
+}
+
+func (p *DBPool) Close() error {
+	close(p.done)
+	if p.Replica != nil {
+		if err := p.Replica.Close(); err != nil {
+			log.Printf("unable to close read replica: %v", err)
+		}
+	}
+	return p.Primary.Close()
+}
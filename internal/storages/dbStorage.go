@@ -3,56 +3,624 @@ package storages
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
 
+	"github.com/lib/pq"
 	"github.com/sandor-clegane/urlshortener/internal/common"
 )
 
 const (
 	initQuery = "CREATE TABLE IF NOT EXISTS urls " +
 		"(id varchar(255) PRIMARY KEY, " +
-		"expand_url varchar(255) UNIQUE, " +
-		"user_id varchar(255))"
+		"expand_url text, " +
+		"user_id varchar(255), " +
+		"password_hash varchar(255) NOT NULL DEFAULT '', " +
+		"click_limit integer NOT NULL DEFAULT 0, " +
+		"clicks_left integer NOT NULL DEFAULT 0, " +
+		"rules jsonb, " +
+		"variants jsonb, " +
+		"utm jsonb, " +
+		"preview jsonb, " +
+		"workspace_id varchar(64), " +
+		"disabled jsonb)"
+	initVariantClicksQuery = "CREATE TABLE IF NOT EXISTS variant_clicks " +
+		"(id varchar(255), " +
+		"variant varchar(255), " +
+		"clicks bigint NOT NULL DEFAULT 0, " +
+		"PRIMARY KEY (id, variant))"
+	//linkColumns колонки ссылки в порядке, который ожидает scanLink
+	linkColumns = "id, expand_url, user_id, password_hash, click_limit, clicks_left, rules, variants, utm, preview, " +
+		"workspace_id, disabled"
+	getLinkQuery = "SELECT " + linkColumns + " FROM urls " +
+		"WHERE id=$1"
+	existingIDsQuery = "SELECT id FROM urls WHERE id = ANY($1)"
+	insertLinkQuery  = "INSERT INTO urls (" + linkColumns + ") " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) " +
+		"ON CONFLICT DO NOTHING"
+	initUserSettingsQuery = "CREATE TABLE IF NOT EXISTS user_settings " +
+		"(user_id varchar(255) PRIMARY KEY, " +
+		"utm jsonb)"
+	getUserUTMQuery = "SELECT utm FROM user_settings WHERE user_id=$1"
+	setUserUTMQuery = "INSERT INTO user_settings (user_id, utm) VALUES ($1, $2) " +
+		"ON CONFLICT (user_id) DO UPDATE SET utm = EXCLUDED.utm"
+	initAPIKeysQuery = "CREATE TABLE IF NOT EXISTS api_keys " +
+		"(id varchar(64) PRIMARY KEY, " +
+		"user_id varchar(255) NOT NULL, " +
+		"name varchar(255) NOT NULL DEFAULT '', " +
+		"prefix varchar(64) NOT NULL, " +
+		"key_hash varchar(64) NOT NULL UNIQUE, " +
+		"scopes jsonb, " +
+		"created_at timestamptz NOT NULL)"
+	apiKeyColumns     = "id, user_id, name, prefix, key_hash, scopes, created_at"
+	insertAPIKeyQuery = "INSERT INTO api_keys (" + apiKeyColumns + ") " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7) " +
+		"ON CONFLICT DO NOTHING"
+	getAPIKeyQuery  = "SELECT " + apiKeyColumns + " FROM api_keys WHERE key_hash=$1"
+	getAPIKeysQuery = "SELECT " + apiKeyColumns + " FROM api_keys WHERE user_id=$1 " +
+		"ORDER BY created_at"
+	deleteAPIKeyQuery = "DELETE FROM api_keys WHERE id=$1 AND user_id=$2"
+
+	initAccountsQuery = "CREATE TABLE IF NOT EXISTS accounts " +
+		"(id varchar(255) PRIMARY KEY, " +
+		"email varchar(255) NOT NULL UNIQUE, " +
+		"password_hash varchar(255) NOT NULL, " +
+		"created_at timestamptz NOT NULL)"
+	accountColumns     = "id, email, password_hash, created_at"
+	insertAccountQuery = "INSERT INTO accounts (" + accountColumns + ") " +
+		"VALUES ($1, $2, $3, $4) " +
+		"ON CONFLICT DO NOTHING"
+	getAccountQuery        = "SELECT " + accountColumns + " FROM accounts WHERE id=$1"
+	getAccountByEmailQuery = "SELECT " + accountColumns + " FROM accounts WHERE email=$1"
+	reassignLinksQuery     = "UPDATE urls SET user_id=$2 WHERE user_id=$1"
+
+	initWorkspacesQuery = "CREATE TABLE IF NOT EXISTS workspaces " +
+		"(id varchar(64) PRIMARY KEY, " +
+		"name varchar(255) NOT NULL, " +
+		"created_at timestamptz NOT NULL)"
+	initWorkspaceMembersQuery = "CREATE TABLE IF NOT EXISTS workspace_members " +
+		"(workspace_id varchar(64), " +
+		"user_id varchar(255), " +
+		"role varchar(16) NOT NULL, " +
+		"PRIMARY KEY (workspace_id, user_id))"
+	insertWorkspaceQuery = "INSERT INTO workspaces (id, name, created_at) " +
+		"VALUES ($1, $2, $3) " +
+		"ON CONFLICT DO NOTHING"
+	getWorkspaceQuery = "SELECT id, name, created_at FROM workspaces WHERE id=$1"
+	setMemberQuery    = "INSERT INTO workspace_members (workspace_id, user_id, role) " +
+		"VALUES ($1, $2, $3) " +
+		"ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = EXCLUDED.role"
+	memberColumns  = "workspace_id, user_id, role"
+	getMemberQuery = "SELECT " + memberColumns + " FROM workspace_members " +
+		"WHERE workspace_id=$1 AND user_id=$2"
+	getMembersQuery = "SELECT " + memberColumns + " FROM workspace_members " +
+		"WHERE workspace_id=$1 ORDER BY user_id"
+	getMembershipsQuery = "SELECT " + memberColumns + " FROM workspace_members " +
+		"WHERE user_id=$1 ORDER BY workspace_id"
+	deleteMemberQuery    = "DELETE FROM workspace_members WHERE workspace_id=$1 AND user_id=$2"
+	getWorkspaceURLQuery = "SELECT id, expand_url " +
+		"FROM urls " +
+		"WHERE workspace_id=$1"
+
+	initAuditQuery = "CREATE TABLE IF NOT EXISTS audit_log " +
+		"(id bigserial PRIMARY KEY, " +
+		"time timestamptz NOT NULL, " +
+		"actor_id varchar(255) NOT NULL, " +
+		"action varchar(32) NOT NULL, " +
+		"link_id varchar(255) NOT NULL, " +
+		"old_value jsonb, " +
+		"new_value jsonb, " +
+		"request_id varchar(64) NOT NULL DEFAULT '')"
+	auditColumns     = "id, time, actor_id, action, link_id, old_value, new_value, request_id"
+	appendAuditQuery = "INSERT INTO audit_log (time, actor_id, action, link_id, old_value, new_value, request_id) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id"
+	//Запись с готовым ID приходит от основного хранилища, повтор не дублирует её
+	appendAuditWithIDQuery = "INSERT INTO audit_log " +
+		"(id, time, actor_id, action, link_id, old_value, new_value, request_id) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8) ON CONFLICT (id) DO NOTHING"
+
+	getVariantClicksQuery = "SELECT variant, clicks FROM variant_clicks " +
+		"WHERE id=$1"
+	insertVariantClicksQuery = "INSERT INTO variant_clicks (id, variant, clicks) " +
+		"VALUES ($1, $2, $3) " +
+		"ON CONFLICT DO NOTHING"
+	//countVariantClickQuery увеличивает счётчик только существующего варианта
+	countVariantClickQuery = "INSERT INTO variant_clicks (id, variant, clicks) " +
+		"SELECT $1::varchar, $2::varchar, 1 WHERE EXISTS " +
+		"(SELECT 1 FROM urls, jsonb_array_elements(urls.variants) v " +
+		"WHERE urls.id = $1::varchar AND v->>'name' = $2::varchar) " +
+		"ON CONFLICT (id, variant) DO UPDATE SET clicks = variant_clicks.clicks + 1"
+	//useClickQuery проверяет и уменьшает счётчик одним запросом, поэтому
+	//параллельные переходы не могут превысить ограничение
+	useClickQuery = "UPDATE urls SET clicks_left = clicks_left - 1 " +
+		"WHERE id=$1 AND click_limit > 0 AND clicks_left > 0"
 	getAllURLQuery = "SELECT id, expand_url " +
 		"FROM urls " +
 		"WHERE user_id=$1"
 	getExpandURLQuery = "SELECT expand_url FROM urls " +
 		"WHERE id=$1"
-	insertURLQuery = "INSERT INTO urls (id, expand_url, user_id) " +
-		"VALUES ($1, $2, $3)"
+	//insertURLQueryWithConstraint пропускает уже сокращённые URL, чтобы
+	//повторная пачка не откатывалась целиком
 	insertURLQueryWithConstraint = "INSERT INTO urls (id, expand_url, user_id) " +
 		"VALUES ($1, $2, $3) " +
 		"ON CONFLICT DO NOTHING"
+	setRulesQuery      = "UPDATE urls SET rules=$2 WHERE id=$1"
+	setUTMQuery        = "UPDATE urls SET utm=$2 WHERE id=$1"
+	setPreviewQuery    = "UPDATE urls SET preview=$2 WHERE id=$1"
+	setDisabledQuery   = "UPDATE urls SET disabled=$2 WHERE id=$1"
+	getClickLimitQuery = "SELECT click_limit FROM urls WHERE id=$1"
+	countByUserQuery   = "SELECT COUNT(*) FROM urls WHERE user_id=$1"
+	countURLsQuery     = "SELECT COUNT(*) FROM urls"
+	countUsersQuery    = "SELECT COUNT(DISTINCT user_id) FROM urls"
+	getLinksQuery      = "SELECT " + linkColumns + " FROM urls " +
+		"WHERE id COLLATE \"C\" > $1 " +
+		"ORDER BY id COLLATE \"C\" " +
+		"LIMIT $2"
+
+	//urlHostExpr хост адреса назначения в нижнем регистре, как его
+	//возвращает url.URL.Hostname для адресов без IPv6
+	urlHostExpr = "lower(substring(expand_url from '^[^:/?#]+://(?:[^/?#@]*@)?([^/?#:]*)'))"
+	//inDomainCondition повторяет common.InDomain, параметр — домен
+	inDomainCondition = "(" + urlHostExpr + " = $%[1]d OR " +
+		"right(" + urlHostExpr + ", length($%[1]d) + 1) = ('.' || $%[1]d))"
 )
 
+//upgradeQueries приводят к текущему виду таблицы, созданные прежними версиями.
+//Ссылки с параметрами получают случайный ID, поэтому один URL может
+//встречаться в таблице несколько раз.
+var upgradeQueries = []string{
+	//MAX_URL_LENGTH по умолчанию больше прежних 255 символов
+	"ALTER TABLE urls ALTER COLUMN expand_url TYPE text",
+	"ALTER TABLE urls ADD COLUMN IF NOT EXISTS password_hash varchar(255) NOT NULL DEFAULT ''",
+	"ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_expand_url_key",
+	"ALTER TABLE urls ADD COLUMN IF NOT EXISTS click_limit integer NOT NULL DEFAULT 0",
+	"ALTER TABLE urls ADD COLUMN IF NOT EXISTS clicks_left integer NOT NULL DEFAULT 0",
+	"ALTER TABLE urls ADD COLUMN IF NOT EXISTS rules jsonb",
+	"ALTER TABLE urls ADD COLUMN IF NOT EXISTS variants jsonb",
+	"ALTER TABLE urls ADD COLUMN IF NOT EXISTS utm jsonb",
+	"ALTER TABLE urls ADD COLUMN IF NOT EXISTS preview jsonb",
+	"ALTER TABLE urls ADD COLUMN IF NOT EXISTS workspace_id varchar(64)",
+	"CREATE INDEX IF NOT EXISTS urls_workspace_id_idx ON urls (workspace_id)",
+	"ALTER TABLE urls ADD COLUMN IF NOT EXISTS disabled jsonb",
+	"CREATE INDEX IF NOT EXISTS audit_log_link_id_idx ON audit_log (link_id, id)",
+	"CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id, id)",
+}
+
 type dbStorage struct {
 	dbConnection *sql.DB
+	pool         *DBPool
 }
 
-func NewDBStorage(dbAddress string) (*dbStorage, error) {
-	connection, err := connect(dbAddress)
+func NewDBStorage(pool *DBPool) (*dbStorage, error) {
+	for _, query := range append([]string{initQuery, initVariantClicksQuery, initUserSettingsQuery,
+		initAPIKeysQuery, initAccountsQuery, initWorkspacesQuery, initWorkspaceMembersQuery, initAuditQuery},
+		upgradeQueries...) {
+		if _, err := pool.Primary.Exec(query); err != nil {
+			return nil, err
+		}
+	}
+	return &dbStorage{
+		dbConnection: pool.Primary,
+		pool:         pool,
+	}, nil
+}
+
+func (d *dbStorage) Insert(ctx context.Context, urlID, expandURL, userID string) error {
+	return d.InsertLink(ctx, common.Link{ID: urlID, ExpandURL: expandURL, UserID: userID})
+}
+
+func (d *dbStorage) InsertLink(ctx context.Context, link common.Link) error {
+	args, err := linkArgs(link)
+	if err != nil {
+		return err
+	}
+	res, err := d.dbConnection.ExecContext(ctx, insertLinkQuery, args...)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("URL %s: %w", link.ExpandURL, ErrAlreadyExists)
+	}
+	return nil
+}
+
+func (d *dbStorage) GetLink(ctx context.Context, id string) (common.Link, error) {
+	var l common.Link
+	err := d.pool.queryReplicaFallback(ctx, func(db *sql.DB) error {
+		err := scanLink(db.QueryRowContext(ctx, getLinkQuery, dbKey(id)), &l)
+		if err != nil {
+			return err
+		}
+		return loadVariantClicks(ctx, db, &l)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.Link{}, fmt.Errorf("short URL %s: %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return common.Link{}, err
+	}
+	return l, nil
+}
+
+//UseClick уменьшает счётчик на основной базе. Запрос не меняет ссылки
+//без ограничения, поэтому для них ограничение проверяется отдельно.
+func (d *dbStorage) UseClick(ctx context.Context, id string) error {
+	res, err := d.dbConnection.ExecContext(ctx, useClickQuery, dbKey(id))
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 1 {
+		return nil
+	}
+	var clickLimit int
+	err = d.dbConnection.QueryRowContext(ctx, getClickLimitQuery, dbKey(id)).Scan(&clickLimit)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("short URL %s: %w", id, ErrNotFound)
+	}
+	if err != nil || clickLimit == 0 {
+		return err
+	}
+	return fmt.Errorf("short URL %s: %w", id, ErrNoClicksLeft)
+}
+
+func (d *dbStorage) SetRules(ctx context.Context, id string, rules []common.RedirectRule) error {
+	value, err := nullJSON(rules, len(rules) == 0)
+	if err != nil {
+		return err
+	}
+	return d.updateLink(ctx, setRulesQuery, id, value)
+}
+
+func (d *dbStorage) SetUTM(ctx context.Context, id string, utm common.UTM) error {
+	value, err := nullJSON(utm, utm.IsEmpty())
+	if err != nil {
+		return err
+	}
+	return d.updateLink(ctx, setUTMQuery, id, value)
+}
+
+func (d *dbStorage) SetPreview(ctx context.Context, id string, preview common.Preview) error {
+	value, err := nullJSON(preview, preview.IsEmpty())
+	if err != nil {
+		return err
+	}
+	return d.updateLink(ctx, setPreviewQuery, id, value)
+}
+
+//updateLink выполняет запрос, меняющий одну колонку ссылки id
+func (d *dbStorage) updateLink(ctx context.Context, query, id string, value interface{}) error {
+	res, err := d.dbConnection.ExecContext(ctx, query, dbKey(id), value)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("short URL %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (d *dbStorage) GetUserUTM(ctx context.Context, userID string) (common.UTM, error) {
+	var value sql.NullString
+	err := d.pool.queryReplicaFallback(ctx, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, getUserUTMQuery, userID).Scan(&value)
+	})
+	var utm common.UTM
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !value.Valid {
+		return utm, nil
+	}
+	if err != nil {
+		return utm, err
+	}
+	err = json.Unmarshal([]byte(value.String), &utm)
+	return utm, err
+}
+
+func (d *dbStorage) SetUserUTM(ctx context.Context, userID string, utm common.UTM) error {
+	value, err := nullJSON(utm, utm.IsEmpty())
+	if err != nil {
+		return err
+	}
+	_, err = d.dbConnection.ExecContext(ctx, setUserUTMQuery, userID, value)
+	return err
+}
+
+func (d *dbStorage) InsertAPIKey(ctx context.Context, key common.APIKey) error {
+	scopes, err := json.Marshal(key.Scopes)
+	if err != nil {
+		return err
+	}
+	res, err := d.dbConnection.ExecContext(ctx, insertAPIKeyQuery,
+		key.ID, key.UserID, key.Name, key.Prefix, key.Hash, string(scopes), key.CreatedAt)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("API key %s: %w", key.ID, ErrAlreadyExists)
+	}
+	return nil
+}
+
+//GetAPIKey читает ключ с основной базы: отозванный ключ не должен
+//продолжать работать из-за отставания реплики
+func (d *dbStorage) GetAPIKey(ctx context.Context, hash string) (common.APIKey, error) {
+	key, err := scanAPIKey(d.dbConnection.QueryRowContext(ctx, getAPIKeyQuery, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.APIKey{}, fmt.Errorf("API key: %w", ErrNotFound)
+	}
+	return key, err
+}
+
+func (d *dbStorage) GetAPIKeys(ctx context.Context, userID string) ([]common.APIKey, error) {
+	rows, err := d.dbConnection.QueryContext(ctx, getAPIKeysQuery, userID)
 	if err != nil {
 		return nil, err
 	}
-	return &dbStorage{dbConnection: connection}, nil
+	defer rows.Close()
+	result := make([]common.APIKey, 0)
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, key)
+	}
+	return result, rows.Err()
+}
+
+func (d *dbStorage) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	res, err := d.dbConnection.ExecContext(ctx, deleteAPIKeyQuery, id, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("API key %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (d *dbStorage) InsertAccount(ctx context.Context, account common.Account) error {
+	res, err := d.dbConnection.ExecContext(ctx, insertAccountQuery,
+		account.ID, account.Email, account.PasswordHash, account.CreatedAt)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("account %s: %w", account.Email, ErrAlreadyExists)
+	}
+	return nil
+}
+
+func (d *dbStorage) GetAccount(ctx context.Context, id string) (common.Account, error) {
+	return d.getAccount(ctx, getAccountQuery, id)
+}
+
+func (d *dbStorage) GetAccountByEmail(ctx context.Context, email string) (common.Account, error) {
+	return d.getAccount(ctx, getAccountByEmailQuery, email)
+}
+
+//getAccount читает учётную запись запросом query по email или ID
+func (d *dbStorage) getAccount(ctx context.Context, query, arg string) (common.Account, error) {
+	var a common.Account
+	err := d.dbConnection.QueryRowContext(ctx, query, arg).
+		Scan(&a.ID, &a.Email, &a.PasswordHash, &a.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.Account{}, fmt.Errorf("account %s: %w", arg, ErrNotFound)
+	}
+	return a, err
+}
+
+func (d *dbStorage) ReassignLinks(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	res, err := d.dbConnection.ExecContext(ctx, reassignLinksQuery, fromUserID, toUserID)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := res.RowsAffected()
+	return int(rows), err
+}
+
+func (d *dbStorage) InsertWorkspace(ctx context.Context, workspace common.Workspace) error {
+	res, err := d.dbConnection.ExecContext(ctx, insertWorkspaceQuery,
+		workspace.ID, workspace.Name, workspace.CreatedAt)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows != 1 {
+		return fmt.Errorf("workspace %s: %w", workspace.ID, ErrAlreadyExists)
+	}
+	return nil
+}
+
+func (d *dbStorage) GetWorkspace(ctx context.Context, id string) (common.Workspace, error) {
+	var w common.Workspace
+	err := d.dbConnection.QueryRowContext(ctx, getWorkspaceQuery, id).
+		Scan(&w.ID, &w.Name, &w.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.Workspace{}, fmt.Errorf("workspace %s: %w", id, ErrNotFound)
+	}
+	return w, err
+}
+
+func (d *dbStorage) SetMember(ctx context.Context, member common.Member) error {
+	_, err := d.dbConnection.ExecContext(ctx, setMemberQuery,
+		member.WorkspaceID, member.UserID, string(member.Role))
+	return err
+}
+
+//GetMember читает основную базу: изменение роли должно действовать сразу
+func (d *dbStorage) GetMember(ctx context.Context, workspaceID, userID string) (common.Member, error) {
+	var m common.Member
+	err := d.dbConnection.QueryRowContext(ctx, getMemberQuery, workspaceID, userID).
+		Scan(&m.WorkspaceID, &m.UserID, &m.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.Member{}, fmt.Errorf("workspace %s: %w", workspaceID, ErrNotFound)
+	}
+	return m, err
 }
 
-func connect(dbAddress string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", dbAddress)
+func (d *dbStorage) GetMembers(ctx context.Context, workspaceID string) ([]common.Member, error) {
+	return d.queryMembers(ctx, getMembersQuery, workspaceID)
+}
+
+func (d *dbStorage) GetMemberships(ctx context.Context, userID string) ([]common.Member, error) {
+	return d.queryMembers(ctx, getMembershipsQuery, userID)
+}
+
+func (d *dbStorage) queryMembers(ctx context.Context, query, arg string) ([]common.Member, error) {
+	rows, err := d.dbConnection.QueryContext(ctx, query, arg)
 	if err != nil {
 		return nil, err
 	}
-	_, err = db.Exec(initQuery)
+	defer rows.Close()
+
+	var members []common.Member
+	for rows.Next() {
+		var m common.Member
+		if err = rows.Scan(&m.WorkspaceID, &m.UserID, &m.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (d *dbStorage) DeleteMember(ctx context.Context, workspaceID, userID string) error {
+	res, err := d.dbConnection.ExecContext(ctx, deleteMemberQuery, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("workspace %s: %w", workspaceID, ErrNotFound)
+	}
+	return nil
+}
+
+func (d *dbStorage) AppendAudit(ctx context.Context, record common.AuditRecord) (int64, error) {
+	if record.ID != 0 {
+		_, err := d.dbConnection.ExecContext(ctx, appendAuditWithIDQuery, record.ID, record.Time,
+			record.ActorID, record.Action, record.LinkID, nullRawJSON(record.OldValue),
+			nullRawJSON(record.NewValue), record.RequestID)
+		return record.ID, err
+	}
+	var id int64
+	err := d.dbConnection.QueryRowContext(ctx, appendAuditQuery, record.Time, record.ActorID,
+		record.Action, record.LinkID, nullRawJSON(record.OldValue), nullRawJSON(record.NewValue),
+		record.RequestID).Scan(&id)
+	return id, err
+}
+
+func nullRawJSON(v json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(v), Valid: len(v) > 0}
+}
+
+//GetAudit собирает условия выборки из заданных полей фильтра
+func (d *dbStorage) GetAudit(ctx context.Context, filter common.AuditFilter) ([]common.AuditRecord, error) {
+	var conditions []string
+	var args []interface{}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.LinkID != "" {
+		where("link_id=$%d", filter.LinkID)
+	}
+	if filter.ActorID != "" {
+		where("actor_id=$%d", filter.ActorID)
+	}
+	if filter.Action != "" {
+		where("action=$%d", filter.Action)
+	}
+	if !filter.Since.IsZero() {
+		where("time>=$%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		where("time<$%d", filter.Until)
+	}
+	if filter.BeforeID != 0 {
+		where("id<$%d", filter.BeforeID)
+	}
+	query := "SELECT " + auditColumns + " FROM audit_log"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	rows, err := d.dbConnection.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
-	return db, nil
+	defer rows.Close()
+
+	records := make([]common.AuditRecord, 0)
+	for rows.Next() {
+		var r common.AuditRecord
+		var oldValue, newValue sql.NullString
+		err = rows.Scan(&r.ID, &r.Time, &r.ActorID, &r.Action, &r.LinkID, &oldValue, &newValue, &r.RequestID)
+		if err != nil {
+			return nil, err
+		}
+		if oldValue.Valid {
+			r.OldValue = json.RawMessage(oldValue.String)
+		}
+		if newValue.Valid {
+			r.NewValue = json.RawMessage(newValue.String)
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
 }
 
-func (d *dbStorage) Insert(ctx context.Context, urlID, expandURL, userID string) error {
-	res, err := d.dbConnection.
-		ExecContext(ctx, insertURLQueryWithConstraint, urlID, expandURL, userID)
+func scanAPIKey(row rowScanner) (common.APIKey, error) {
+	var key common.APIKey
+	var scopes sql.NullString
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.Hash, &scopes, &key.CreatedAt)
+	if err != nil {
+		return common.APIKey{}, err
+	}
+	if scopes.Valid {
+		err = json.Unmarshal([]byte(scopes.String), &key.Scopes)
+	}
+	return key, err
+}
+
+func (d *dbStorage) CountVariantClick(ctx context.Context, id, variant string) error {
+	res, err := d.dbConnection.ExecContext(ctx, countVariantClickQuery, dbKey(id), variant)
 	if err != nil {
 		return err
 	}
@@ -61,28 +629,170 @@ func (d *dbStorage) Insert(ctx context.Context, urlID, expandURL, userID string)
 		return err
 	}
 	if rows != 1 {
-		return fmt.Errorf("URL %s already exists", expandURL)
+		return fmt.Errorf("variant %s of short URL %s: %w", variant, id, ErrNotFound)
 	}
 	return nil
 }
 
+//loadVariantClicks заполняет счётчики переходов вариантов ссылки
+func loadVariantClicks(ctx context.Context, db *sql.DB, l *common.Link) error {
+	if len(l.Variants) == 0 {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx, getVariantClicksQuery, dbKey(l.ID))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	clicks := make(map[string]int64, len(l.Variants))
+	for rows.Next() {
+		var name string
+		var n int64
+		if err = rows.Scan(&name, &n); err != nil {
+			return err
+		}
+		clicks[name] = n
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for i := range l.Variants {
+		l.Variants[i].Clicks = clicks[l.Variants[i].Name]
+	}
+	return nil
+}
+
+//nullJSON возвращает значение для колонки jsonb, NULL если оно пустое
+func nullJSON(v interface{}, isEmpty bool) (sql.NullString, error) {
+	if isEmpty {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+//linkArgs параметры insertLinkQuery. Счётчики вариантов хранятся
+//в variant_clicks, в колонку variants они не попадают.
+func linkArgs(l common.Link) ([]interface{}, error) {
+	rules, err := nullJSON(l.Rules, len(l.Rules) == 0)
+	if err != nil {
+		return nil, err
+	}
+	variants := make([]common.Variant, len(l.Variants))
+	for i, v := range l.Variants {
+		v.Clicks = 0
+		variants[i] = v
+	}
+	variantsJSON, err := nullJSON(variants, len(variants) == 0)
+	if err != nil {
+		return nil, err
+	}
+	utm, err := nullJSON(l.UTM, l.UTM.IsEmpty())
+	if err != nil {
+		return nil, err
+	}
+	preview, err := nullJSON(l.Preview, l.Preview.IsEmpty())
+	if err != nil {
+		return nil, err
+	}
+	disabled, err := nullJSON(l.Disabled, l.Disabled == nil)
+	if err != nil {
+		return nil, err
+	}
+	workspaceID := sql.NullString{String: l.WorkspaceID, Valid: l.WorkspaceID != ""}
+	return []interface{}{dbKey(l.ID), l.ExpandURL, l.UserID,
+		l.PasswordHash, l.ClickLimit, l.ClicksLeft, rules, variantsJSON, utm, preview, workspaceID, disabled}, nil
+}
+
+//rowScanner общий интерфейс *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+//scanLink читает строку таблицы urls в формате getLinkQuery
+func scanLink(row rowScanner, l *common.Link) error {
+	var userID, rules, variants, utm, preview, workspaceID, disabled sql.NullString
+	if err := row.Scan(&l.ID, &l.ExpandURL, &userID,
+		&l.PasswordHash, &l.ClickLimit, &l.ClicksLeft, &rules, &variants, &utm, &preview,
+		&workspaceID, &disabled); err != nil {
+		return err
+	}
+	l.ID = strings.TrimPrefix(l.ID, "/")
+	l.UserID = userID.String
+	l.WorkspaceID = workspaceID.String
+	if rules.Valid {
+		if err := json.Unmarshal([]byte(rules.String), &l.Rules); err != nil {
+			return err
+		}
+	}
+	if variants.Valid {
+		if err := json.Unmarshal([]byte(variants.String), &l.Variants); err != nil {
+			return err
+		}
+	}
+	if utm.Valid {
+		if err := json.Unmarshal([]byte(utm.String), &l.UTM); err != nil {
+			return err
+		}
+	}
+	if preview.Valid {
+		if err := json.Unmarshal([]byte(preview.String), &l.Preview); err != nil {
+			return err
+		}
+	}
+	if disabled.Valid {
+		l.Disabled = &common.LinkBlock{}
+		return json.Unmarshal([]byte(disabled.String), l.Disabled)
+	}
+	return nil
+}
+
+//ExistingIDs читает с основной базы: реплика может ещё не знать
+//о только что созданных ссылках
+func (d *dbStorage) ExistingIDs(ctx context.Context, ids []string) (map[string]bool, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = dbKey(id)
+	}
+	rows, err := d.pool.Primary.QueryContext(ctx, existingIDsQuery, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		existing[strings.TrimPrefix(key, "/")] = true
+	}
+	return existing, rows.Err()
+}
+
 func (d *dbStorage) InsertSome(ctx context.Context, expandURLwIDslice []common.PairURL, userID string) error {
-	tx, err := d.dbConnection.Begin()
+	tx, err := d.pool.Primary.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	stmt, err := tx.PrepareContext(ctx, insertURLQuery)
+	stmt, err := tx.PrepareContext(ctx, insertURLQueryWithConstraint)
 	if err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			log.Printf("update drivers: unable to rollback: %v", rbErr)
+		}
 		return err
 	}
 	defer stmt.Close()
 
 	for _, p := range expandURLwIDslice {
-		if _, err = stmt.Exec(p.ShortURL, p.ExpandURL, userID); err != nil {
-			if err = tx.Rollback(); err != nil {
-				log.Printf("update drivers: unable to rollback: %v", err)
-				return err
+		if _, err = stmt.ExecContext(ctx, dbKey(p.ShortURL), p.ExpandURL, userID); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Printf("update drivers: unable to rollback: %v", rbErr)
 			}
 			return err
 		}
@@ -96,21 +806,233 @@ func (d *dbStorage) InsertSome(ctx context.Context, expandURLwIDslice []common.P
 	return nil
 }
 
+//dbKey приводит ID к виду, в котором он хранится в таблице: с ведущим "/"
+func dbKey(id string) string {
+	return "/" + strings.TrimPrefix(id, "/")
+}
+
+//InsertLinks сохраняет ссылки вместе с владельцами, уже существующие пропускаются
+func (d *dbStorage) InsertLinks(ctx context.Context, links []common.Link) error {
+	tx, err := d.dbConnection.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, insertLinkQuery)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, l := range links {
+		args, err := linkArgs(l)
+		if err != nil {
+			return err
+		}
+		if _, err = stmt.ExecContext(ctx, args...); err != nil {
+			return err
+		}
+		for _, v := range l.Variants {
+			if v.Clicks == 0 {
+				continue
+			}
+			_, err = tx.ExecContext(ctx, insertVariantClicksQuery, dbKey(l.ID), v.Name, v.Clicks)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+//GetLinks возвращает до limit ссылок с ID больше afterID в порядке возрастания ID
+func (d *dbStorage) GetLinks(ctx context.Context, afterID string, limit int) ([]common.Link, error) {
+	after := ""
+	if afterID != "" {
+		after = dbKey(afterID)
+	}
+	rows, err := d.dbConnection.QueryContext(ctx, getLinksQuery, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]common.Link, 0, limit)
+	for rows.Next() {
+		var l common.Link
+		if err = scanLink(rows, &l); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range links {
+		if err = loadVariantClicks(ctx, d.dbConnection, &links[i]); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+//SearchLinks собирает условия выборки из заданных полей фильтра
+func (d *dbStorage) SearchLinks(ctx context.Context, filter common.LinkFilter) ([]common.Link, error) {
+	after := ""
+	if filter.AfterID != "" {
+		after = dbKey(filter.AfterID)
+	}
+	args := []interface{}{after}
+	conditions := []string{"id COLLATE \"C\" > $1"}
+	where := func(condition string, arg interface{}) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Query != "" {
+		where("(strpos(lower(id), lower($%[1]d)) > 0 OR strpos(lower(expand_url), lower($%[1]d)) > 0)",
+			filter.Query)
+	}
+	if filter.UserID != "" {
+		where("user_id=$%d", filter.UserID)
+	}
+	if filter.WorkspaceID != "" {
+		where("workspace_id=$%d", filter.WorkspaceID)
+	}
+	if filter.Domain != "" {
+		where(inDomainCondition, filter.Domain)
+	}
+	if filter.Disabled {
+		conditions = append(conditions, "disabled IS NOT NULL")
+	}
+	args = append(args, filter.Limit)
+	query := "SELECT " + linkColumns + " FROM urls WHERE " + strings.Join(conditions, " AND ") +
+		fmt.Sprintf(" ORDER BY id COLLATE \"C\" LIMIT $%d", len(args))
+
+	rows, err := d.dbConnection.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]common.Link, 0)
+	for rows.Next() {
+		var l common.Link
+		if err = scanLink(rows, &l); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range links {
+		if err = loadVariantClicks(ctx, d.dbConnection, &links[i]); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+func (d *dbStorage) SetDisabled(ctx context.Context, id string, block *common.LinkBlock) error {
+	value, err := nullJSON(block, block == nil)
+	if err != nil {
+		return err
+	}
+	return d.updateLink(ctx, setDisabledQuery, id, value)
+}
+
+//DisableByDomain не трогает уже заблокированные ссылки, чтобы не
+//затереть прежнюю причину блокировки
+func (d *dbStorage) DisableByDomain(ctx context.Context, domain string, block common.LinkBlock) ([]string, error) {
+	value, err := json.Marshal(block)
+	if err != nil {
+		return nil, err
+	}
+	query := "UPDATE urls SET disabled=$2 WHERE disabled IS NULL AND " +
+		fmt.Sprintf(inDomainCondition, 1) + " RETURNING id"
+	rows, err := d.dbConnection.QueryContext(ctx, query, domain, string(value))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]string, 0)
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, strings.TrimPrefix(id, "/"))
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+//CountByUser читает основную базу: отставание реплики позволило бы
+//превысить квоту серией быстрых запросов
+func (d *dbStorage) CountByUser(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := d.dbConnection.QueryRowContext(ctx, countByUserQuery, userID).Scan(&count)
+	return count, err
+}
+
+//CountURLs допускает отставание реплики: счётчик нужен только для статистики
+func (d *dbStorage) CountURLs(ctx context.Context) (int, error) {
+	var count int
+	err := d.pool.queryReplicaFallback(ctx, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, countURLsQuery).Scan(&count)
+	})
+	return count, err
+}
+
+func (d *dbStorage) CountUsers(ctx context.Context) (int, error) {
+	var count int
+	err := d.pool.queryReplicaFallback(ctx, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, countUsersQuery).Scan(&count)
+	})
+	return count, err
+}
+
+func (d *dbStorage) HealthCheck(ctx context.Context) error {
+	return d.dbConnection.PingContext(ctx)
+}
+
 func (d *dbStorage) LookUp(ctx context.Context, urlID string) (string, error) {
 	var expandURL string
-	err := d.dbConnection.
-		QueryRowContext(ctx, getExpandURLQuery, urlID).
-		Scan(&expandURL)
+	err := d.pool.queryReplicaFallback(ctx, func(db *sql.DB) error {
+		return db.QueryRowContext(ctx, getExpandURLQuery, urlID).
+			Scan(&expandURL)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("short URL %s: %w", urlID, ErrNotFound)
+	}
 	if err != nil {
 		return "", err
 	}
 	return expandURL, nil
 }
 
+//GetPairsByID читает с основной базы: по неполному списку с отстающей
+//реплики нельзя понять, что он устарел, а пользователь ждёт увидеть
+//только что сокращённые ссылки
 func (d *dbStorage) GetPairsByID(ctx context.Context, userID string) ([]common.PairURL, error) {
+	return queryPairs(ctx, d.pool.Primary, getAllURLQuery, userID)
+}
+
+//GetPairsByWorkspace читает с основной базы по той же причине, что и GetPairsByID
+func (d *dbStorage) GetPairsByWorkspace(ctx context.Context, workspaceID string) ([]common.PairURL, error) {
+	return queryPairs(ctx, d.pool.Primary, getWorkspaceURLQuery, workspaceID)
+}
+
+//queryPairs читает пары коротких и исходных адресов запросом query с одним аргументом
+func queryPairs(ctx context.Context, db *sql.DB, query, arg string) ([]common.PairURL, error) {
 	pairs := make([]common.PairURL, 0)
 
-	rows, err := d.dbConnection.QueryContext(ctx, getAllURLQuery, userID)
+	rows, err := db.QueryContext(ctx, query, arg)
 	if err != nil {
 		return nil, err
 	}
@@ -3,61 +3,165 @@ package storages
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"os"
 	"strings"
 
 	"github.com/sandor-clegane/urlshortener/internal/common"
 )
 
+//FileStorage хранит ссылки в памяти и дописывает каждое изменение в файл.
+//При запуске файл перечитывается, более поздняя запись о ссылке
+//заменяет предыдущую.
 type FileStorage struct {
-	enc *json.Encoder
+	file *os.File
+	enc  *json.Encoder
 	*InMemoryStorage
 }
 
 type record struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	UserID string `json:"user_id,omitempty"`
+
+	PasswordHash string `json:"password_hash,omitempty"`
+	ClickLimit   int    `json:"click_limit,omitempty"`
+	ClicksLeft   int    `json:"clicks_left,omitempty"`
+
+	Rules    []common.RedirectRule `json:"rules,omitempty"`
+	Variants []common.Variant      `json:"variants,omitempty"`
+	UTM      *common.UTM           `json:"utm,omitempty"`
+	Preview  *common.Preview       `json:"preview,omitempty"`
+	Disabled *common.LinkBlock     `json:"disabled,omitempty"`
+
+	//UserUTM задан у записей с метками пользователя по умолчанию,
+	//остальные поля таких записей, кроме UserID, пустые
+	UserUTM *common.UTM `json:"user_utm,omitempty"`
+
+	//APIKey задан у записей с API-ключами. Отзыв ключа записывается
+	//отдельной записью с Revoked.
+	APIKey     *common.APIKey `json:"api_key,omitempty"`
+	APIKeyHash string         `json:"api_key_hash,omitempty"`
+	Revoked    bool           `json:"revoked,omitempty"`
+
+	//Account задан у записей с учётными записями, хеш пароля хранится в PasswordHash
+	Account *common.Account `json:"account,omitempty"`
+
+	WorkspaceID string            `json:"workspace_id,omitempty"`
+	Workspace   *common.Workspace `json:"workspace,omitempty"`
+	//Member задан у записей с участниками рабочих пространств. Исключение
+	//участника записывается отдельной записью с Revoked.
+	Member *common.Member `json:"member,omitempty"`
+
+	Audit *common.AuditRecord `json:"audit,omitempty"`
+
+	//Click задан у записей о переходе по ссылке Key, Variant — вариант
+	//A/B-теста, пустой у перехода по ссылке с ограничением
+	Click   bool   `json:"click,omitempty"`
+	Variant string `json:"variant,omitempty"`
 }
 
-func (fs *FileStorage) Insert(_ context.Context, key, value, userID string) error {
-	trimmedKey := strings.TrimPrefix(key, "/")
-	r := record{Key: key, Value: value}
+func newRecord(l common.Link) record {
+	var utm *common.UTM
+	if !l.UTM.IsEmpty() {
+		utm = &l.UTM
+	}
+	var preview *common.Preview
+	if !l.Preview.IsEmpty() {
+		preview = &l.Preview
+	}
+	return record{
+		Key:          l.ID,
+		Value:        l.ExpandURL,
+		UserID:       l.UserID,
+		PasswordHash: l.PasswordHash,
+		ClickLimit:   l.ClickLimit,
+		ClicksLeft:   l.ClicksLeft,
+		Rules:        l.Rules,
+		Variants:     l.Variants,
+		UTM:          utm,
+		Preview:      preview,
+		WorkspaceID:  l.WorkspaceID,
+		Disabled:     l.Disabled,
+	}
+}
 
-	fs.lock.Lock()
-	defer fs.lock.Unlock()
-	_, isExists := fs.storage[trimmedKey]
-	if isExists {
-		return fmt.Errorf("key %s already exists", key)
+func (r record) link() common.Link {
+	var utm common.UTM
+	if r.UTM != nil {
+		utm = *r.UTM
 	}
-	err := fs.enc.Encode(&r)
-	if err != nil {
-		return err
+	var preview common.Preview
+	if r.Preview != nil {
+		preview = *r.Preview
 	}
-	fs.storage[trimmedKey] = value
-	fs.userToKeys[userID] = append(fs.userToKeys[userID], trimmedKey)
+	return common.Link{
+		ID:        strings.TrimPrefix(r.Key, "/"),
+		ExpandURL: r.Value,
+		UserID:    r.UserID,
 
-	return nil
+		PasswordHash: r.PasswordHash,
+		ClickLimit:   r.ClickLimit,
+		ClicksLeft:   r.ClicksLeft,
+		Rules:        r.Rules,
+		Variants:     r.Variants,
+		UTM:          utm,
+		Preview:      preview,
+		WorkspaceID:  r.WorkspaceID,
+		Disabled:     r.Disabled,
+	}
 }
 
-func (fs *FileStorage) InsertSome(_ context.Context, expandURLwIDslice []common.PairURL, userID string) error {
-	var r record
-
-	fs.lock.Lock()
-	defer fs.lock.Unlock()
-	for _, p := range expandURLwIDslice {
-		trimmedKey := strings.TrimPrefix(p.ShortURL, "/")
-		r = record{Key: trimmedKey, Value: p.ExpandURL}
-		fs.storage[trimmedKey] = p.ExpandURL
-		err := fs.enc.Encode(&r)
-		if err != nil {
+func (fs *FileStorage) writeRecords(links ...common.Link) error {
+	for _, l := range links {
+		r := newRecord(l)
+		if err := fs.enc.Encode(&r); err != nil {
 			return err
 		}
-		fs.userToKeys[userID] = append(fs.userToKeys[userID], trimmedKey)
 	}
 	return nil
 }
 
+func (fs *FileStorage) writeUserUTM(userID string, utm common.UTM) error {
+	return fs.enc.Encode(&record{UserID: userID, UserUTM: &utm})
+}
+
+func (fs *FileStorage) writeAPIKey(key common.APIKey, revoked bool) error {
+	return fs.enc.Encode(&record{UserID: key.UserID, APIKey: &key, APIKeyHash: key.Hash, Revoked: revoked})
+}
+
+func (fs *FileStorage) writeAccount(account common.Account) error {
+	return fs.enc.Encode(&record{Account: &account, PasswordHash: account.PasswordHash})
+}
+
+func (fs *FileStorage) writeWorkspace(workspace common.Workspace) error {
+	return fs.enc.Encode(&record{Workspace: &workspace})
+}
+
+func (fs *FileStorage) writeMember(member common.Member, removed bool) error {
+	return fs.enc.Encode(&record{Member: &member, Revoked: removed})
+}
+
+func (fs *FileStorage) writeAudit(entry common.AuditRecord) error {
+	return fs.enc.Encode(&record{Audit: &entry})
+}
+
+func (fs *FileStorage) writeClick(id, variant string) error {
+	return fs.enc.Encode(&record{Key: id, Click: true, Variant: variant})
+}
+
+//HealthCheck проверяет, что файл хранилища по-прежнему открыт и доступен
+func (fs *FileStorage) HealthCheck(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := fs.file.Stat()
+	return err
+}
+
+func (fs *FileStorage) Close() error {
+	return fs.file.Close()
+}
+
 func NewFileStorage(fileName string) (*FileStorage, error) {
 	file, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0755)
 	if err != nil {
@@ -70,6 +174,7 @@ func NewFileStorage(fileName string) (*FileStorage, error) {
 	}
 	fs := &FileStorage{
 		InMemoryStorage: ims,
+		file:            file,
 		enc:             json.NewEncoder(file),
 	}
 
@@ -77,12 +182,44 @@ func NewFileStorage(fileName string) (*FileStorage, error) {
 	var r record
 
 	for dec.More() {
+		r = record{}
 		err = dec.Decode(&r)
 		if err != nil {
 			return nil, err
 		}
-		fs.storage[r.Key] = r.Value
+		if r.UserUTM != nil {
+			err = fs.putUserUTM(r.UserID, *r.UserUTM)
+		} else if r.Account != nil {
+			account := *r.Account
+			account.PasswordHash = r.PasswordHash
+			err = fs.putAccount(account)
+		} else if r.Audit != nil {
+			err = fs.putAudit(*r.Audit)
+		} else if r.Click {
+			err = fs.putClick(strings.TrimPrefix(r.Key, "/"), r.Variant)
+		} else if r.Workspace != nil {
+			err = fs.putWorkspace(*r.Workspace)
+		} else if r.Member != nil {
+			err = fs.putMember(*r.Member, r.Revoked)
+		} else if r.APIKey != nil {
+			key := *r.APIKey
+			key.UserID, key.Hash = r.UserID, r.APIKeyHash
+			err = fs.putAPIKey(key, r.Revoked)
+		} else {
+			err = fs.put(r.link())
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
+	fs.persist = fs.writeRecords
+	fs.persistUserUTM = fs.writeUserUTM
+	fs.persistAPIKey = fs.writeAPIKey
+	fs.persistAccount = fs.writeAccount
+	fs.persistWorkspace = fs.writeWorkspace
+	fs.persistMember = fs.writeMember
+	fs.persistAudit = fs.writeAudit
+	fs.persistClick = fs.writeClick
 
 	return fs, nil
 }
@@ -3,6 +3,7 @@ package storages
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"sync"
 
@@ -10,12 +11,38 @@ import (
 )
 
 type InMemoryStorage struct {
-	storage    map[string]string
+	storage    map[string]common.Link
 	userToKeys map[string][]string
-	lock       sync.RWMutex
+	userUTM    map[string]common.UTM
+	//apiKeys API-ключи по хешу
+	apiKeys map[string]common.APIKey
+	//accounts учётные записи по ID, accountEmails их ID по email
+	accounts      map[string]common.Account
+	accountEmails map[string]string
+	//workspaces рабочие пространства по ID, members их участники
+	//по ID пространства и пользователя
+	workspaces      map[string]common.Workspace
+	members         map[string]map[string]common.Member
+	workspaceToKeys map[string][]string
+	//audit журнал аудита в порядке возрастания ID
+	audit []common.AuditRecord
+	lock  sync.RWMutex
+	//persist и остальные persist* вызываются под блокировкой перед
+	//изменением данных; используются FileStorage для записи изменений на диск
+	persist          func(links ...common.Link) error
+	persistUserUTM   func(userID string, utm common.UTM) error
+	persistAPIKey    func(key common.APIKey, revoked bool) error
+	persistAccount   func(account common.Account) error
+	persistWorkspace func(workspace common.Workspace) error
+	persistMember    func(member common.Member, removed bool) error
+	persistAudit     func(record common.AuditRecord) error
+	persistClick     func(id, variant string) error
 }
 
-func (s *InMemoryStorage) LookUp(_ context.Context, str string) (string, error) {
+func (s *InMemoryStorage) LookUp(ctx context.Context, str string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	trimmedStr := strings.TrimPrefix(str, "/")
 
 	s.lock.RLock()
@@ -23,52 +50,405 @@ func (s *InMemoryStorage) LookUp(_ context.Context, str string) (string, error)
 	res, ok := s.storage[trimmedStr]
 
 	if !ok {
-		return "", fmt.Errorf("no %s short URL in database", str)
+		return "", fmt.Errorf("short URL %s: %w", str, ErrNotFound)
+	}
+	return res.ExpandURL, nil
+}
+
+//put сохраняет ссылки, вызывающий должен удерживать s.lock. Ссылка
+//с другим UserID переходит к новому владельцу, поэтому передавать её
+//так можно только при явной смене владельца, как в ReassignLinks.
+func (s *InMemoryStorage) put(links ...common.Link) error {
+	if s.persist != nil {
+		if err := s.persist(links...); err != nil {
+			return err
+		}
+	}
+	for _, l := range links {
+		old, isExists := s.storage[l.ID]
+		if isExists && old.UserID != l.UserID {
+			s.removeUserKey(old.UserID, l.ID)
+		}
+		if !isExists || old.UserID != l.UserID {
+			s.userToKeys[l.UserID] = append(s.userToKeys[l.UserID], l.ID)
+		}
+		//рабочее пространство ссылки задаётся при создании и не меняется
+		if !isExists && l.WorkspaceID != "" {
+			s.workspaceToKeys[l.WorkspaceID] = append(s.workspaceToKeys[l.WorkspaceID], l.ID)
+		}
+		s.storage[l.ID] = l
 	}
-	return res, nil
+	return nil
 }
 
-func (s *InMemoryStorage) Insert(_ context.Context, key, value, userID string) error {
-	trimmedKey := strings.TrimPrefix(key, "/")
+//putEach сохраняет пачку ссылок по одной, проверяя контекст перед каждой:
+//запись в файл не отменяется, и отменённый запрос не должен дописывать
+//пачку до конца. Вызывающий должен удерживать s.lock.
+func (s *InMemoryStorage) putEach(ctx context.Context, links []common.Link) error {
+	for _, l := range links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := s.put(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *InMemoryStorage) removeUserKey(userID, key string) {
+	keys := s.userToKeys[userID]
+	for i, k := range keys {
+		if k == key {
+			keys = append(keys[:i], keys[i+1:]...)
+			break
+		}
+	}
+	if len(keys) == 0 {
+		delete(s.userToKeys, userID)
+		return
+	}
+	s.userToKeys[userID] = keys
+}
+
+func (s *InMemoryStorage) Insert(ctx context.Context, key, value, userID string) error {
+	return s.InsertLink(ctx, common.Link{ID: key, ExpandURL: value, UserID: userID})
+}
+
+func (s *InMemoryStorage) InsertLink(ctx context.Context, link common.Link) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	link.ID = strings.TrimPrefix(link.ID, "/")
 
 	s.lock.Lock()
 	defer s.lock.Unlock()
-	_, isExists := s.storage[trimmedKey]
+	_, isExists := s.storage[link.ID]
 	if isExists {
-		return fmt.Errorf("key %s already exists", key)
+		return fmt.Errorf("key %s: %w", link.ID, ErrAlreadyExists)
+	}
+	return s.put(link)
+}
+
+func (s *InMemoryStorage) GetLink(ctx context.Context, id string) (common.Link, error) {
+	if err := ctx.Err(); err != nil {
+		return common.Link{}, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	link, ok := s.storage[strings.TrimPrefix(id, "/")]
+	if !ok {
+		return common.Link{}, fmt.Errorf("short URL %s: %w", id, ErrNotFound)
+	}
+	return link, nil
+}
+
+func (s *InMemoryStorage) UseClick(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	link, ok := s.storage[strings.TrimPrefix(id, "/")]
+	if !ok {
+		return fmt.Errorf("short URL %s: %w", id, ErrNotFound)
+	}
+	if link.ClickLimit == 0 {
+		return nil
+	}
+	if link.ClicksLeft <= 0 {
+		return fmt.Errorf("short URL %s: %w", id, ErrNoClicksLeft)
+	}
+	return s.putClick(link.ID, "")
+}
+
+//putClick засчитывает переход по ссылке id: без варианта уменьшает число
+//оставшихся переходов, с вариантом увеличивает его счётчик. Переход
+//сохраняется отдельно от ссылки, чтобы FileStorage не переписывал её
+//целиком на каждый переход. Вызывающий должен удерживать s.lock.
+func (s *InMemoryStorage) putClick(id, variant string) error {
+	link, ok := s.storage[id]
+	if !ok {
+		return fmt.Errorf("short URL %s: %w", id, ErrNotFound)
+	}
+	if s.persistClick != nil {
+		if err := s.persistClick(id, variant); err != nil {
+			return err
+		}
 	}
-	s.storage[trimmedKey] = value
-	s.userToKeys[userID] = append(s.userToKeys[userID], trimmedKey)
+	if variant == "" {
+		link.ClicksLeft--
+		s.storage[id] = link
+		return nil
+	}
+	//ссылки, выданные читателям, не должны меняться
+	link.Variants = append([]common.Variant(nil), link.Variants...)
+	for i := range link.Variants {
+		if link.Variants[i].Name == variant {
+			link.Variants[i].Clicks++
+			s.storage[id] = link
+			return nil
+		}
+	}
+	return fmt.Errorf("variant %s of short URL %s: %w", variant, id, ErrNotFound)
+}
+
+func (s *InMemoryStorage) SetRules(ctx context.Context, id string, rules []common.RedirectRule) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	link, ok := s.storage[strings.TrimPrefix(id, "/")]
+	if !ok {
+		return fmt.Errorf("short URL %s: %w", id, ErrNotFound)
+	}
+	link.Rules = append([]common.RedirectRule(nil), rules...)
+	return s.put(link)
+}
+
+func (s *InMemoryStorage) SetUTM(ctx context.Context, id string, utm common.UTM) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	link, ok := s.storage[strings.TrimPrefix(id, "/")]
+	if !ok {
+		return fmt.Errorf("short URL %s: %w", id, ErrNotFound)
+	}
+	link.UTM = utm
+	return s.put(link)
+}
+
+func (s *InMemoryStorage) SetPreview(ctx context.Context, id string, preview common.Preview) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	link, ok := s.storage[strings.TrimPrefix(id, "/")]
+	if !ok {
+		return fmt.Errorf("short URL %s: %w", id, ErrNotFound)
+	}
+	link.Preview = preview
+	return s.put(link)
+}
+
+func (s *InMemoryStorage) GetUserUTM(ctx context.Context, userID string) (common.UTM, error) {
+	if err := ctx.Err(); err != nil {
+		return common.UTM{}, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.userUTM[userID], nil
+}
 
+func (s *InMemoryStorage) SetUserUTM(ctx context.Context, userID string, utm common.UTM) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.putUserUTM(userID, utm)
+}
+
+//putUserUTM сохраняет метки пользователя, вызывающий должен удерживать s.lock
+func (s *InMemoryStorage) putUserUTM(userID string, utm common.UTM) error {
+	if s.persistUserUTM != nil {
+		if err := s.persistUserUTM(userID, utm); err != nil {
+			return err
+		}
+	}
+	if utm.IsEmpty() {
+		delete(s.userUTM, userID)
+		return nil
+	}
+	s.userUTM[userID] = utm
 	return nil
 }
 
-func (s *InMemoryStorage) InsertSome(_ context.Context, expandURLwIDslice []common.PairURL, userID string) error {
+func (s *InMemoryStorage) CountVariantClick(ctx context.Context, id, variant string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	link, ok := s.storage[strings.TrimPrefix(id, "/")]
+	if !ok {
+		return fmt.Errorf("short URL %s: %w", id, ErrNotFound)
+	}
+	for _, v := range link.Variants {
+		if v.Name == variant {
+			return s.putClick(link.ID, variant)
+		}
+	}
+	return fmt.Errorf("variant %s of short URL %s: %w", variant, id, ErrNotFound)
+}
+
+func (s *InMemoryStorage) ExistingIDs(ctx context.Context, ids []string) (map[string]bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	existing := make(map[string]bool)
+	for _, id := range ids {
+		id = strings.TrimPrefix(id, "/")
+		if _, ok := s.storage[id]; ok {
+			existing[id] = true
+		}
+	}
+	return existing, nil
+}
+
+func (s *InMemoryStorage) InsertSome(ctx context.Context, expandURLwIDslice []common.PairURL, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	links := make([]common.Link, 0, len(expandURLwIDslice))
+	added := make(map[string]bool, len(expandURLwIDslice))
 	for _, p := range expandURLwIDslice {
-		trimmedKey := strings.TrimPrefix(p.ShortURL, "/")
-		s.storage[trimmedKey] = p.ExpandURL
-		s.userToKeys[userID] = append(s.userToKeys[userID], trimmedKey)
+		id := strings.TrimPrefix(p.ShortURL, "/")
+		//тот же URL, сокращённый другим пользователем, остаётся у него
+		//вместе со всеми параметрами
+		if _, isExists := s.storage[id]; isExists || added[id] {
+			continue
+		}
+		added[id] = true
+		links = append(links, common.Link{ID: id, ExpandURL: p.ExpandURL, UserID: userID})
 	}
+	return s.putEach(ctx, links)
+}
 
-	return nil
+//InsertLinks сохраняет ссылки вместе с владельцами, уже существующие пропускаются
+func (s *InMemoryStorage) InsertLinks(ctx context.Context, links []common.Link) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	newLinks := make([]common.Link, 0, len(links))
+	for _, l := range links {
+		l.ID = strings.TrimPrefix(l.ID, "/")
+		if _, isExists := s.storage[l.ID]; !isExists {
+			newLinks = append(newLinks, l)
+		}
+	}
+	return s.putEach(ctx, newLinks)
+}
+
+//GetLinks возвращает до limit ссылок с ID больше afterID в порядке возрастания ID
+func (s *InMemoryStorage) GetLinks(ctx context.Context, afterID string, limit int) ([]common.Link, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keys := make([]string, 0, len(s.storage))
+	for key := range s.storage {
+		if key > afterID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	result := make([]common.Link, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, s.storage[key])
+	}
+	return result, nil
+}
+
+func (s *InMemoryStorage) SearchLinks(ctx context.Context, filter common.LinkFilter) ([]common.Link, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keys := make([]string, 0)
+	for key, link := range s.storage {
+		if key > filter.AfterID && filter.Match(link) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > filter.Limit {
+		keys = keys[:filter.Limit]
+	}
+
+	result := make([]common.Link, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, s.storage[key])
+	}
+	return result, nil
+}
+
+func (s *InMemoryStorage) SetDisabled(ctx context.Context, id string, block *common.LinkBlock) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	link, ok := s.storage[strings.TrimPrefix(id, "/")]
+	if !ok {
+		return fmt.Errorf("short URL %s: %w", id, ErrNotFound)
+	}
+	link.Disabled = block
+	return s.put(link)
+}
+
+func (s *InMemoryStorage) DisableByDomain(ctx context.Context, domain string, block common.LinkBlock) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	links := make([]common.Link, 0)
+	for _, link := range s.storage {
+		if link.Disabled == nil && common.InDomain(link.ExpandURL, domain) {
+			b := block
+			link.Disabled = &b
+			links = append(links, link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ID < links[j].ID })
+	if err := s.put(links...); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(links))
+	for _, l := range links {
+		ids = append(ids, l.ID)
+	}
+	return ids, nil
 }
 
-func (s *InMemoryStorage) GetPairsByID(_ context.Context, userID string) ([]common.PairURL, error) {
+func (s *InMemoryStorage) GetPairsByID(ctx context.Context, userID string) ([]common.PairURL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	s.lock.RLock()
 	keys, ok := s.userToKeys[userID]
 	s.lock.RUnlock()
 
 	if !ok {
-		return nil, fmt.Errorf("user with ID %s did not shorten any URL", userID)
+		return nil, fmt.Errorf("user with ID %s did not shorten any URL: %w", userID, ErrNotFound)
 	}
 	result := make([]common.PairURL, 0, len(keys))
 
 	s.lock.RLock()
 	for _, key := range keys {
 		result = append(result, common.PairURL{
-			ExpandURL: s.storage[key],
+			ExpandURL: s.storage[key].ExpandURL,
 			ShortURL:  key,
 		})
 	}
@@ -77,9 +457,382 @@ func (s *InMemoryStorage) GetPairsByID(_ context.Context, userID string) ([]comm
 	return result, nil
 }
 
+func (s *InMemoryStorage) InsertAPIKey(ctx context.Context, key common.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.apiKeys[key.Hash]; ok {
+		return fmt.Errorf("API key %s: %w", key.ID, ErrAlreadyExists)
+	}
+	return s.putAPIKey(key, false)
+}
+
+func (s *InMemoryStorage) GetAPIKey(ctx context.Context, hash string) (common.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return common.APIKey{}, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	key, ok := s.apiKeys[hash]
+	if !ok {
+		return common.APIKey{}, fmt.Errorf("API key: %w", ErrNotFound)
+	}
+	return key, nil
+}
+
+func (s *InMemoryStorage) GetAPIKeys(ctx context.Context, userID string) ([]common.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([]common.APIKey, 0)
+	for _, key := range s.apiKeys {
+		if key.UserID == userID {
+			result = append(result, key)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+func (s *InMemoryStorage) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, key := range s.apiKeys {
+		if key.ID == id && key.UserID == userID {
+			return s.putAPIKey(key, true)
+		}
+	}
+	return fmt.Errorf("API key %s: %w", id, ErrNotFound)
+}
+
+//putAPIKey сохраняет или отзывает ключ, вызывающий должен удерживать s.lock
+func (s *InMemoryStorage) putAPIKey(key common.APIKey, revoked bool) error {
+	if s.persistAPIKey != nil {
+		if err := s.persistAPIKey(key, revoked); err != nil {
+			return err
+		}
+	}
+	if revoked {
+		delete(s.apiKeys, key.Hash)
+		return nil
+	}
+	s.apiKeys[key.Hash] = key
+	return nil
+}
+
+func (s *InMemoryStorage) InsertAccount(ctx context.Context, account common.Account) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.accountEmails[account.Email]; ok {
+		return fmt.Errorf("account %s: %w", account.Email, ErrAlreadyExists)
+	}
+	if _, ok := s.accounts[account.ID]; ok {
+		return fmt.Errorf("account %s: %w", account.ID, ErrAlreadyExists)
+	}
+	return s.putAccount(account)
+}
+
+//putAccount сохраняет учётную запись, вызывающий должен удерживать s.lock
+func (s *InMemoryStorage) putAccount(account common.Account) error {
+	if s.persistAccount != nil {
+		if err := s.persistAccount(account); err != nil {
+			return err
+		}
+	}
+	s.accounts[account.ID] = account
+	s.accountEmails[account.Email] = account.ID
+	return nil
+}
+
+func (s *InMemoryStorage) GetAccount(ctx context.Context, id string) (common.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return common.Account{}, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	account, ok := s.accounts[id]
+	if !ok {
+		return common.Account{}, fmt.Errorf("account %s: %w", id, ErrNotFound)
+	}
+	return account, nil
+}
+
+func (s *InMemoryStorage) GetAccountByEmail(ctx context.Context, email string) (common.Account, error) {
+	if err := ctx.Err(); err != nil {
+		return common.Account{}, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	id, ok := s.accountEmails[email]
+	if !ok {
+		return common.Account{}, fmt.Errorf("account %s: %w", email, ErrNotFound)
+	}
+	return s.accounts[id], nil
+}
+
+func (s *InMemoryStorage) ReassignLinks(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keys := s.userToKeys[fromUserID]
+	links := make([]common.Link, 0, len(keys))
+	for _, key := range keys {
+		link := s.storage[key]
+		link.UserID = toUserID
+		links = append(links, link)
+	}
+	if err := s.put(links...); err != nil {
+		return 0, err
+	}
+	return len(links), nil
+}
+
+func (s *InMemoryStorage) InsertWorkspace(ctx context.Context, workspace common.Workspace) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.workspaces[workspace.ID]; ok {
+		return fmt.Errorf("workspace %s: %w", workspace.ID, ErrAlreadyExists)
+	}
+	return s.putWorkspace(workspace)
+}
+
+//putWorkspace сохраняет рабочее пространство, вызывающий должен удерживать s.lock
+func (s *InMemoryStorage) putWorkspace(workspace common.Workspace) error {
+	if s.persistWorkspace != nil {
+		if err := s.persistWorkspace(workspace); err != nil {
+			return err
+		}
+	}
+	s.workspaces[workspace.ID] = workspace
+	return nil
+}
+
+func (s *InMemoryStorage) GetWorkspace(ctx context.Context, id string) (common.Workspace, error) {
+	if err := ctx.Err(); err != nil {
+		return common.Workspace{}, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	workspace, ok := s.workspaces[id]
+	if !ok {
+		return common.Workspace{}, fmt.Errorf("workspace %s: %w", id, ErrNotFound)
+	}
+	return workspace, nil
+}
+
+func (s *InMemoryStorage) SetMember(ctx context.Context, member common.Member) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.putMember(member, false)
+}
+
+//putMember сохраняет или удаляет участника, вызывающий должен удерживать s.lock
+func (s *InMemoryStorage) putMember(member common.Member, removed bool) error {
+	if s.persistMember != nil {
+		if err := s.persistMember(member, removed); err != nil {
+			return err
+		}
+	}
+	if removed {
+		delete(s.members[member.WorkspaceID], member.UserID)
+		if len(s.members[member.WorkspaceID]) == 0 {
+			delete(s.members, member.WorkspaceID)
+		}
+		return nil
+	}
+	if s.members[member.WorkspaceID] == nil {
+		s.members[member.WorkspaceID] = make(map[string]common.Member)
+	}
+	s.members[member.WorkspaceID][member.UserID] = member
+	return nil
+}
+
+func (s *InMemoryStorage) GetMember(ctx context.Context, workspaceID, userID string) (common.Member, error) {
+	if err := ctx.Err(); err != nil {
+		return common.Member{}, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	member, ok := s.members[workspaceID][userID]
+	if !ok {
+		return common.Member{}, fmt.Errorf("workspace %s: %w", workspaceID, ErrNotFound)
+	}
+	return member, nil
+}
+
+func (s *InMemoryStorage) GetMembers(ctx context.Context, workspaceID string) ([]common.Member, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	members := make([]common.Member, 0, len(s.members[workspaceID]))
+	for _, m := range s.members[workspaceID] {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].UserID < members[j].UserID
+	})
+	return members, nil
+}
+
+func (s *InMemoryStorage) GetMemberships(ctx context.Context, userID string) ([]common.Member, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var memberships []common.Member
+	for _, members := range s.members {
+		if m, ok := members[userID]; ok {
+			memberships = append(memberships, m)
+		}
+	}
+	sort.Slice(memberships, func(i, j int) bool {
+		return memberships[i].WorkspaceID < memberships[j].WorkspaceID
+	})
+	return memberships, nil
+}
+
+func (s *InMemoryStorage) DeleteMember(ctx context.Context, workspaceID, userID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	member, ok := s.members[workspaceID][userID]
+	if !ok {
+		return fmt.Errorf("workspace %s: %w", workspaceID, ErrNotFound)
+	}
+	return s.putMember(member, true)
+}
+
+func (s *InMemoryStorage) GetPairsByWorkspace(ctx context.Context, workspaceID string) ([]common.PairURL, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	keys, ok := s.workspaceToKeys[workspaceID]
+	if !ok {
+		return nil, fmt.Errorf("workspace %s has no URLs: %w", workspaceID, ErrNotFound)
+	}
+	result := make([]common.PairURL, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, common.PairURL{
+			ExpandURL: s.storage[key].ExpandURL,
+			ShortURL:  key,
+		})
+	}
+	return result, nil
+}
+
+func (s *InMemoryStorage) AppendAudit(ctx context.Context, record common.AuditRecord) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if record.ID == 0 {
+		record.ID = 1
+		if len(s.audit) > 0 {
+			record.ID = s.audit[len(s.audit)-1].ID + 1
+		}
+	}
+	return record.ID, s.putAudit(record)
+}
+
+//putAudit дописывает запись журнала, вызывающий должен удерживать s.lock
+func (s *InMemoryStorage) putAudit(record common.AuditRecord) error {
+	if s.persistAudit != nil {
+		if err := s.persistAudit(record); err != nil {
+			return err
+		}
+	}
+	s.audit = append(s.audit, record)
+	return nil
+}
+
+func (s *InMemoryStorage) GetAudit(ctx context.Context, filter common.AuditFilter) ([]common.AuditRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	records := make([]common.AuditRecord, 0)
+	for i := len(s.audit) - 1; i >= 0 && len(records) < filter.Limit; i-- {
+		if filter.Match(s.audit[i]) {
+			records = append(records, s.audit[i])
+		}
+	}
+	return records, nil
+}
+
+func (s *InMemoryStorage) CountByUser(ctx context.Context, userID string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.userToKeys[userID]), nil
+}
+
+func (s *InMemoryStorage) CountURLs(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.storage), nil
+}
+
+//CountUsers опирается на то, что removeUserKey удаляет пользователей
+//без ссылок из userToKeys
+func (s *InMemoryStorage) CountUsers(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.userToKeys), nil
+}
+
+func (s *InMemoryStorage) HealthCheck(ctx context.Context) error {
+	return ctx.Err()
+}
+
 func NewInMemoryStorage() (*InMemoryStorage, error) {
 	return &InMemoryStorage{
-		storage:    make(map[string]string),
+		storage:    make(map[string]common.Link),
 		userToKeys: make(map[string][]string),
+		userUTM:    make(map[string]common.UTM),
+		apiKeys:    make(map[string]common.APIKey),
+
+		accounts:      make(map[string]common.Account),
+		accountEmails: make(map[string]string),
+
+		workspaces:      make(map[string]common.Workspace),
+		members:         make(map[string]map[string]common.Member),
+		workspaceToKeys: make(map[string][]string),
 	}, nil
 }
@@ -0,0 +1,745 @@
+package storages
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sandor-clegane/urlshortener/internal/common"
+)
+
+var ErrMirrorQueueFull = errors.New("mirror queue is full")
+
+//ReplicatedStorage пишет в основное хранилище и асинхронно повторяет
+//записи во вторичных. Если основное хранилище недоступно, чтение
+//переключается на вторичные.
+type ReplicatedStorage struct {
+	primary Storage
+	mirrors []*Mirror
+
+	//lock защищает очереди зеркал от записи после закрытия
+	lock   sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+//Mirror вторичное хранилище со своей очередью записей
+type Mirror struct {
+	Name    string
+	storage Storage
+	queue   chan mirrorOp
+
+	retries    int
+	retryDelay time.Duration
+	maxLag     time.Duration
+
+	pending int64
+	dropped int64
+	failed  int64
+	//headEnqueued время постановки в очередь обрабатываемой записи (UnixNano)
+	headEnqueued int64
+}
+
+type mirrorOp struct {
+	apply    func(ctx context.Context, stg Storage) error
+	enqueued time.Time
+}
+
+//MirrorStatus состояние зеркала для мониторинга
+type MirrorStatus struct {
+	Name       string  `json:"name"`
+	Pending    int64   `json:"pending"`
+	LagSeconds float64 `json:"lag_seconds"`
+	Dropped    int64   `json:"dropped"`
+	Failed     int64   `json:"failed"`
+}
+
+type MirrorOptions struct {
+	QueueSize  int
+	Retries    int
+	RetryDelay time.Duration
+	MaxLag     time.Duration
+}
+
+//NewReplicatedStorage names задаёт имена вторичных хранилищ для логов и мониторинга
+func NewReplicatedStorage(primary Storage, secondaries []Storage, names []string,
+	opts MirrorOptions) *ReplicatedStorage {
+	rs := &ReplicatedStorage{primary: primary}
+	for i, stg := range secondaries {
+		m := &Mirror{
+			Name:       names[i],
+			storage:    stg,
+			queue:      make(chan mirrorOp, opts.QueueSize),
+			retries:    opts.Retries,
+			retryDelay: opts.RetryDelay,
+			maxLag:     opts.MaxLag,
+		}
+		rs.mirrors = append(rs.mirrors, m)
+		rs.wg.Add(1)
+		go func() {
+			defer rs.wg.Done()
+			m.run()
+		}()
+	}
+	return rs
+}
+
+func (m *Mirror) run() {
+	for op := range m.queue {
+		atomic.StoreInt64(&m.headEnqueued, op.enqueued.UnixNano())
+		m.apply(op)
+		atomic.StoreInt64(&m.headEnqueued, 0)
+		atomic.AddInt64(&m.pending, -1)
+	}
+}
+
+func (m *Mirror) apply(op mirrorOp) {
+	var err error
+	for attempt := 0; attempt <= m.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(m.retryDelay * time.Duration(attempt))
+		}
+		err = op.apply(context.Background(), m.storage)
+		//повтор уже применённой записи не считается ошибкой
+		if err == nil || errors.Is(err, ErrAlreadyExists) || errors.Is(err, ErrNoClicksLeft) {
+			return
+		}
+	}
+	atomic.AddInt64(&m.failed, 1)
+	log.Printf("mirror %s: unable to apply write after %d attempts: %v", m.Name, m.retries+1, err)
+}
+
+func (m *Mirror) enqueue(op mirrorOp) {
+	select {
+	case m.queue <- op:
+		atomic.AddInt64(&m.pending, 1)
+	default:
+		atomic.AddInt64(&m.dropped, 1)
+		log.Printf("mirror %s: %v, write dropped", m.Name, ErrMirrorQueueFull)
+	}
+}
+
+//Lag возвращает возраст самой старой ещё не применённой записи
+func (m *Mirror) Lag() time.Duration {
+	head := atomic.LoadInt64(&m.headEnqueued)
+	if head == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, head))
+}
+
+//Status возвращает метрики зеркала: размер очереди, отставание и потерянные записи
+func (m *Mirror) Status() interface{} {
+	return MirrorStatus{
+		Name:       m.Name,
+		Pending:    atomic.LoadInt64(&m.pending),
+		LagSeconds: m.Lag().Seconds(),
+		Dropped:    atomic.LoadInt64(&m.dropped),
+		Failed:     atomic.LoadInt64(&m.failed),
+	}
+}
+
+//HealthCheck проверяет вторичное хранилище и отставание зеркала
+func (m *Mirror) HealthCheck(ctx context.Context) error {
+	if err := m.storage.HealthCheck(ctx); err != nil {
+		return err
+	}
+	if lag := m.Lag(); m.maxLag > 0 && lag > m.maxLag {
+		return fmt.Errorf("mirror lags behind by %s (max %s)", lag, m.maxLag)
+	}
+	return nil
+}
+
+func (rs *ReplicatedStorage) Mirrors() []*Mirror {
+	return rs.mirrors
+}
+
+func (rs *ReplicatedStorage) mirror(apply func(ctx context.Context, stg Storage) error) {
+	rs.lock.RLock()
+	defer rs.lock.RUnlock()
+	if rs.closed {
+		return
+	}
+	op := mirrorOp{apply: apply, enqueued: time.Now()}
+	for _, m := range rs.mirrors {
+		m.enqueue(op)
+	}
+}
+
+//isFailover сообщает, стоит ли повторить чтение на вторичных хранилищах
+func isFailover(ctx context.Context, err error) bool {
+	return err != nil && !errors.Is(err, ErrNotFound) && ctx.Err() == nil
+}
+
+func (rs *ReplicatedStorage) LookUp(ctx context.Context, str string) (string, error) {
+	res, err := rs.primary.LookUp(ctx, str)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.LookUp(ctx, str)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return "", err
+}
+
+func (rs *ReplicatedStorage) GetPairsByID(ctx context.Context, userID string) ([]common.PairURL, error) {
+	res, err := rs.primary.GetPairsByID(ctx, userID)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetPairsByID(ctx, userID)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+func (rs *ReplicatedStorage) Insert(ctx context.Context, key, value, userID string) error {
+	err := rs.primary.Insert(ctx, key, value, userID)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.Insert(ctx, key, value, userID)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) InsertLink(ctx context.Context, link common.Link) error {
+	err := rs.primary.InsertLink(ctx, link)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.InsertLink(ctx, link)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) GetLink(ctx context.Context, id string) (common.Link, error) {
+	res, err := rs.primary.GetLink(ctx, id)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetLink(ctx, id)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return common.Link{}, err
+}
+
+func (rs *ReplicatedStorage) UseClick(ctx context.Context, id string) error {
+	err := rs.primary.UseClick(ctx, id)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.UseClick(ctx, id)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) SetRules(ctx context.Context, id string, rules []common.RedirectRule) error {
+	err := rs.primary.SetRules(ctx, id, rules)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.SetRules(ctx, id, rules)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) SetUTM(ctx context.Context, id string, utm common.UTM) error {
+	err := rs.primary.SetUTM(ctx, id, utm)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.SetUTM(ctx, id, utm)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) SetPreview(ctx context.Context, id string, preview common.Preview) error {
+	err := rs.primary.SetPreview(ctx, id, preview)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.SetPreview(ctx, id, preview)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) GetUserUTM(ctx context.Context, userID string) (common.UTM, error) {
+	res, err := rs.primary.GetUserUTM(ctx, userID)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetUserUTM(ctx, userID)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return common.UTM{}, err
+}
+
+func (rs *ReplicatedStorage) SetUserUTM(ctx context.Context, userID string, utm common.UTM) error {
+	err := rs.primary.SetUserUTM(ctx, userID, utm)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.SetUserUTM(ctx, userID, utm)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) CountVariantClick(ctx context.Context, id, variant string) error {
+	err := rs.primary.CountVariantClick(ctx, id, variant)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.CountVariantClick(ctx, id, variant)
+	})
+	return nil
+}
+
+//ExistingIDs не переходит на зеркала: по ответу решается, что записать
+//на основное хранилище, а без него запись всё равно не удастся
+func (rs *ReplicatedStorage) ExistingIDs(ctx context.Context, ids []string) (map[string]bool, error) {
+	return rs.primary.ExistingIDs(ctx, ids)
+}
+
+func (rs *ReplicatedStorage) InsertSome(ctx context.Context, expandURLwIDslice []common.PairURL, userID string) error {
+	err := rs.primary.InsertSome(ctx, expandURLwIDslice, userID)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.InsertSome(ctx, expandURLwIDslice, userID)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) InsertLinks(ctx context.Context, links []common.Link) error {
+	err := rs.primary.InsertLinks(ctx, links)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.InsertLinks(ctx, links)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) GetLinks(ctx context.Context, afterID string, limit int) ([]common.Link, error) {
+	res, err := rs.primary.GetLinks(ctx, afterID, limit)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetLinks(ctx, afterID, limit)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+func (rs *ReplicatedStorage) InsertAPIKey(ctx context.Context, key common.APIKey) error {
+	err := rs.primary.InsertAPIKey(ctx, key)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.InsertAPIKey(ctx, key)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) GetAPIKey(ctx context.Context, hash string) (common.APIKey, error) {
+	res, err := rs.primary.GetAPIKey(ctx, hash)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetAPIKey(ctx, hash)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return common.APIKey{}, err
+}
+
+func (rs *ReplicatedStorage) GetAPIKeys(ctx context.Context, userID string) ([]common.APIKey, error) {
+	res, err := rs.primary.GetAPIKeys(ctx, userID)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetAPIKeys(ctx, userID)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+func (rs *ReplicatedStorage) DeleteAPIKey(ctx context.Context, userID, id string) error {
+	err := rs.primary.DeleteAPIKey(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		//ключ мог не дойти до зеркала, отзывать там уже нечего
+		if err := stg.DeleteAPIKey(ctx, userID, id); !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) InsertAccount(ctx context.Context, account common.Account) error {
+	err := rs.primary.InsertAccount(ctx, account)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.InsertAccount(ctx, account)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) GetAccount(ctx context.Context, id string) (common.Account, error) {
+	res, err := rs.primary.GetAccount(ctx, id)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetAccount(ctx, id)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return common.Account{}, err
+}
+
+func (rs *ReplicatedStorage) GetAccountByEmail(ctx context.Context, email string) (common.Account, error) {
+	res, err := rs.primary.GetAccountByEmail(ctx, email)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetAccountByEmail(ctx, email)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return common.Account{}, err
+}
+
+func (rs *ReplicatedStorage) ReassignLinks(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	n, err := rs.primary.ReassignLinks(ctx, fromUserID, toUserID)
+	if err != nil {
+		return n, err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		_, err := stg.ReassignLinks(ctx, fromUserID, toUserID)
+		return err
+	})
+	return n, nil
+}
+
+func (rs *ReplicatedStorage) InsertWorkspace(ctx context.Context, workspace common.Workspace) error {
+	err := rs.primary.InsertWorkspace(ctx, workspace)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.InsertWorkspace(ctx, workspace)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) GetWorkspace(ctx context.Context, id string) (common.Workspace, error) {
+	res, err := rs.primary.GetWorkspace(ctx, id)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetWorkspace(ctx, id)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return common.Workspace{}, err
+}
+
+func (rs *ReplicatedStorage) SetMember(ctx context.Context, member common.Member) error {
+	err := rs.primary.SetMember(ctx, member)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.SetMember(ctx, member)
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) GetMember(ctx context.Context, workspaceID, userID string) (common.Member, error) {
+	res, err := rs.primary.GetMember(ctx, workspaceID, userID)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetMember(ctx, workspaceID, userID)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return common.Member{}, err
+}
+
+func (rs *ReplicatedStorage) GetMembers(ctx context.Context, workspaceID string) ([]common.Member, error) {
+	res, err := rs.primary.GetMembers(ctx, workspaceID)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetMembers(ctx, workspaceID)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+func (rs *ReplicatedStorage) GetMemberships(ctx context.Context, userID string) ([]common.Member, error) {
+	res, err := rs.primary.GetMemberships(ctx, userID)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetMemberships(ctx, userID)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+func (rs *ReplicatedStorage) DeleteMember(ctx context.Context, workspaceID, userID string) error {
+	err := rs.primary.DeleteMember(ctx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		//участник мог не дойти до зеркала, исключать там уже некого
+		if err := stg.DeleteMember(ctx, workspaceID, userID); !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+	return nil
+}
+
+func (rs *ReplicatedStorage) GetPairsByWorkspace(ctx context.Context, workspaceID string) ([]common.PairURL, error) {
+	res, err := rs.primary.GetPairsByWorkspace(ctx, workspaceID)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetPairsByWorkspace(ctx, workspaceID)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+//AppendAudit передаёт зеркалам ID, назначенный основным хранилищем,
+//чтобы при чтении с зеркала ID записей совпадали
+func (rs *ReplicatedStorage) AppendAudit(ctx context.Context, record common.AuditRecord) (int64, error) {
+	id, err := rs.primary.AppendAudit(ctx, record)
+	if err != nil {
+		return 0, err
+	}
+	record.ID = id
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		_, err := stg.AppendAudit(ctx, record)
+		return err
+	})
+	return id, nil
+}
+
+func (rs *ReplicatedStorage) GetAudit(ctx context.Context, filter common.AuditFilter) ([]common.AuditRecord, error) {
+	res, err := rs.primary.GetAudit(ctx, filter)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.GetAudit(ctx, filter)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+func (rs *ReplicatedStorage) SearchLinks(ctx context.Context, filter common.LinkFilter) ([]common.Link, error) {
+	res, err := rs.primary.SearchLinks(ctx, filter)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.SearchLinks(ctx, filter)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return nil, err
+}
+
+func (rs *ReplicatedStorage) SetDisabled(ctx context.Context, id string, block *common.LinkBlock) error {
+	err := rs.primary.SetDisabled(ctx, id, block)
+	if err != nil {
+		return err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		return stg.SetDisabled(ctx, id, block)
+	})
+	return nil
+}
+
+//DisableByDomain блокирует в зеркалах ровно те ссылки, что заблокированы
+//в основном хранилище
+func (rs *ReplicatedStorage) DisableByDomain(ctx context.Context, domain string,
+	block common.LinkBlock) ([]string, error) {
+	ids, err := rs.primary.DisableByDomain(ctx, domain, block)
+	if err != nil {
+		return nil, err
+	}
+	rs.mirror(func(ctx context.Context, stg Storage) error {
+		for _, id := range ids {
+			//ссылка могла не дойти до зеркала, которое отставало при её создании
+			if err := stg.SetDisabled(ctx, id, &block); err != nil && !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		}
+		return nil
+	})
+	return ids, nil
+}
+
+func (rs *ReplicatedStorage) CountByUser(ctx context.Context, userID string) (int, error) {
+	res, err := rs.primary.CountByUser(ctx, userID)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.CountByUser(ctx, userID)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return 0, err
+}
+
+func (rs *ReplicatedStorage) CountURLs(ctx context.Context) (int, error) {
+	res, err := rs.primary.CountURLs(ctx)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.CountURLs(ctx)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return 0, err
+}
+
+func (rs *ReplicatedStorage) CountUsers(ctx context.Context) (int, error) {
+	res, err := rs.primary.CountUsers(ctx)
+	if !isFailover(ctx, err) {
+		return res, err
+	}
+	for _, m := range rs.mirrors {
+		res, mErr := m.storage.CountUsers(ctx)
+		if mErr == nil {
+			log.Printf("primary storage unavailable, read from mirror %s: %v", m.Name, err)
+			return res, nil
+		}
+	}
+	return 0, err
+}
+
+//HealthCheck отражает состояние основного хранилища: без него запись невозможна
+func (rs *ReplicatedStorage) HealthCheck(ctx context.Context) error {
+	return rs.primary.HealthCheck(ctx)
+}
+
+//Close прекращает приём записей, дожидается применения уже поставленных
+//в очередь и закрывает хранилища
+func (rs *ReplicatedStorage) Close() error {
+	rs.lock.Lock()
+	if rs.closed {
+		rs.lock.Unlock()
+		return nil
+	}
+	rs.closed = true
+	for _, m := range rs.mirrors {
+		close(m.queue)
+	}
+	rs.lock.Unlock()
+
+	rs.wg.Wait()
+	for _, m := range rs.mirrors {
+		closeStorage(m.storage)
+	}
+	closeStorage(rs.primary)
+	return nil
+}
+
+func closeStorage(stg Storage) {
+	if c, ok := stg.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			log.Printf("unable to close storage: %v", err)
+		}
+	}
+}
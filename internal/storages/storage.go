@@ -2,30 +2,195 @@ package storages
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
 
 	"github.com/sandor-clegane/urlshortener/internal/common"
 	"github.com/sandor-clegane/urlshortener/internal/config"
 )
 
+var (
+	ErrNotFound      = errors.New("not found")
+	ErrAlreadyExists = errors.New("already exists")
+	ErrNoClicksLeft  = errors.New("no clicks left")
+)
+
 var _ Storage = &InMemoryStorage{}
 var _ Storage = &FileStorage{}
 var _ Storage = &dbStorage{}
+var _ Storage = &ReplicatedStorage{}
 
 type Storage interface {
 	LookUp(ctx context.Context, str string) (string, error)
 	Insert(ctx context.Context, key, value, userID string) error
+	//InsertLink сохраняет ссылку со всеми её параметрами,
+	//ErrAlreadyExists если ссылка с таким ID уже есть
+	InsertLink(ctx context.Context, link common.Link) error
+	//GetLink возвращает ссылку со всеми её параметрами, ErrNotFound если её нет
+	GetLink(ctx context.Context, id string) (common.Link, error)
+	//UseClick атомарно уменьшает число оставшихся переходов по ссылке
+	//с ограничением, ErrNoClicksLeft если переходов не осталось.
+	//Для ссылок без ограничения ничего не делает.
+	UseClick(ctx context.Context, id string) error
+	//SetRules заменяет правила перенаправления ссылки
+	SetRules(ctx context.Context, id string, rules []common.RedirectRule) error
+	//SetUTM заменяет UTM-метки ссылки
+	SetUTM(ctx context.Context, id string, utm common.UTM) error
+	//SetPreview сохраняет описание страницы назначения ссылки
+	SetPreview(ctx context.Context, id string, preview common.Preview) error
+	//GetUserUTM возвращает UTM-метки пользователя по умолчанию,
+	//пустые если они не заданы
+	GetUserUTM(ctx context.Context, userID string) (common.UTM, error)
+	SetUserUTM(ctx context.Context, userID string, utm common.UTM) error
+	//CountVariantClick атомарно увеличивает счётчик переходов варианта A/B-теста
+	CountVariantClick(ctx context.Context, id, variant string) error
+	//ExistingIDs возвращает те из ids (без ведущего "/"), что уже сохранены.
+	//Читает с основного хранилища одним запросом.
+	ExistingIDs(ctx context.Context, ids []string) (map[string]bool, error)
+	//InsertSome сохраняет ссылки пользователя, уже существующие пропускаются
 	InsertSome(ctx context.Context, expandURLwIDslice []common.PairURL, userID string) error
 	GetPairsByID(ctx context.Context, userID string) ([]common.PairURL, error)
+	//InsertLinks сохраняет ссылки вместе с владельцами, уже существующие пропускаются
+	InsertLinks(ctx context.Context, links []common.Link) error
+	//GetLinks возвращает до limit ссылок с ID больше afterID в порядке возрастания ID
+	GetLinks(ctx context.Context, afterID string, limit int) ([]common.Link, error)
+	//InsertAPIKey сохраняет API-ключ, ErrAlreadyExists если ключ с таким хешем уже есть
+	InsertAPIKey(ctx context.Context, key common.APIKey) error
+	//GetAPIKey возвращает API-ключ по хешу, ErrNotFound если ключа нет или он отозван
+	GetAPIKey(ctx context.Context, hash string) (common.APIKey, error)
+	//GetAPIKeys возвращает API-ключи пользователя в порядке создания
+	GetAPIKeys(ctx context.Context, userID string) ([]common.APIKey, error)
+	//DeleteAPIKey отзывает ключ id пользователя, ErrNotFound если такого ключа нет
+	DeleteAPIKey(ctx context.Context, userID, id string) error
+	//InsertAccount сохраняет учётную запись, ErrAlreadyExists если email занят
+	InsertAccount(ctx context.Context, account common.Account) error
+	//GetAccount возвращает учётную запись по ID, ErrNotFound если её нет
+	GetAccount(ctx context.Context, id string) (common.Account, error)
+	//GetAccountByEmail возвращает учётную запись по email, ErrNotFound если её нет
+	GetAccountByEmail(ctx context.Context, email string) (common.Account, error)
+	//ReassignLinks передаёт все ссылки пользователя fromUserID пользователю
+	//toUserID и возвращает их число
+	ReassignLinks(ctx context.Context, fromUserID, toUserID string) (int, error)
+	//InsertWorkspace сохраняет рабочее пространство, ErrAlreadyExists если ID занят
+	InsertWorkspace(ctx context.Context, workspace common.Workspace) error
+	//GetWorkspace возвращает рабочее пространство, ErrNotFound если его нет
+	GetWorkspace(ctx context.Context, id string) (common.Workspace, error)
+	//SetMember добавляет участника рабочего пространства или меняет его роль
+	SetMember(ctx context.Context, member common.Member) error
+	//GetMember возвращает участие пользователя в рабочем пространстве,
+	//ErrNotFound если пользователь в нём не состоит
+	GetMember(ctx context.Context, workspaceID, userID string) (common.Member, error)
+	//GetMembers возвращает участников рабочего пространства в порядке их ID
+	GetMembers(ctx context.Context, workspaceID string) ([]common.Member, error)
+	//GetMemberships возвращает участие пользователя во всех рабочих
+	//пространствах в порядке их ID
+	GetMemberships(ctx context.Context, userID string) ([]common.Member, error)
+	//DeleteMember исключает пользователя из рабочего пространства,
+	//ErrNotFound если он в нём не состоит
+	DeleteMember(ctx context.Context, workspaceID, userID string) error
+	//GetPairsByWorkspace возвращает ссылки рабочего пространства
+	GetPairsByWorkspace(ctx context.Context, workspaceID string) ([]common.PairURL, error)
+	//AppendAudit дописывает запись в журнал аудита и возвращает её ID.
+	//Ненулевой record.ID сохраняется, иначе ID назначает хранилище
+	AppendAudit(ctx context.Context, record common.AuditRecord) (int64, error)
+	//GetAudit возвращает до filter.Limit записей журнала аудита, начиная с новых
+	GetAudit(ctx context.Context, filter common.AuditFilter) ([]common.AuditRecord, error)
+	//SearchLinks возвращает до filter.Limit ссылок всех пользователей,
+	//подходящих под фильтр, с ID больше filter.AfterID в порядке возрастания ID
+	SearchLinks(ctx context.Context, filter common.LinkFilter) ([]common.Link, error)
+	//SetDisabled блокирует ссылку или, если block равен nil, снимает блокировку
+	SetDisabled(ctx context.Context, id string, block *common.LinkBlock) error
+	//DisableByDomain блокирует все работающие ссылки на домен и его поддомены
+	//и возвращает их ID. domain должен быть в нижнем регистре.
+	DisableByDomain(ctx context.Context, domain string, block common.LinkBlock) ([]string, error)
+	//CountByUser возвращает число ссылок пользователя
+	CountByUser(ctx context.Context, userID string) (int, error)
+	//CountURLs возвращает число всех сокращённых ссылок
+	CountURLs(ctx context.Context) (int, error)
+	//CountUsers возвращает число пользователей, у которых есть ссылки
+	CountUsers(ctx context.Context) (int, error)
+	//HealthCheck возвращает ошибку, если хранилище не может обслуживать запросы
+	HealthCheck(ctx context.Context) error
 }
 
-func CreateStorage(cfg config.Config) (Storage, error) {
-	if cfg.DatabaseDSN == config.DefaultDatabaseDSN {
+//CreateStorage выбирает хранилище по конфигурации. pool равен nil,
+//если база данных не настроена. Если заданы пути зеркал, записи
+//дублируются в файловые хранилища по этим путям.
+func CreateStorage(cfg config.Config, pool *DBPool) (Storage, error) {
+	primary, err := createPrimaryStorage(cfg, pool)
+	if err != nil || len(cfg.MirrorStoragePaths) == 0 {
+		return primary, err
+	}
+
+	secondaries := make([]Storage, 0, len(cfg.MirrorStoragePaths))
+	for _, path := range cfg.MirrorStoragePaths {
+		fs, err := NewFileStorage(path)
+		if err != nil {
+			return nil, err
+		}
+		secondaries = append(secondaries, fs)
+	}
+	return NewReplicatedStorage(primary, secondaries, cfg.MirrorStoragePaths, MirrorOptions{
+		QueueSize:  cfg.MirrorQueueSize,
+		Retries:    cfg.MirrorRetries,
+		RetryDelay: cfg.MirrorRetryDelay,
+		MaxLag:     cfg.MirrorMaxLag,
+	}), nil
+}
+
+func createPrimaryStorage(cfg config.Config, pool *DBPool) (Storage, error) {
+	if pool == nil {
 		if cfg.FileStoragePath == config.DefaultFileStoragePath {
 			return NewInMemoryStorage()
 		} else {
 			return NewFileStorage(cfg.FileStoragePath)
 		}
 	} else {
-		return NewDBStorage(cfg.DatabaseDSN)
+		return NewDBStorage(pool)
+	}
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+//OpenStorage открывает хранилище по URL вида file:///path/to/file.json,
+//memory:// или postgres://... Возвращаемый io.Closer освобождает ресурсы хранилища.
+func OpenStorage(rawURL string, cfg config.Config) (Storage, io.Closer, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		path := u.Path
+		if u.Host != "" {
+			path = u.Host + u.Path
+		}
+		fs, err := NewFileStorage(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return fs, fs, nil
+	case "memory":
+		ims, err := NewInMemoryStorage()
+		return ims, nopCloser{}, err
+	case "postgres", "postgresql":
+		cfg.DatabaseDSN = rawURL
+		cfg.DatabaseReplicaDSN = config.DefaultDatabaseReplicaDSN
+		pool, err := OpenDBPool(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		dbs, err := NewDBStorage(pool)
+		if err != nil {
+			pool.Close()
+			return nil, nil, err
+		}
+		return dbs, pool, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported storage scheme %q", u.Scheme)
 	}
 }
@@ -2,9 +2,23 @@ package storages
 
 import (
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/sandor-clegane/urlshortener/internal/common"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestLookUp(t *testing.T) {
@@ -84,8 +98,499 @@ func TestInsert(t *testing.T) {
 
 			for i, p := range tt.storage {
 				s.Insert(context.Background(), p.first, p.second, "some_user")
-				assert.Equal(t, tt.want.values[i], s.storage[p.first])
+				assert.Equal(t, tt.want.values[i], s.storage[p.first].ExpandURL)
 			}
 		})
 	}
 }
+
+func TestCanceledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s, _ := NewInMemoryStorage()
+	s.Insert(context.Background(), "id1", "http://ya.ru", "some_user")
+
+	_, err := s.LookUp(ctx, "id1")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	err = s.Insert(ctx, "id2", "http://yandex.ru", "some_user")
+	assert.ErrorIs(t, err, context.Canceled)
+	_, isExists := s.storage["id2"]
+	assert.False(t, isExists)
+
+	_, err = s.GetPairsByID(ctx, "some_user")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	path := filepath.Join(t.TempDir(), "storage.json")
+	fs, err := NewFileStorage(path)
+	require.NoError(t, err)
+	batch := []common.PairURL{{ShortURL: "/id1", ExpandURL: "http://ya.ru/1"},
+		{ShortURL: "/id2", ExpandURL: "http://ya.ru/2"}, {ShortURL: "/id3", ExpandURL: "http://ya.ru/3"}}
+	assert.ErrorIs(t, fs.InsertSome(ctx, batch, "some_user"), context.Canceled)
+	//запрос отменён после записи первой ссылки пачки
+	err = fs.InsertSome(&cancelAfter{Context: context.Background(), checks: 2}, batch, "some_user")
+	assert.ErrorIs(t, err, context.Canceled)
+	require.NoError(t, fs.Close())
+
+	fs, err = NewFileStorage(path)
+	require.NoError(t, err)
+	defer fs.Close()
+	pairs, err := fs.GetPairsByID(context.Background(), "some_user")
+	require.NoError(t, err)
+	assert.Equal(t, []common.PairURL{{ShortURL: "id1", ExpandURL: "http://ya.ru/1"}}, pairs)
+}
+
+//cancelAfter считается отменённым после checks вызовов Err
+type cancelAfter struct {
+	context.Context
+	checks int32
+}
+
+func (c *cancelAfter) Err() error {
+	if atomic.AddInt32(&c.checks, -1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+type failingStorage struct {
+	Storage
+}
+
+func (failingStorage) LookUp(context.Context, string) (string, error) {
+	return "", errors.New("connection refused")
+}
+
+func TestReplicatedStorage(t *testing.T) {
+	primary, _ := NewInMemoryStorage()
+	secondary, _ := NewInMemoryStorage()
+	rs := NewReplicatedStorage(primary, []Storage{secondary}, []string{"secondary"},
+		MirrorOptions{QueueSize: 16})
+
+	err := rs.Insert(context.Background(), "id1", "http://ya.ru", "some_user")
+	assert.NoError(t, err)
+	err = rs.Insert(context.Background(), "id1", "http://ya.ru", "some_user")
+	assert.ErrorIs(t, err, ErrAlreadyExists)
+
+	//Close дожидается применения очереди зеркала
+	assert.NoError(t, rs.Close())
+	gotValue, err := secondary.LookUp(context.Background(), "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", gotValue)
+
+	failover := NewReplicatedStorage(failingStorage{}, []Storage{secondary}, []string{"secondary"},
+		MirrorOptions{QueueSize: 16})
+	defer failover.Close()
+	gotValue, err = failover.LookUp(context.Background(), "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", gotValue)
+}
+
+func TestExistingIDs(t *testing.T) {
+	ctx := context.Background()
+	primary, _ := NewInMemoryStorage()
+	secondary, _ := NewInMemoryStorage()
+	require.NoError(t, primary.Insert(ctx, "/id1", "http://ya.ru", "user"))
+	//на зеркале ссылка есть, но решает основное хранилище
+	require.NoError(t, secondary.Insert(ctx, "/id2", "http://ya.ru/2", "user"))
+	rs := NewReplicatedStorage(primary, []Storage{secondary}, []string{"secondary"},
+		MirrorOptions{QueueSize: 16})
+	defer rs.Close()
+
+	existing, err := rs.ExistingIDs(ctx, []string{"/id1", "id2", "id3"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{"id1": true}, existing)
+}
+
+func TestReplicatedStorageAuditIDs(t *testing.T) {
+	ctx := context.Background()
+	primary, _ := NewInMemoryStorage()
+	secondary, _ := NewInMemoryStorage()
+	//на основном хранилище уже есть записи, которых нет на зеркале
+	_, err := primary.AppendAudit(ctx, common.AuditRecord{ActorID: "alice", Action: common.AuditCreate})
+	assert.NoError(t, err)
+	rs := NewReplicatedStorage(primary, []Storage{secondary}, []string{"secondary"},
+		MirrorOptions{QueueSize: 16})
+	id, err := rs.AppendAudit(ctx, common.AuditRecord{ActorID: "bob", Action: common.AuditReassign})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), id)
+	assert.NoError(t, rs.Close())
+
+	records, err := secondary.GetAudit(ctx, common.AuditFilter{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []common.AuditRecord{{ID: 2, ActorID: "bob", Action: common.AuditReassign}}, records)
+}
+
+func TestFileStorageKeepsLinkOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	fs, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	link := common.Link{ID: "id1", ExpandURL: "http://ya.ru", UserID: "some_user",
+		PasswordHash: "hash", ClickLimit: 3, ClicksLeft: 2, UTM: common.UTM{Campaign: "spring"},
+		Preview: common.Preview{Title: "Yandex"}}
+	assert.NoError(t, fs.InsertLink(context.Background(), link))
+	utm := common.UTM{Source: "newsletter"}
+	assert.NoError(t, fs.SetUserUTM(context.Background(), "some_user", utm))
+	assert.NoError(t, fs.Close())
+
+	fs, err = NewFileStorage(path)
+	assert.NoError(t, err)
+	defer fs.Close()
+	got, err := fs.GetLink(context.Background(), "/id1")
+	assert.NoError(t, err)
+	assert.Equal(t, link, got)
+	gotUTM, err := fs.GetUserUTM(context.Background(), "some_user")
+	assert.NoError(t, err)
+	assert.Equal(t, utm, gotUTM)
+}
+
+func TestUseClickConcurrent(t *testing.T) {
+	s, _ := NewInMemoryStorage()
+	link := common.Link{ID: "id1", ExpandURL: "http://ya.ru", ClickLimit: 5, ClicksLeft: 5}
+	assert.NoError(t, s.InsertLink(context.Background(), link))
+
+	var wg sync.WaitGroup
+	var used int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if s.UseClick(context.Background(), "id1") == nil {
+				atomic.AddInt32(&used, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(5), used)
+
+	err := s.UseClick(context.Background(), "id1")
+	assert.ErrorIs(t, err, ErrNoClicksLeft)
+}
+
+func TestFileStorageAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	fs, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	kept := common.APIKey{ID: "k1", UserID: "some_user", Prefix: "sk_1", Hash: "h1",
+		Scopes: []string{common.ScopeRead}, CreatedAt: time.Unix(1, 0).UTC()}
+	revoked := common.APIKey{ID: "k2", UserID: "some_user", Prefix: "sk_2", Hash: "h2",
+		CreatedAt: time.Unix(2, 0).UTC()}
+	assert.NoError(t, fs.InsertAPIKey(ctx, kept))
+	assert.NoError(t, fs.InsertAPIKey(ctx, revoked))
+	assert.ErrorIs(t, fs.InsertAPIKey(ctx, kept), ErrAlreadyExists)
+	assert.NoError(t, fs.DeleteAPIKey(ctx, "some_user", "k2"))
+	assert.NoError(t, fs.Close())
+
+	fs, err = NewFileStorage(path)
+	assert.NoError(t, err)
+	defer fs.Close()
+	got, err := fs.GetAPIKey(ctx, "h1")
+	assert.NoError(t, err)
+	assert.Equal(t, kept, got)
+	_, err = fs.GetAPIKey(ctx, "h2")
+	assert.ErrorIs(t, err, ErrNotFound)
+	keys, err := fs.GetAPIKeys(ctx, "some_user")
+	assert.NoError(t, err)
+	assert.Equal(t, []common.APIKey{kept}, keys)
+}
+
+func TestFileStorageAccounts(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	fs, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	account := common.Account{ID: "acc", Email: "user@example.com", PasswordHash: "hash",
+		CreatedAt: time.Unix(1, 0).UTC()}
+	assert.NoError(t, fs.InsertAccount(ctx, account))
+	assert.ErrorIs(t, fs.InsertAccount(ctx, common.Account{ID: "other", Email: account.Email}),
+		ErrAlreadyExists)
+	assert.NoError(t, fs.Insert(ctx, "id1", "http://ya.ru", "anonymous"))
+	assert.NoError(t, fs.Insert(ctx, "id2", "http://ya.ru/2", "anonymous"))
+	n, err := fs.ReassignLinks(ctx, "anonymous", account.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.NoError(t, fs.Close())
+
+	fs, err = NewFileStorage(path)
+	assert.NoError(t, err)
+	defer fs.Close()
+	got, err := fs.GetAccountByEmail(ctx, account.Email)
+	assert.NoError(t, err)
+	assert.Equal(t, account, got)
+	_, err = fs.GetAccount(ctx, "other")
+	assert.ErrorIs(t, err, ErrNotFound)
+	count, err := fs.CountByUser(ctx, account.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	count, err = fs.CountByUser(ctx, "anonymous")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestFileStorageWorkspaces(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	fs, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	ws := common.Workspace{ID: "ws", Name: "team", CreatedAt: time.Unix(1, 0).UTC()}
+	owner := common.Member{WorkspaceID: "ws", UserID: "alice", Role: common.RoleOwner}
+	assert.NoError(t, fs.InsertWorkspace(ctx, ws))
+	assert.ErrorIs(t, fs.InsertWorkspace(ctx, ws), ErrAlreadyExists)
+	assert.NoError(t, fs.SetMember(ctx, owner))
+	assert.NoError(t, fs.SetMember(ctx, common.Member{WorkspaceID: "ws", UserID: "bob", Role: common.RoleViewer}))
+	assert.NoError(t, fs.DeleteMember(ctx, "ws", "bob"))
+	assert.ErrorIs(t, fs.DeleteMember(ctx, "ws", "bob"), ErrNotFound)
+	link := common.Link{ID: "id1", ExpandURL: "http://ya.ru", UserID: "alice", WorkspaceID: "ws"}
+	assert.NoError(t, fs.InsertLink(ctx, link))
+	assert.NoError(t, fs.Close())
+
+	fs, err = NewFileStorage(path)
+	assert.NoError(t, err)
+	defer fs.Close()
+	gotWS, err := fs.GetWorkspace(ctx, "ws")
+	assert.NoError(t, err)
+	assert.Equal(t, ws, gotWS)
+	members, err := fs.GetMembers(ctx, "ws")
+	assert.NoError(t, err)
+	assert.Equal(t, []common.Member{owner}, members)
+	_, err = fs.GetMember(ctx, "ws", "bob")
+	assert.ErrorIs(t, err, ErrNotFound)
+	pairs, err := fs.GetPairsByWorkspace(ctx, "ws")
+	assert.NoError(t, err)
+	assert.Equal(t, []common.PairURL{{ShortURL: "id1", ExpandURL: "http://ya.ru"}}, pairs)
+}
+
+func TestFileStorageAudit(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	fs, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	first := common.AuditRecord{Time: time.Unix(1, 0).UTC(), ActorID: "alice", Action: common.AuditCreate,
+		LinkID: "id1", NewValue: json.RawMessage(`{"id":"id1"}`), RequestID: "req-1"}
+	id, err := fs.AppendAudit(ctx, first)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), id)
+	_, err = fs.AppendAudit(ctx, common.AuditRecord{Time: time.Unix(2, 0).UTC(), ActorID: "bob",
+		Action: common.AuditReassign, LinkID: "id1"})
+	assert.NoError(t, err)
+	assert.NoError(t, fs.Close())
+
+	fs, err = NewFileStorage(path)
+	assert.NoError(t, err)
+	defer fs.Close()
+	_, err = fs.AppendAudit(ctx, common.AuditRecord{Time: time.Unix(3, 0).UTC(), ActorID: "alice",
+		Action: common.AuditUpdateUTM, LinkID: "id2"})
+	assert.NoError(t, err)
+	records, err := fs.GetAudit(ctx, common.AuditFilter{LinkID: "id1", Limit: 10})
+	assert.NoError(t, err)
+	first.ID = 1
+	assert.Equal(t, []common.AuditRecord{
+		{ID: 2, Time: time.Unix(2, 0).UTC(), ActorID: "bob", Action: common.AuditReassign, LinkID: "id1"},
+		first,
+	}, records)
+	records, err = fs.GetAudit(ctx, common.AuditFilter{Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), records[0].ID)
+}
+
+func TestFileStorageDisabledLinks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	fs, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	assert.NoError(t, fs.InsertLink(ctx, common.Link{ID: "id1", ExpandURL: "http://www.ya.ru/a", UserID: "u1"}))
+	assert.NoError(t, fs.InsertLink(ctx, common.Link{ID: "id2", ExpandURL: "http://ya.ru.evil.com", UserID: "u1"}))
+	block := common.LinkBlock{Legal: true, Reason: "court order", Time: time.Unix(1, 0).UTC()}
+	ids, err := fs.DisableByDomain(ctx, "ya.ru", block)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"id1"}, ids)
+	assert.ErrorIs(t, fs.SetDisabled(ctx, "missing", &block), ErrNotFound)
+	assert.NoError(t, fs.Close())
+
+	fs, err = NewFileStorage(path)
+	assert.NoError(t, err)
+	defer fs.Close()
+	link, err := fs.GetLink(ctx, "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, &block, link.Disabled)
+	ids, err = fs.DisableByDomain(ctx, "ya.ru", block)
+	assert.NoError(t, err)
+	assert.Empty(t, ids)
+	assert.NoError(t, fs.SetDisabled(ctx, "/id1", nil))
+	links, err := fs.SearchLinks(ctx, common.LinkFilter{Domain: "ya.ru", Limit: 10})
+	assert.NoError(t, err)
+	assert.Len(t, links, 1)
+	assert.Nil(t, links[0].Disabled)
+}
+
+func TestCountURLsAndUsers(t *testing.T) {
+	ctx := context.Background()
+	ims, err := NewInMemoryStorage()
+	assert.NoError(t, err)
+	assert.NoError(t, ims.Insert(ctx, "id1", "http://ya.ru", "u1"))
+	assert.NoError(t, ims.Insert(ctx, "id2", "http://ya.ru/2", "u1"))
+	assert.NoError(t, ims.Insert(ctx, "id3", "http://ya.ru/3", "u2"))
+	_, err = ims.ReassignLinks(ctx, "u2", "u1")
+	assert.NoError(t, err)
+
+	urls, err := ims.CountURLs(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, urls)
+	users, err := ims.CountUsers(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, users)
+}
+
+func TestFileStorageLongURL(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	fs, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	longURL := "http://ya.ru/" + strings.Repeat("a", 2048-len("http://ya.ru/"))
+	assert.NoError(t, fs.Insert(ctx, "id1", longURL, "u1"))
+	assert.NoError(t, fs.Close())
+
+	fs, err = NewFileStorage(path)
+	assert.NoError(t, err)
+	defer fs.Close()
+	got, err := fs.LookUp(ctx, "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, longURL, got)
+}
+
+func TestFileStorageClicks(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.json")
+	fs, err := NewFileStorage(path)
+	assert.NoError(t, err)
+	link := common.Link{ID: "id1", ExpandURL: "http://ya.ru", UserID: "u1", PasswordHash: "hash",
+		ClickLimit: 3, ClicksLeft: 3, Variants: []common.Variant{{Name: "a", Target: "http://a.ru", Weight: 1}}}
+	assert.NoError(t, fs.InsertLink(ctx, link))
+	assert.NoError(t, fs.UseClick(ctx, "/id1"))
+	assert.NoError(t, fs.UseClick(ctx, "id1"))
+	assert.NoError(t, fs.CountVariantClick(ctx, "id1", "a"))
+	assert.ErrorIs(t, fs.CountVariantClick(ctx, "id1", "b"), ErrNotFound)
+	assert.NoError(t, fs.Close())
+
+	//переходы записываются короткими записями без данных ссылки
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), `"password_hash"`))
+
+	fs, err = NewFileStorage(path)
+	assert.NoError(t, err)
+	defer fs.Close()
+	got, err := fs.GetLink(ctx, "id1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, got.ClicksLeft)
+	assert.Equal(t, int64(1), got.Variants[0].Clicks)
+}
+
+//fakeDB отвечает на любой запрос строками rows или ошибкой err
+//и считает запросы, чтобы проверить, куда пул направил чтение
+type fakeDB struct {
+	rows    [][]driver.Value
+	err     error
+	queries int32
+}
+
+var fakeDBs sync.Map
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	db, _ := fakeDBs.Load(name)
+	return fakeConn{db.(*fakeDB)}, nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt(c), nil }
+func (fakeConn) Close() error                          { return nil }
+func (fakeConn) Begin() (driver.Tx, error)             { return nil, errors.New("not supported") }
+
+type fakeStmt struct{ db *fakeDB }
+
+func (fakeStmt) Close() error  { return nil }
+func (fakeStmt) NumInput() int { return -1 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("not supported")
+}
+func (s fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	atomic.AddInt32(&s.db.queries, 1)
+	if s.db.err != nil {
+		return nil, s.db.err
+	}
+	return &fakeRows{rows: s.db.rows}, nil
+}
+
+type fakeRows struct{ rows [][]driver.Value }
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return []string{"value"}
+	}
+	return make([]string, len(r.rows[0]))
+}
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func init() {
+	sql.Register("fakedb", fakeDriver{})
+}
+
+func openFakeDB(t *testing.T, db *fakeDB) *sql.DB {
+	name := fmt.Sprintf("%s/%p", t.Name(), db)
+	fakeDBs.Store(name, db)
+	conn, err := sql.Open("fakedb", name)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestDBPoolReads(t *testing.T) {
+	ctx := context.Background()
+	newStorage := func(primary, replica *fakeDB) *dbStorage {
+		pool := &DBPool{Primary: openFakeDB(t, primary), Replica: openFakeDB(t, replica), replicaOK: 1}
+		return &dbStorage{dbConnection: pool.Primary, pool: pool}
+	}
+
+	//отставшая реплика ещё не знает о ссылке: чтение повторяется на основной
+	//базе, а реплика остаётся в работе
+	primary := &fakeDB{rows: [][]driver.Value{{"http://ya.ru"}}}
+	replica := &fakeDB{}
+	d := newStorage(primary, replica)
+	got, err := d.LookUp(ctx, "/id1")
+	require.NoError(t, err)
+	assert.Equal(t, "http://ya.ru", got)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&replica.queries))
+	assert.Equal(t, d.pool.Replica, d.pool.Reader())
+
+	//отказ реплики выводит её из чтения
+	replica = &fakeDB{err: errors.New("connection refused")}
+	d = newStorage(primary, replica)
+	_, err = d.LookUp(ctx, "/id1")
+	require.NoError(t, err)
+	assert.Equal(t, d.pool.Primary, d.pool.Reader())
+
+	//список ссылок пользователя читается только с основной базы
+	primary = &fakeDB{rows: [][]driver.Value{{"/id1", "http://ya.ru"}}}
+	replica = &fakeDB{}
+	d = newStorage(primary, replica)
+	pairs, err := d.GetPairsByID(ctx, "user")
+	require.NoError(t, err)
+	assert.Equal(t, []common.PairURL{{ShortURL: "/id1", ExpandURL: "http://ya.ru"}}, pairs)
+	pairs, err = d.GetPairsByWorkspace(ctx, "ws")
+	require.NoError(t, err)
+	assert.Len(t, pairs, 1)
+	assert.Zero(t, atomic.LoadInt32(&replica.queries))
+}